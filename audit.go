@@ -0,0 +1,189 @@
+package docker
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types/container"
+)
+
+// AuditEventType is the type of Docker operation recorded in an audit event.
+type AuditEventType string
+
+const (
+	// AuditEventImagePull is emitted when an image has been pulled.
+	AuditEventImagePull AuditEventType = "image_pull"
+	// AuditEventContainerCreate is emitted when a container has been created.
+	AuditEventContainerCreate AuditEventType = "container_create"
+	// AuditEventExecCreate is emitted when an exec has been created in a container.
+	AuditEventExecCreate AuditEventType = "exec_create"
+	// AuditEventSignal is emitted when a signal has been sent to a process or container.
+	AuditEventSignal AuditEventType = "signal"
+	// AuditEventResize is emitted when a terminal has been resized.
+	AuditEventResize AuditEventType = "resize"
+	// AuditEventContainerCommit is emitted when a container has been committed to an image.
+	AuditEventContainerCommit AuditEventType = "container_commit"
+	// AuditEventContainerRemove is emitted when a container has been removed.
+	AuditEventContainerRemove AuditEventType = "container_remove"
+)
+
+// AuditEvent is a single Docker operation performed on behalf of an SSH user.
+type AuditEvent struct {
+	// Timestamp is the time the operation finished.
+	Timestamp time.Time `json:"timestamp"`
+	// Type is the type of the operation.
+	Type AuditEventType `json:"type"`
+	// ConnectionID is the unique ID of the SSH connection.
+	ConnectionID string `json:"connectionId"`
+	// Username is the username the user authenticated with.
+	Username string `json:"username"`
+	// RemoteAddress is the IP address of the SSH client.
+	RemoteAddress string `json:"remoteAddress"`
+
+	// Image is the image name for image pulls, container creation, and commits.
+	Image string `json:"image,omitempty"`
+	// ImageID is the ID of the committed image.
+	ImageID string `json:"imageId,omitempty"`
+	// ImageDigest is the digest of the pulled image.
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// ContainerID is the ID of the container the operation was performed on.
+	ContainerID string `json:"containerId,omitempty"`
+	// ContainerConfig is the effective container configuration on container creation. Environment variables only
+	// contain the variable names.
+	ContainerConfig *container.Config `json:"containerConfig,omitempty"`
+	// HostConfig is the effective host configuration on container creation.
+	HostConfig *container.HostConfig `json:"hostConfig,omitempty"`
+	// ExecID is the ID of the created exec.
+	ExecID string `json:"execId,omitempty"`
+	// Program is the program executed in the exec.
+	Program []string `json:"program,omitempty"`
+	// EnvKeys contains the names of the environment variables passed to the exec.
+	EnvKeys []string `json:"envKeys,omitempty"`
+	// User is the user the exec runs as. Empty if the exec runs as the container user.
+	User string `json:"user,omitempty"`
+	// TTY indicates if the exec has a TTY.
+	TTY *bool `json:"tty,omitempty"`
+	// Signal is the name of the signal sent.
+	Signal string `json:"signal,omitempty"`
+	// Width is the new terminal width.
+	Width uint `json:"width,omitempty"`
+	// Height is the new terminal height.
+	Height uint `json:"height,omitempty"`
+}
+
+// AuditSink receives audit events. Implementations must be safe for concurrent use.
+type AuditSink interface {
+	// Write records a single audit event.
+	Write(event AuditEvent) error
+}
+
+// auditLogger emits audit events for a single connection. A nil auditLogger discards all events.
+type auditLogger struct {
+	connectionID  string
+	username      string
+	remoteAddress string
+	sinks         []AuditSink
+	logger        log.Logger
+}
+
+func newAuditLogger(
+	config AuditConfig,
+	connectionID string,
+	username string,
+	remoteAddress string,
+	logger log.Logger,
+) *auditLogger {
+	if !config.Enable {
+		return nil
+	}
+	var sinks []AuditSink
+	if config.File != "" {
+		sinks = append(sinks, getFileAuditSink(config.File))
+	}
+	if config.Sink != nil {
+		sinks = append(sinks, config.Sink)
+	}
+	return &auditLogger{
+		connectionID:  connectionID,
+		username:      username,
+		remoteAddress: remoteAddress,
+		sinks:         sinks,
+		logger:        logger,
+	}
+}
+
+func (a *auditLogger) emit(event AuditEvent) {
+	if a == nil {
+		return
+	}
+	event.Timestamp = time.Now()
+	event.ConnectionID = a.connectionID
+	event.Username = a.username
+	event.RemoteAddress = a.remoteAddress
+	for _, sink := range a.sinks {
+		if err := sink.Write(event); err != nil {
+			a.logger.Error(log.Wrap(err, EFailedAuditWrite, "failed to write %s audit event", event.Type))
+		}
+	}
+}
+
+// envKeys returns the sorted names of the environment variables in the KEY=VALUE list.
+func envKeys(env []string) []string {
+	keys := make([]string, 0, len(env))
+	for _, item := range env {
+		keys = append(keys, strings.SplitN(item, "=", 2)[0])
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fileAuditSinks holds the open audit log files so connections writing to the same file share a handle.
+var fileAuditSinks = struct {
+	lock  *sync.Mutex
+	sinks map[string]*fileAuditSink
+}{
+	lock:  &sync.Mutex{},
+	sinks: map[string]*fileAuditSink{},
+}
+
+func getFileAuditSink(file string) *fileAuditSink {
+	fileAuditSinks.lock.Lock()
+	defer fileAuditSinks.lock.Unlock()
+	sink, ok := fileAuditSinks.sinks[file]
+	if !ok {
+		sink = &fileAuditSink{
+			file: file,
+			lock: &sync.Mutex{},
+		}
+		fileAuditSinks.sinks[file] = sink
+	}
+	return sink
+}
+
+// fileAuditSink appends audit events to a file in JSON lines format. The file is opened on the first write.
+type fileAuditSink struct {
+	file string
+	lock *sync.Mutex
+	fh   *os.File
+}
+
+func (f *fileAuditSink) Write(event AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.fh == nil {
+		if f.fh, err = os.OpenFile(f.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+			return err
+		}
+	}
+	_, err = f.fh.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"context"
+	"io"
+
+	"github.com/containerssh/log"
+)
+
+// builtinProgram is a program implemented in ContainerSSH. It returns the exit status and should return when the
+// context is cancelled.
+type builtinProgram func(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer) int
+
+// newBuiltinExecution creates a dockerExecution that runs a program implemented in ContainerSSH instead of in the
+// container.
+func newBuiltinExecution(program builtinProgram) dockerExecution {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &builtinExecution{
+		program:  program,
+		ctx:      ctx,
+		cancel:   cancel,
+		doneChan: make(chan struct{}),
+	}
+}
+
+type builtinExecution struct {
+	program  builtinProgram
+	ctx      context.Context
+	cancel   func()
+	doneChan chan struct{}
+}
+
+func (b *builtinExecution) resize(_ context.Context, _ uint, _ uint) error {
+	return log.UserMessage(
+		EFailedResize,
+		"Cannot resize window.",
+		"cannot resize window, the program is built into ContainerSSH",
+	)
+}
+
+func (b *builtinExecution) signal(_ context.Context, sig string) error {
+	return log.UserMessage(
+		EFailedExecSignal,
+		"Cannot send signal to process.",
+		"cannot send signal %s, the program is built into ContainerSSH",
+		sig,
+	).Label("signal", sig)
+}
+
+func (b *builtinExecution) record(_ sessionRecorder) {}
+
+func (b *builtinExecution) run(
+	stdin io.Reader,
+	stdout io.Writer,
+	stderr io.Writer,
+	writeClose func() error,
+	onExit func(exitStatus int),
+) {
+	go func() {
+		exitStatus := b.program(b.ctx, stdin, stdout, stderr)
+		_ = writeClose()
+		close(b.doneChan)
+		onExit(exitStatus)
+	}()
+}
+
+func (b *builtinExecution) done() <-chan struct{} {
+	return b.doneChan
+}
+
+func (b *builtinExecution) term(_ context.Context) {
+	b.cancel()
+}
+
+func (b *builtinExecution) kill() {
+	b.cancel()
+}
@@ -185,3 +185,209 @@ const EProgramExecutionDisabled = "DOCKERRUN_EXEC_DISABLED"
 // This message indicates that the user requested an action that can only be performed when
 // a program is running, but there is currently no program running.
 const EProgramNotRunning = "DOCKER_PROGRAM_NOT_RUNNING"
+
+// The ContainerSSH Docker module rejected the requested program, shell, or subsystem because of the configured execution policy.
+const EProgramPolicyDenied = "DOCKER_PROGRAM_POLICY_DENIED"
+
+// The ContainerSSH Docker module is checking if a path exists in the container.
+const MContainerStatPath = "DOCKER_CONTAINER_STAT_PATH"
+
+// The ContainerSSH Docker module failed to check a path in the container. This may be because the path does not
+// exist, or because the Docker daemon could not be reached.
+const EFailedContainerStatPath = "DOCKER_CONTAINER_STAT_PATH_FAILED"
+
+// The ContainerSSH Docker module could not parse the command sent by the client and the program parsing mode is set
+// to reject such commands.
+const EProgramParseFailed = "DOCKER_PROGRAM_PARSE_FAILED"
+
+// The ContainerSSH Docker module is detecting which shell is present in the container image.
+const MShellDetect = "DOCKER_SHELL_DETECT"
+
+// The ContainerSSH Docker module could not find any of the configured shell candidates in the container image and
+// falls back to the configured shell.
+const EShellDetectFailed = "DOCKER_SHELL_DETECT_FAILED"
+
+// The ContainerSSH Docker module is recording an interactive session in the asciicast v2 format.
+const MRecordingStart = "DOCKER_RECORDING_START"
+
+// The ContainerSSH Docker module failed to record a session. The session continues without recording. This may be
+// because the recording directory is not writable, or because the recording reached its configured size limit.
+const EFailedRecording = "DOCKER_RECORDING_FAILED"
+
+// The ContainerSSH Docker module failed to write an audit event to the configured audit file or sink. Check if the
+// audit file is writable.
+const EFailedAuditWrite = "DOCKER_AUDIT_WRITE_FAILED"
+
+// The ContainerSSH Docker module is copying files from the container using the Docker archive API.
+const MContainerCopyFrom = "DOCKER_CONTAINER_COPY_FROM"
+
+// The ContainerSSH Docker module failed to copy files from the container. This may be because the path does not
+// exist or the Docker daemon could not be reached.
+const EFailedContainerCopyFrom = "DOCKER_CONTAINER_COPY_FROM_FAILED"
+
+// The ContainerSSH Docker module is copying files into the container using the Docker archive API.
+const MContainerCopyTo = "DOCKER_CONTAINER_COPY_TO"
+
+// The ContainerSSH Docker module failed to copy files into the container. This may be because the target directory
+// does not exist or the Docker daemon could not be reached.
+const EFailedContainerCopyTo = "DOCKER_CONTAINER_COPY_TO_FAILED"
+
+// The ContainerSSH Docker module failed to run an internal command in the container, for example to perform a file
+// operation.
+const EFailedCommand = "DOCKER_COMMAND_FAILED"
+
+// The built-in SFTP server of the ContainerSSH Docker module is performing a file operation in the container.
+const MSFTPOperation = "DOCKER_SFTP_OPERATION"
+
+// The built-in SFTP server of the ContainerSSH Docker module has encountered an error and ended the SFTP session.
+const EFailedSFTP = "DOCKER_SFTP_FAILED"
+
+// The built-in SCP server of the ContainerSSH Docker module has transferred a file to or from the container.
+const MSCPTransfer = "DOCKER_SCP_TRANSFER"
+
+// The built-in SCP server of the ContainerSSH Docker module could not transfer a file or has ended the SCP session
+// due to an error.
+const EFailedSCP = "DOCKER_SCP_FAILED"
+
+// The ContainerSSH Docker module is copying the configured files into the container before starting it.
+const MSeedFiles = "DOCKER_SEED_FILES"
+
+// The ContainerSSH Docker module failed to copy the configured files into the container. The container will not be
+// started.
+const EFailedSeedFiles = "DOCKER_SEED_FILES_FAILED"
+
+// The ContainerSSH Docker module is exporting the configured paths from the container before removing it.
+const MExport = "DOCKER_EXPORT"
+
+// The ContainerSSH Docker module failed to export files from the container. The container is removed regardless.
+const EFailedExport = "DOCKER_EXPORT_FAILED"
+
+// The ContainerSSH Docker module is committing the container to an image before removing it.
+const MContainerCommit = "DOCKER_CONTAINER_COMMIT"
+
+// The ContainerSSH Docker module has committed the container to an image.
+const MContainerCommitSuccessful = "DOCKER_CONTAINER_COMMIT_SUCCESSFUL"
+
+// The ContainerSSH Docker module failed to commit the container to an image. The container is removed regardless.
+const EFailedContainerCommit = "DOCKER_CONTAINER_COMMIT_FAILED"
+
+// The ContainerSSH Docker module is removing an image committed for the user because more images than configured
+// are kept.
+const MImageRemove = "DOCKER_IMAGE_REMOVE"
+
+// The ContainerSSH Docker module failed to remove an old committed image, for example because a container still uses
+// it. The image is removed on a later commit.
+const EFailedImageRemove = "DOCKER_IMAGE_REMOVE_FAILED"
+
+// The ContainerSSH Docker module is starting the container from the last image committed for the user instead of
+// the configured image.
+const MImageReuse = "DOCKER_IMAGE_REUSE"
+
+// The ContainerSSH Docker module is creating the home volume of the user unless it already exists.
+const MVolumeCreate = "DOCKER_VOLUME_CREATE"
+
+// The ContainerSSH Docker module failed to create the home volume of the user. The connection is rejected.
+const EFailedVolumeCreate = "DOCKER_VOLUME_CREATE_FAILED"
+
+// The ContainerSSH Docker module is creating an isolated network for the connection or user.
+const MNetworkCreate = "DOCKER_NETWORK_CREATE"
+
+// The ContainerSSH Docker module failed to create an isolated network. The connection is rejected.
+const EFailedNetworkCreate = "DOCKER_NETWORK_CREATE_FAILED"
+
+// The ContainerSSH Docker module is connecting a container to an additional network, for example the egress proxy
+// to the egress network.
+const MNetworkConnect = "DOCKER_NETWORK_CONNECT"
+
+// The ContainerSSH Docker module failed to connect a container to a network. The connection is rejected.
+const EFailedNetworkConnect = "DOCKER_NETWORK_CONNECT_FAILED"
+
+// The ContainerSSH Docker module is removing the isolated network of the connection or user.
+const MNetworkRemove = "DOCKER_NETWORK_REMOVE"
+
+// The ContainerSSH Docker module failed to remove an isolated network. Networks shared by the connections of a user
+// cannot be removed while other containers are attached.
+const EFailedNetworkRemove = "DOCKER_NETWORK_REMOVE_FAILED"
+
+// The ContainerSSH Docker module is running a setup command in the container before accepting sessions. The output
+// of the command is included in the labels.
+const MSetupCommand = "DOCKER_SETUP_COMMAND"
+
+// A setup command failed to run or exited with a non-zero status. The connection is rejected with the configured
+// failure message.
+const EFailedSetup = "DOCKER_SETUP_FAILED"
+
+// The ContainerSSH Docker module has determined the user the sessions run as from the SSH username, or is creating
+// that user in the container.
+const MUserMapping = "DOCKER_USER_MAPPING"
+
+// The ContainerSSH Docker module failed to determine or create the user the sessions run as. The connection is
+// rejected.
+const EFailedUserMapping = "DOCKER_USER_MAPPING_FAILED"
+
+// The ContainerSSH Docker module is joining or removing the container shared by the connections of a user in
+// execution mode "user".
+const MUserContainer = "DOCKER_USER_CONTAINER"
+
+// The ContainerSSH Docker module failed to wait for the shared container of the user to be launched by another
+// connection.
+const EFailedUserContainer = "DOCKER_USER_CONTAINER_FAILED"
+
+// The ContainerSSH Docker module is starting, attaching, detaching, or removing a detachable session.
+const MSessionDetach = "DOCKER_SESSION_DETACH"
+
+// The user tried to attach a detachable session that does not exist or has expired.
+const ESessionNotFound = "DOCKER_SESSION_NOT_FOUND"
+
+// The user tried to attach a detachable session that is already attached to another channel.
+const ESessionAttached = "DOCKER_SESSION_ATTACHED"
+
+// The user tried to start a detachable session with a name that is already in use.
+const ESessionExists = "DOCKER_SESSION_EXISTS"
+
+// The ContainerSSH Docker module is draining. Active sessions are notified and closed after the drain timeout.
+const MDrain = "DOCKER_DRAIN"
+
+// The ContainerSSH Docker module has rejected a connection, session or program while draining before a restart.
+const EDraining = "DOCKER_DRAINING"
+
+// The ContainerSSH Docker module is leaving the container running during draining so a new ContainerSSH process can
+// adopt it.
+const MContainerHandover = "DOCKER_CONTAINER_HANDOVER"
+
+// The ContainerSSH Docker module failed to list the containers on the Docker daemon.
+const EFailedContainerList = "DOCKER_CONTAINER_LIST_FAILED"
+
+// The ContainerSSH Docker module is adopting or removing a container left behind by a previous ContainerSSH
+// process.
+const MRecovery = "DOCKER_RECOVERY"
+
+// The ContainerSSH Docker module failed to discover or remove the containers left behind by a previous ContainerSSH
+// process.
+const EFailedRecovery = "DOCKER_RECOVERY_FAILED"
+
+// The ContainerSSH Docker module failed to inspect the state of the container.
+const EFailedContainerInspect = "DOCKER_CONTAINER_INSPECT_FAILED"
+
+// The ContainerSSH Docker module is waiting for the container to become ready before accepting sessions.
+const MReadiness = "DOCKER_READINESS"
+
+// The container did not become ready before the readiness timeout. The connection is rejected with the configured
+// failure message.
+const EFailedReadiness = "DOCKER_READINESS_FAILED"
+
+// The ContainerSSH Docker module has rejected a container because the maximum number of concurrent containers of
+// the user, client IP or Docker host has been reached.
+const ELimitReached = "DOCKER_LIMIT_REACHED"
+
+// The ContainerSSH Docker module has rejected launching a container because the client IP address or the user
+// exceeded the configured rate limit.
+const ERateLimited = "DOCKER_RATE_LIMITED"
+
+// The ContainerSSH Docker module has removed a setting violating the security policy from the launch configuration.
+// Fix the configuration to remove this warning.
+const ESecurityPolicyRewrite = "DOCKER_SECURITY_POLICY_REWRITE"
+
+// The ContainerSSH Docker module has selected a resource profile for the user and applies its limits to the user
+// container.
+const MResourceProfile = "DOCKER_RESOURCE_PROFILE"
@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/containerssh/log"
+)
+
+// commandResult is the outcome of a program run to completion by runCommand.
+type commandResult struct {
+	stdout     []byte
+	stderr     []byte
+	exitStatus int
+}
+
+// runCommand runs a non-interactive program in the container, waits for it to exit, and returns its output. The
+// program receives no input and runs as the specified user, or as the container user if empty.
+func runCommand(
+	ctx context.Context,
+	cnt dockerContainer,
+	program []string,
+	env map[string]string,
+	user string,
+) (commandResult, error) {
+	exec, err := cnt.createExec(ctx, program, env, false, user)
+	if err != nil {
+		return commandResult{}, err
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	stdin, stdinWriter := io.Pipe()
+	done := make(chan int, 1)
+	exec.run(
+		stdin, &stdout, &stderr, func() error {
+			return nil
+		}, func(exitStatus int) {
+			done <- exitStatus
+		},
+	)
+	defer func() {
+		_ = stdinWriter.Close()
+	}()
+	select {
+	case exitStatus := <-done:
+		return commandResult{
+			stdout:     stdout.Bytes(),
+			stderr:     stderr.Bytes(),
+			exitStatus: exitStatus,
+		}, nil
+	case <-ctx.Done():
+		exec.kill()
+		return commandResult{}, log.Wrap(ctx.Err(), EFailedCommand, "timeout while waiting for %s to exit", program[0])
+	}
+}
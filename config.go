@@ -12,6 +12,20 @@ type Config struct {
 	Execution ExecutionConfig `json:"execution,omitempty" yaml:"execution,omitempty"`
 	// Timeouts configures the various timeouts when interacting with dockerd.
 	Timeouts TimeoutConfig `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+	// Recording configures the recording of interactive sessions.
+	Recording RecordingConfig `json:"recording,omitempty" yaml:"recording,omitempty"`
+	// Audit configures the audit trail of the Docker operations performed on behalf of users.
+	Audit AuditConfig `json:"audit,omitempty" yaml:"audit,omitempty"`
+	// Export configures exporting files from the container when the connection ends.
+	Export ExportConfig `json:"export,omitempty" yaml:"export,omitempty"`
+	// Drain configures how connections are closed when the backend is drained before a restart.
+	Drain DrainConfig `json:"drain,omitempty" yaml:"drain,omitempty"`
+	// Recovery configures what happens with the containers of a previous ContainerSSH process on startup.
+	Recovery RecoveryConfig `json:"recovery,omitempty" yaml:"recovery,omitempty"`
+	// Limits configures the maximum number of concurrent containers per user, client IP and Docker host.
+	Limits LimitsConfig `json:"limits,omitempty" yaml:"limits,omitempty"`
+	// RateLimit configures rate limits on launching containers per client IP and username.
+	RateLimit RateLimitConfig `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`
 }
 
 // Validate validates the provided configuration and returns an error if invalid.
@@ -22,5 +36,38 @@ func (c Config) Validate() error {
 	if err := c.Execution.Validate(); err != nil {
 		return log.Wrap(err, EConfigError, "invalid execution configuration")
 	}
+	if err := c.Execution.validateHardening(); err != nil {
+		return log.Wrap(err, EConfigError, "invalid hardening configuration")
+	}
+	if err := c.Audit.Validate(); err != nil {
+		return log.Wrap(err, EConfigError, "invalid audit configuration")
+	}
+	if err := c.Recording.Validate(); err != nil {
+		return log.Wrap(err, EConfigError, "invalid recording configuration")
+	}
+	if err := c.Export.Validate(); err != nil {
+		return log.Wrap(err, EConfigError, "invalid export configuration")
+	}
+	if len(c.Export.Paths) > 0 && c.Execution.Mode == ExecutionModeSession {
+		return log.NewMessage(EConfigError, "exporting files is not supported in execution mode \"session\"")
+	}
+	if err := c.Drain.Validate(); err != nil {
+		return log.Wrap(err, EConfigError, "invalid drain configuration")
+	}
+	if c.Drain.Handover && c.Execution.Mode != ExecutionModeUser {
+		return log.NewMessage(EConfigError, "container handover requires execution mode \"user\"")
+	}
+	if err := c.Recovery.Validate(); err != nil {
+		return log.Wrap(err, EConfigError, "invalid recovery configuration")
+	}
+	if err := c.Limits.Validate(); err != nil {
+		return log.Wrap(err, EConfigError, "invalid limits configuration")
+	}
+	if err := c.RateLimit.Validate(); err != nil {
+		return log.Wrap(err, EConfigError, "invalid rate limit configuration")
+	}
+	if c.Recovery.Policy == RecoveryPolicyAdopt && c.Execution.Mode != ExecutionModeUser {
+		return log.NewMessage(EConfigError, "adopting containers requires execution mode \"user\"")
+	}
 	return nil
 }
@@ -0,0 +1,23 @@
+package docker
+
+import (
+	"fmt"
+)
+
+// AuditConfig configures the audit trail of the Docker operations performed on behalf of SSH users.
+type AuditConfig struct {
+	// Enable turns on emitting audit events.
+	Enable bool `json:"enable" yaml:"enable"`
+	// File is the path of a file audit events are appended to in JSON lines format. Leave empty to not write a file.
+	File string `json:"file,omitempty" yaml:"file,omitempty"`
+	// Sink receives every audit event in addition to File. It can only be set programmatically.
+	Sink AuditSink `json:"-" yaml:"-"`
+}
+
+// Validate checks the audit configuration for errors.
+func (a AuditConfig) Validate() error {
+	if a.Enable && a.File == "" && a.Sink == nil {
+		return fmt.Errorf("audit is enabled but neither a file nor a sink is configured")
+	}
+	return nil
+}
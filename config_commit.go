@@ -0,0 +1,45 @@
+package docker
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// CommitConfig configures committing the container to an image when the connection ends.
+type CommitConfig struct {
+	// Enable commits the container to an image before it is removed. Only supported in execution mode "connection".
+	Enable bool `json:"enable" yaml:"enable"`
+	// Reference is a Go template rendered into the repository:tag of the committed image. The template can reference
+	// {{ .Username }}, {{ .ConnectionID }} and {{ .Timestamp }}. The repository is lowercased and characters Docker
+	// does not accept in references are replaced with dashes.
+	Reference string `json:"reference" yaml:"reference" default:"containerssh-commit/{{ .Username }}:{{ .Timestamp }}"`
+	// Keep is the number of images committed for each user that are kept, older images are removed after a commit.
+	// 0 keeps all images.
+	Keep int `json:"keep" yaml:"keep" default:"5"`
+	// Reuse starts the next connection of the same user from the last image committed for the user instead of the
+	// configured image.
+	Reuse bool `json:"reuse" yaml:"reuse"`
+	// Pause pauses the container while committing.
+	Pause bool `json:"pause" yaml:"pause" default:"true"`
+}
+
+// Validate checks the commit configuration for errors.
+func (c CommitConfig) Validate() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.Reference == "" {
+		return fmt.Errorf("commit is enabled but no reference is configured")
+	}
+	if _, err := template.New("reference").Parse(c.Reference); err != nil {
+		return fmt.Errorf("invalid reference template (%w)", err)
+	}
+	sample := templateData{Username: "user", ConnectionID: "0123456789abcdef", Timestamp: "20060102150405"}
+	if _, err := renderImageReference(c.Reference, sample); err != nil {
+		return fmt.Errorf("invalid reference template (%w)", err)
+	}
+	if c.Keep < 0 {
+		return fmt.Errorf("invalid number of images to keep: %d", c.Keep)
+	}
+	return nil
+}
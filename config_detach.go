@@ -0,0 +1,38 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DetachConfig configures sessions that keep running when the SSH connection is closed and can be reattached later.
+type DetachConfig struct {
+	// Enable enables detachable sessions. Only supported in execution mode "user" since the container must outlive
+	// the connection.
+	Enable bool `json:"enable" yaml:"enable"`
+	// SessionEnv is the environment variable the client sets to name the session. If a detached session with the
+	// same name exists it is reattached, otherwise a new detachable session is started.
+	SessionEnv string `json:"sessionEnv" yaml:"sessionEnv" default:"CONTAINERSSH_SESSION"`
+	// AttachCommand is the command that reattaches a detached session given by name, e.g. "attach mysession".
+	AttachCommand string `json:"attachCommand" yaml:"attachCommand" default:"attach"`
+	// BufferSize is the number of output bytes kept while the session is detached. The most recent output is replayed
+	// when the session is reattached.
+	BufferSize int `json:"bufferSize" yaml:"bufferSize" default:"65536"`
+}
+
+// Validate checks the detachable session configuration for errors.
+func (d DetachConfig) Validate() error {
+	if !d.Enable {
+		return nil
+	}
+	if d.SessionEnv == "" {
+		return fmt.Errorf("no session environment variable provided")
+	}
+	if d.AttachCommand == "" || strings.ContainsAny(d.AttachCommand, " \t") {
+		return fmt.Errorf("invalid attach command: %q", d.AttachCommand)
+	}
+	if d.BufferSize < 0 {
+		return fmt.Errorf("invalid buffer size: %d", d.BufferSize)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package docker
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// DrainConfig configures the behavior of the connections when the backend is drained with Drain before a restart.
+type DrainConfig struct {
+	// Message is shown to users whose connection is rejected while the backend is draining.
+	Message string `json:"message" yaml:"message" default:"The server is being updated, please try again in a few minutes."`
+	// Notice is written to the stderr of the active sessions when draining starts. It is a Go template that can
+	// reference {{ .Window }}, the time left until the sessions are closed.
+	Notice string `json:"notice" yaml:"notice" default:"The server is being updated. Please finish your work, this session will be closed in {{ .Window }}."`
+	// Handover leaves the containers running when the connections are closed during draining so a new ContainerSSH
+	// process can adopt them by their labels. Only supported in execution mode "user".
+	Handover bool `json:"handover" yaml:"handover"`
+}
+
+// Validate checks the drain configuration for errors.
+func (d DrainConfig) Validate() error {
+	if _, err := template.New("notice").Parse(d.Notice); err != nil {
+		return fmt.Errorf("invalid notice template (%w)", err)
+	}
+	return nil
+}
@@ -6,8 +6,8 @@ import (
 )
 
 // ExecutionMode determines when a container is launched.
-// ExecutionModeConnection launches one container per SSH connection (default), while ExecutionModeSession launches
-// one container per SSH session.
+// ExecutionModeConnection launches one container per SSH connection (default), ExecutionModeSession launches
+// one container per SSH session, and ExecutionModeUser shares one container between the connections of a user.
 type ExecutionMode string
 
 const (
@@ -15,6 +15,9 @@ const (
 	ExecutionModeConnection ExecutionMode = "connection"
 	// ExecutionModeSession launches one container per SSH session (multiple containers per connection).
 	ExecutionModeSession ExecutionMode = "session"
+	// ExecutionModeUser launches one container per user that is shared by all concurrent connections of the user.
+	// The container is removed when the last connection has closed and the linger timeout has passed.
+	ExecutionModeUser ExecutionMode = "user"
 )
 
 // Validate validates the execution config.
@@ -23,6 +26,8 @@ func (e ExecutionMode) Validate() error {
 	case ExecutionModeConnection:
 		fallthrough
 	case ExecutionModeSession:
+		fallthrough
+	case ExecutionModeUser:
 		return nil
 	default:
 		return fmt.Errorf("invalid execution mode: %s", e)
@@ -43,11 +48,13 @@ type ExecutionConfig struct {
 	//   containers per connection. In this mode the program is launched directly as the main process of the container.
 	//   When configuring this mode you should explicitly configure the "cmd" option to an empty list if you want the
 	//   default command in the container to launch.
+	// - If ExecutionModeUser is chosen all concurrent connections of the same user share a container. Sessions are
+	//   executed using "docker exec" like in ExecutionModeConnection.
 	Mode ExecutionMode `json:"mode,omitempty" yaml:"mode" default:"connection"`
 
-	// IdleCommand is the command that runs as the first process in the container in ExecutionModeConnection. Ignored in ExecutionModeSession.
+	// IdleCommand is the command that runs as the first process in the container in ExecutionModeConnection and ExecutionModeUser. Ignored in ExecutionModeSession.
 	IdleCommand []string `json:"idleCommand,omitempty" yaml:"idleCommand" comment:"Run this command to wait for container exit" default:"[\"/usr/bin/containerssh-agent\", \"wait-signal\", \"--signal\", \"INT\", \"--signal\", \"TERM\"]"`
-	// ShellCommand is the command used for launching shells when the container is in ExecutionModeConnection. Ignored in ExecutionModeSession.
+	// ShellCommand is the command used for launching shells when the container is in ExecutionModeConnection or ExecutionModeUser. Ignored in ExecutionModeSession.
 	ShellCommand []string `json:"shellCommand,omitempty" yaml:"shellCommand" comment:"Run this command as a default shell." default:"[\"/bin/bash\"]"`
 	// AgentPath contains the path to the ContainerSSH Guest Agent.
 	AgentPath string `json:"agentPath,omitempty" yaml:"agentPath" default:"/usr/bin/containerssh-agent"`
@@ -59,6 +66,66 @@ type ExecutionConfig struct {
 	// ImagePullPolicy controls when to pull container images.
 	ImagePullPolicy ImagePullPolicy `json:"imagePullPolicy" yaml:"imagePullPolicy" comment:"Image pull policy" default:"IfNotPresent"`
 
+	// Groups maps group names to the usernames that belong to the group. Groups can be referenced when matching users,
+	// for example in policy rules.
+	Groups map[string][]string `json:"groups,omitempty" yaml:"groups,omitempty" comment:"Group names and their member usernames."`
+	// Policy restricts which programs users are allowed to run.
+	Policy PolicyConfig `json:"policy" yaml:"policy" comment:"Execution policy"`
+	// ForceCommand replaces the program requested by the client with a fixed command for matching users. The first
+	// matching rule applies to execs, shells, and subsystems in all execution modes.
+	ForceCommand []ForceCommandRule `json:"forceCommand,omitempty" yaml:"forceCommand,omitempty" comment:"Commands to run instead of the client request."`
+
+	// ProgramParsing configures how commands sent by the client are turned into programs.
+	ProgramParsing ProgramParsingConfig `json:"programParsing" yaml:"programParsing" comment:"Program parsing configuration"`
+
+	// FileTransfer configures the file transfer protocols built into ContainerSSH.
+	FileTransfer FileTransferConfig `json:"fileTransfer" yaml:"fileTransfer" comment:"Built-in file transfer configuration"`
+
+	// Files are copied into the container after it has been created and before it is started.
+	Files []SeedFile `json:"files,omitempty" yaml:"files,omitempty" comment:"Files to copy into the container before it starts."`
+
+	// Commit configures committing the container to an image when the connection ends.
+	Commit CommitConfig `json:"commit" yaml:"commit" comment:"Commit the container to an image on disconnect."`
+
+	// HomeVolume configures a persistent volume per user that is mounted into all containers of the user.
+	HomeVolume HomeVolumeConfig `json:"homeVolume" yaml:"homeVolume" comment:"Persistent home volume per user."`
+
+	// IsolatedNetwork configures a dedicated Docker network per connection or per user.
+	IsolatedNetwork IsolatedNetworkConfig `json:"isolatedNetwork" yaml:"isolatedNetwork" comment:"Dedicated network per connection or user."`
+
+	// Sidecars are containers launched alongside the user container for each connection and removed together with it.
+	// Only supported in execution mode "connection".
+	Sidecars []LaunchConfig `json:"sidecars,omitempty" yaml:"sidecars,omitempty" comment:"Containers launched alongside the user container."`
+
+	// SidecarNetwork determines if sidecars share the network namespace of the user container or are attached to the
+	// isolated network.
+	SidecarNetwork SidecarNetworkMode `json:"sidecarNetwork" yaml:"sidecarNetwork" comment:"How sidecars are connected to the user container." default:"container"`
+
+	// Setup configures commands that run in the container after it has started and before the first session.
+	Setup SetupConfig `json:"setup" yaml:"setup" comment:"Commands preparing the container before the first session."`
+
+	// UserMapping configures the user the sessions run as based on the SSH username.
+	UserMapping UserMappingConfig `json:"userMapping" yaml:"userMapping" comment:"User the sessions run as."`
+
+	// Detach configures sessions that keep running after the connection is closed and can be reattached.
+	Detach DetachConfig `json:"detach" yaml:"detach" comment:"Sessions that survive disconnects."`
+
+	// Readiness configures conditions the container must meet after it has started and before sessions are accepted.
+	Readiness ReadinessConfig `json:"readiness" yaml:"readiness" comment:"Conditions the container must meet before sessions are accepted."`
+
+	// SecurityPolicy checks the launch configurations for unsafe settings and rejects or removes them.
+	SecurityPolicy SecurityPolicyConfig `json:"securityPolicy" yaml:"securityPolicy" comment:"Checks of the launch configurations for unsafe settings."`
+
+	// Hardening fills in secure defaults on the launch configuration of the user container.
+	Hardening HardeningConfig `json:"hardening" yaml:"hardening" comment:"Secure defaults for the user container."`
+
+	// ResourceProfiles are named sets of resource limits for the user container.
+	ResourceProfiles map[string]ResourceProfile `json:"resourceProfiles,omitempty" yaml:"resourceProfiles,omitempty" comment:"Named resource limits for the user container."`
+
+	// ResourceProfileRules select the resource profile of a user. The first matching rule applies. Users not matching
+	// any rule use the resource limits of the launch configuration.
+	ResourceProfileRules []ResourceProfileRule `json:"resourceProfileRules,omitempty" yaml:"resourceProfileRules,omitempty" comment:"Rules selecting the resource profile of users."`
+
 	// disableCommand is a configuration option to support legacy command disabling from the dockerrun config.
 	// See https://containerssh.io/deprecations/dockerrun for details.
 	disableCommand bool `json:"-" yaml:"-"`
@@ -72,6 +139,25 @@ type tmpExecutionConfig struct {
 	DisableAgent bool `json:"disableAgent,omitempty" yaml:"disableAgent"`
 	Subsystems map[string]string `json:"subsystems" yaml:"subsystems" comment:"Subsystem names and binaries map." default:"{\"sftp\":\"/usr/lib/openssh/sftp-server\"}"`
 	ImagePullPolicy ImagePullPolicy `json:"imagePullPolicy" yaml:"imagePullPolicy" comment:"Image pull policy" default:"IfNotPresent"`
+	Groups map[string][]string `json:"groups,omitempty" yaml:"groups,omitempty" comment:"Group names and their member usernames."`
+	Policy PolicyConfig `json:"policy" yaml:"policy" comment:"Execution policy"`
+	ForceCommand []ForceCommandRule `json:"forceCommand,omitempty" yaml:"forceCommand,omitempty" comment:"Commands to run instead of the client request."`
+	ProgramParsing ProgramParsingConfig `json:"programParsing" yaml:"programParsing" comment:"Program parsing configuration"`
+	FileTransfer FileTransferConfig `json:"fileTransfer" yaml:"fileTransfer" comment:"Built-in file transfer configuration"`
+	Files []SeedFile `json:"files,omitempty" yaml:"files,omitempty" comment:"Files to copy into the container before it starts."`
+	Commit CommitConfig `json:"commit" yaml:"commit" comment:"Commit the container to an image on disconnect."`
+	HomeVolume HomeVolumeConfig `json:"homeVolume" yaml:"homeVolume" comment:"Persistent home volume per user."`
+	IsolatedNetwork IsolatedNetworkConfig `json:"isolatedNetwork" yaml:"isolatedNetwork" comment:"Dedicated network per connection or user."`
+	Sidecars []LaunchConfig `json:"sidecars,omitempty" yaml:"sidecars,omitempty" comment:"Containers launched alongside the user container."`
+	SidecarNetwork SidecarNetworkMode `json:"sidecarNetwork" yaml:"sidecarNetwork" comment:"How sidecars are connected to the user container." default:"container"`
+	Setup SetupConfig `json:"setup" yaml:"setup" comment:"Commands preparing the container before the first session."`
+	UserMapping UserMappingConfig `json:"userMapping" yaml:"userMapping" comment:"User the sessions run as."`
+	Detach DetachConfig `json:"detach" yaml:"detach" comment:"Sessions that survive disconnects."`
+	Readiness ReadinessConfig `json:"readiness" yaml:"readiness" comment:"Conditions the container must meet before sessions are accepted."`
+	SecurityPolicy SecurityPolicyConfig `json:"securityPolicy" yaml:"securityPolicy" comment:"Checks of the launch configurations for unsafe settings."`
+	Hardening HardeningConfig `json:"hardening" yaml:"hardening" comment:"Secure defaults for the user container."`
+	ResourceProfiles map[string]ResourceProfile `json:"resourceProfiles,omitempty" yaml:"resourceProfiles,omitempty" comment:"Named resource limits for the user container."`
+	ResourceProfileRules []ResourceProfileRule `json:"resourceProfileRules,omitempty" yaml:"resourceProfileRules,omitempty" comment:"Rules selecting the resource profile of users."`
 }
 
 // UnmarshalJSON provides inlining capabilities for LaunchConfig
@@ -91,6 +177,25 @@ func (c *ExecutionConfig) UnmarshalJSON(data []byte) error {
 	c.DisableAgent = cfg.DisableAgent
 	c.Subsystems = cfg.Subsystems
 	c.ImagePullPolicy = cfg.ImagePullPolicy
+	c.Groups = cfg.Groups
+	c.Policy = cfg.Policy
+	c.ForceCommand = cfg.ForceCommand
+	c.ProgramParsing = cfg.ProgramParsing
+	c.FileTransfer = cfg.FileTransfer
+	c.Files = cfg.Files
+	c.Commit = cfg.Commit
+	c.HomeVolume = cfg.HomeVolume
+	c.IsolatedNetwork = cfg.IsolatedNetwork
+	c.Sidecars = cfg.Sidecars
+	c.SidecarNetwork = cfg.SidecarNetwork
+	c.Setup = cfg.Setup
+	c.UserMapping = cfg.UserMapping
+	c.Detach = cfg.Detach
+	c.Readiness = cfg.Readiness
+	c.SecurityPolicy = cfg.SecurityPolicy
+	c.Hardening = cfg.Hardening
+	c.ResourceProfiles = cfg.ResourceProfiles
+	c.ResourceProfileRules = cfg.ResourceProfileRules
 	return nil
 }
 
@@ -104,13 +209,32 @@ func (c ExecutionConfig) MarshalJSON() ([]byte, error) {
 		return nil, err
 	}
 	cfg := tmpExecutionConfig{
-		Mode:            c.Mode,
-		IdleCommand:     c.IdleCommand,
-		ShellCommand:    c.ShellCommand,
-		AgentPath:       c.AgentPath,
-		DisableAgent:    c.DisableAgent,
-		Subsystems:      c.Subsystems,
-		ImagePullPolicy: c.ImagePullPolicy,
+		Mode:                 c.Mode,
+		IdleCommand:          c.IdleCommand,
+		ShellCommand:         c.ShellCommand,
+		AgentPath:            c.AgentPath,
+		DisableAgent:         c.DisableAgent,
+		Subsystems:           c.Subsystems,
+		ImagePullPolicy:      c.ImagePullPolicy,
+		Groups:               c.Groups,
+		Policy:               c.Policy,
+		ForceCommand:         c.ForceCommand,
+		ProgramParsing:       c.ProgramParsing,
+		FileTransfer:         c.FileTransfer,
+		Files:                c.Files,
+		Commit:               c.Commit,
+		HomeVolume:           c.HomeVolume,
+		IsolatedNetwork:      c.IsolatedNetwork,
+		Sidecars:             c.Sidecars,
+		SidecarNetwork:       c.SidecarNetwork,
+		Setup:                c.Setup,
+		UserMapping:          c.UserMapping,
+		Detach:               c.Detach,
+		Readiness:            c.Readiness,
+		SecurityPolicy:       c.SecurityPolicy,
+		Hardening:            c.Hardening,
+		ResourceProfiles:     c.ResourceProfiles,
+		ResourceProfileRules: c.ResourceProfileRules,
 	}
 	cfgData, err := json.Marshal(cfg)
 	if err != nil {
@@ -128,11 +252,11 @@ func (c ExecutionConfig) MarshalJSON() ([]byte, error) {
 
 // Validate validates the docker config structure.
 func (c ExecutionConfig) Validate() error {
-	if c.Mode == ExecutionModeConnection && len(c.IdleCommand) == 0 {
-		return fmt.Errorf("idle command required for execution mode \"connection\"")
+	if c.Mode != ExecutionModeSession && len(c.IdleCommand) == 0 {
+		return fmt.Errorf("idle command required for execution mode \"%s\"", c.Mode)
 	}
-	if c.Mode == ExecutionModeConnection && len(c.ShellCommand) == 0 {
-		return fmt.Errorf("shell command required for execution mode \"connection\"")
+	if c.Mode != ExecutionModeSession && len(c.ShellCommand) == 0 {
+		return fmt.Errorf("shell command required for execution mode \"%s\"", c.Mode)
 	}
 	switch c.Mode {
 	case ExecutionModeSession:
@@ -149,6 +273,108 @@ func (c ExecutionConfig) Validate() error {
 	if err := c.Launch.Validate(); err != nil {
 		return err
 	}
+	if err := c.Policy.Validate(); err != nil {
+		return fmt.Errorf("invalid policy (%w)", err)
+	}
+	if err := c.ProgramParsing.Validate(); err != nil {
+		return fmt.Errorf("invalid program parsing configuration (%w)", err)
+	}
+	if c.ProgramParsing.DetectShell && c.Mode == ExecutionModeSession {
+		return fmt.Errorf("shell detection is not supported in execution mode \"session\"")
+	}
+	if (c.FileTransfer.BuiltinSFTP || c.FileTransfer.BuiltinSCP) && c.Mode == ExecutionModeSession {
+		return fmt.Errorf("built-in file transfers are not supported in execution mode \"session\"")
+	}
+	if err := c.FileTransfer.Validate(); err != nil {
+		return fmt.Errorf("invalid file transfer configuration (%w)", err)
+	}
+	if err := c.Commit.Validate(); err != nil {
+		return fmt.Errorf("invalid commit configuration (%w)", err)
+	}
+	if c.Commit.Enable && c.Mode == ExecutionModeSession {
+		return fmt.Errorf("committing containers is not supported in execution mode \"session\"")
+	}
+	if err := c.HomeVolume.Validate(); err != nil {
+		return fmt.Errorf("invalid home volume configuration (%w)", err)
+	}
+	if err := c.IsolatedNetwork.Validate(); err != nil {
+		return fmt.Errorf("invalid isolated network configuration (%w)", err)
+	}
+	if c.IsolatedNetwork.Enable && c.Mode == ExecutionModeUser && c.IsolatedNetwork.Scope != NetworkIsolationScopeUser {
+		return fmt.Errorf("execution mode \"user\" requires the \"user\" isolated network scope")
+	}
+	if len(c.Sidecars) > 0 {
+		if c.Mode == ExecutionModeSession {
+			return fmt.Errorf("sidecars are not supported in execution mode \"session\"")
+		}
+		if err := c.SidecarNetwork.Validate(); err != nil {
+			return err
+		}
+		if c.SidecarNetwork == SidecarNetworkModeIsolated && !c.IsolatedNetwork.Enable {
+			return fmt.Errorf("sidecar network mode \"isolated\" requires the isolated network to be enabled")
+		}
+	}
+	for i, sidecar := range c.Sidecars {
+		if err := sidecar.Validate(); err != nil {
+			return fmt.Errorf("invalid sidecar %d (%w)", i, err)
+		}
+	}
+	if err := c.Setup.Validate(); err != nil {
+		return fmt.Errorf("invalid setup configuration (%w)", err)
+	}
+	if len(c.Setup.Commands) > 0 && c.Mode == ExecutionModeSession {
+		return fmt.Errorf("setup commands are not supported in execution mode \"session\"")
+	}
+	if err := c.UserMapping.Validate(); err != nil {
+		return fmt.Errorf("invalid user mapping (%w)", err)
+	}
+	if c.UserMapping.CreateUser && c.Mode == ExecutionModeSession {
+		return fmt.Errorf("creating users is not supported in execution mode \"session\"")
+	}
+	if err := c.Detach.Validate(); err != nil {
+		return fmt.Errorf("invalid detachable session configuration (%w)", err)
+	}
+	if c.Detach.Enable && c.Mode != ExecutionModeUser {
+		return fmt.Errorf("detachable sessions require execution mode \"user\"")
+	}
+	if err := c.Readiness.Validate(); err != nil {
+		return fmt.Errorf("invalid readiness configuration (%w)", err)
+	}
+	if len(c.Readiness.Checks) > 0 && c.Mode == ExecutionModeSession {
+		return fmt.Errorf("readiness checks are not supported in execution mode \"session\"")
+	}
+	if err := c.SecurityPolicy.Validate(); err != nil {
+		return fmt.Errorf("invalid security policy (%w)", err)
+	}
+	if err := c.validateSecurityPolicy(); err != nil {
+		return err
+	}
+	if err := c.Hardening.Validate(); err != nil {
+		return fmt.Errorf("invalid hardening configuration (%w)", err)
+	}
+	if c.UserMapping.CreateUser && c.Hardening.enabled(HardeningOptionUser) {
+		return fmt.Errorf("creating users requires opting out of the hardening user")
+	}
+	for name, profile := range c.ResourceProfiles {
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("invalid resource profile %s (%w)", name, err)
+		}
+	}
+	for i, rule := range c.ResourceProfileRules {
+		if _, ok := c.ResourceProfiles[rule.Profile]; !ok {
+			return fmt.Errorf("resource profile rule %d references unknown profile %q", i, rule.Profile)
+		}
+	}
+	for i, file := range c.Files {
+		if err := file.Validate(); err != nil {
+			return fmt.Errorf("invalid file %d (%w)", i, err)
+		}
+	}
+	for i, rule := range c.ForceCommand {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid force command rule %d (%w)", i, err)
+		}
+	}
 	if err := c.Mode.Validate(); err != nil {
 		return err
 	}
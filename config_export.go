@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"path"
+)
+
+// ExportConfig configures exporting files from the container when the connection ends.
+type ExportConfig struct {
+	// Paths are the absolute paths of files and directories in the container to export. Export is disabled if empty.
+	Paths []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	// Directory is the host directory the exported tar archives are written to. Archives are named after the username
+	// and the connection ID.
+	Directory string `json:"directory,omitempty" yaml:"directory,omitempty"`
+	// Sink receives the exported archives in addition to Directory. It can only be set programmatically.
+	Sink ExportSink `json:"-" yaml:"-"`
+}
+
+// ExportSink receives the files exported from a container. Implementations must be safe for concurrent use.
+type ExportSink interface {
+	// Write stores the tar archive under the specified name. The name contains the username and the connection ID.
+	Write(name string, archive io.Reader) error
+}
+
+// Validate checks the export configuration for errors.
+func (e ExportConfig) Validate() error {
+	if len(e.Paths) == 0 {
+		return nil
+	}
+	for _, exportPath := range e.Paths {
+		if !path.IsAbs(exportPath) {
+			return fmt.Errorf("export path %s is not absolute", exportPath)
+		}
+	}
+	if e.Directory == "" && e.Sink == nil {
+		return fmt.Errorf("export paths are configured but no directory is set")
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/docker/v2"
+)
+
+// TestExportValidation tests if export paths must be absolute and have a destination.
+func TestExportValidation(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, docker.ExportConfig{}.Validate())
+	assert.NoError(t, docker.ExportConfig{Paths: []string{"/home"}, Directory: "/var/export"}.Validate())
+	assert.Error(t, docker.ExportConfig{Paths: []string{"home"}, Directory: "/var/export"}.Validate())
+	assert.Error(t, docker.ExportConfig{Paths: []string{"/home"}}.Validate())
+}
@@ -0,0 +1,67 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"text/template"
+)
+
+// SeedFile is a file copied into the container before it starts. Exactly one of Source, Content and Template must be
+// set.
+type SeedFile struct {
+	// Target is the absolute path of the file in the container. Missing parent directories are created.
+	Target string `json:"target" yaml:"target"`
+	// Source is the path of a file on the ContainerSSH host that is copied into the container.
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+	// Content is the literal content of the file.
+	Content string `json:"content,omitempty" yaml:"content,omitempty"`
+	// Template is a Go template rendered into the content of the file. The template can reference {{ .Username }}
+	// and {{ .ConnectionID }}.
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+	// Mode is the file mode in octal notation.
+	Mode string `json:"mode" yaml:"mode" default:"0644"`
+	// UID is the numeric user ID owning the file.
+	UID int `json:"uid" yaml:"uid"`
+	// GID is the numeric group ID owning the file.
+	GID int `json:"gid" yaml:"gid"`
+}
+
+// Validate validates the seed file configuration.
+func (s SeedFile) Validate() error {
+	if !path.IsAbs(s.Target) {
+		return fmt.Errorf("target path %q is not absolute", s.Target)
+	}
+	sources := 0
+	for _, source := range []string{s.Source, s.Content, s.Template} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of source, content, and template must be set for %s", s.Target)
+	}
+	if _, err := s.parseMode(); err != nil {
+		return err
+	}
+	if s.Template != "" {
+		if _, err := template.New(s.Target).Parse(s.Template); err != nil {
+			return fmt.Errorf("invalid template for %s (%w)", s.Target, err)
+		}
+	}
+	if s.UID < 0 || s.GID < 0 {
+		return fmt.Errorf("invalid owner %d:%d for %s", s.UID, s.GID, s.Target)
+	}
+	return nil
+}
+
+func (s SeedFile) parseMode() (int64, error) {
+	if s.Mode == "" {
+		return 0644, nil
+	}
+	mode, err := strconv.ParseUint(s.Mode, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("invalid file mode %q for %s", s.Mode, s.Target)
+	}
+	return int64(mode), nil
+}
@@ -0,0 +1,36 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/docker/v2"
+)
+
+// TestSeedFileValidation tests if invalid seed file configurations are rejected.
+func TestSeedFileValidation(t *testing.T) {
+	t.Parallel()
+
+	valid := []docker.SeedFile{
+		{Target: "/etc/motd", Content: "hello"},
+		{Target: "/etc/motd", Source: "/srv/motd", Mode: "0600", UID: 1000, GID: 1000},
+		{Target: "/etc/motd", Template: "Hello {{ .Username }}!", Mode: "4755"},
+	}
+	for _, file := range valid {
+		assert.NoError(t, file.Validate(), file)
+	}
+
+	invalid := []docker.SeedFile{
+		{Target: "etc/motd", Content: "hello"},
+		{Target: "/etc/motd"},
+		{Target: "/etc/motd", Content: "hello", Source: "/srv/motd"},
+		{Target: "/etc/motd", Content: "hello", Mode: "0999"},
+		{Target: "/etc/motd", Content: "hello", Mode: "17777"},
+		{Target: "/etc/motd", Template: "{{ .Username "},
+		{Target: "/etc/motd", Content: "hello", UID: -1},
+	}
+	for _, file := range invalid {
+		assert.Error(t, file.Validate(), file)
+	}
+}
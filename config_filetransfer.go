@@ -0,0 +1,45 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+)
+
+// FileTransferConfig configures the file transfer protocols served by ContainerSSH itself. The built-in
+// implementations transfer files using the Docker archive API, so they work on images without an SFTP server or
+// scp binary. ContainerSSH enforces the file permissions of the user the sessions run as, based on the passwd and
+// group files of the container. Removing and renaming files runs rm, rmdir and mv in the container, so on images
+// without these programs, such as distroless images, these operations are reported as unsupported. Listing a
+// directory reads the archive of the whole tree below it and fails if the archive exceeds 64 MiB. The built-in
+// implementations require a container that lives for the whole connection and are not supported in execution mode
+// "session". Files on tmpfs mounts are not visible to the archive API.
+type FileTransferConfig struct {
+	// BuiltinSFTP serves the "sftp" subsystem from ContainerSSH instead of running the binary configured in
+	// Subsystems in the container.
+	BuiltinSFTP bool `json:"builtinSftp" yaml:"builtinSftp"`
+	// BuiltinSCP serves "scp -t" and "scp -f" requests from ContainerSSH instead of running scp in the container.
+	BuiltinSCP bool `json:"builtinScp" yaml:"builtinScp"`
+	// AllowedPaths restricts the built-in file transfers to these absolute directories and everything below them.
+	// Symbolic links are resolved before the check. Defaults to the home directory of the user if empty, use / to
+	// allow all paths.
+	AllowedPaths []string `json:"allowedPaths" yaml:"allowedPaths"`
+	// ReadOnly only permits downloads with the built-in file transfers.
+	ReadOnly bool `json:"readOnly" yaml:"readOnly"`
+	// MaxUploadSize is the maximum size of a file uploaded with the built-in file transfers in bytes. SFTP uploads
+	// are staged in a temporary file on the ContainerSSH host, so this also limits the disk space a single upload
+	// uses. 0 disables the limit.
+	MaxUploadSize int64 `json:"maxUploadSize" yaml:"maxUploadSize" default:"1073741824"`
+}
+
+// Validate validates the file transfer configuration.
+func (c FileTransferConfig) Validate() error {
+	if c.MaxUploadSize < 0 {
+		return fmt.Errorf("invalid maximum upload size: %d", c.MaxUploadSize)
+	}
+	for _, allowedPath := range c.AllowedPaths {
+		if !path.IsAbs(allowedPath) {
+			return fmt.Errorf("allowed path %s is not absolute", allowedPath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// HardeningOption is a single setting of the hardening preset that can be opted out of.
+type HardeningOption string
+
+const (
+	// HardeningOptionCapabilities drops all capabilities except the configured ones.
+	HardeningOptionCapabilities HardeningOption = "capabilities"
+	// HardeningOptionNoNewPrivileges prevents processes from gaining privileges, e.g. through setuid binaries.
+	HardeningOptionNoNewPrivileges HardeningOption = "noNewPrivileges"
+	// HardeningOptionReadOnlyRootFilesystem mounts the root filesystem of the container read-only. The Docker archive
+	// API cannot write to it, so opt out when seeding files into the container or when the built-in file transfers
+	// need to write outside of volumes.
+	HardeningOptionReadOnlyRootFilesystem HardeningOption = "readOnlyRootFilesystem"
+	// HardeningOptionTmpfs mounts writable tmpfs filesystems on the configured paths. Files on tmpfs mounts are not
+	// visible to the built-in file transfers, and a tmpfs hides the home volume if it is mounted on a parent of it.
+	HardeningOptionTmpfs HardeningOption = "tmpfs"
+	// HardeningOptionPidsLimit limits the number of processes in the container.
+	HardeningOptionPidsLimit HardeningOption = "pidsLimit"
+	// HardeningOptionMemory limits the memory of the container.
+	HardeningOptionMemory HardeningOption = "memory"
+	// HardeningOptionCPU limits the CPU usage of the container.
+	HardeningOptionCPU HardeningOption = "cpu"
+	// HardeningOptionUser runs the container as a non-root user.
+	HardeningOptionUser HardeningOption = "user"
+)
+
+// Validate checks if the hardening option is known.
+func (h HardeningOption) Validate() error {
+	switch h {
+	case HardeningOptionCapabilities:
+	case HardeningOptionNoNewPrivileges:
+	case HardeningOptionReadOnlyRootFilesystem:
+	case HardeningOptionTmpfs:
+	case HardeningOptionPidsLimit:
+	case HardeningOptionMemory:
+	case HardeningOptionCPU:
+	case HardeningOptionUser:
+	default:
+		return fmt.Errorf("invalid hardening option: %s", h)
+	}
+	return nil
+}
+
+// HardeningConfig is a preset of secure defaults for the user container. Each setting is only applied if the launch
+// configuration does not specify the corresponding field, and can be disabled with OptOut. The read-only root
+// filesystem and the tmpfs mounts do not work together with seed files, with the built-in file transfers outside of
+// volumes and with a home volume below a tmpfs path, so these combinations are rejected when validating the
+// configuration.
+type HardeningConfig struct {
+	// Enable applies the hardening preset.
+	Enable bool `json:"enable" yaml:"enable"`
+	// OptOut lists the settings of the preset that are not applied.
+	OptOut []HardeningOption `json:"optOut,omitempty" yaml:"optOut,omitempty"`
+	// Capabilities are kept when all other capabilities are dropped. They must be in the allowlist of the security
+	// policy if the policy is enabled.
+	Capabilities []string `json:"capabilities" yaml:"capabilities" default:"[\"CHOWN\",\"DAC_OVERRIDE\",\"FOWNER\",\"FSETID\",\"KILL\",\"SETGID\",\"SETUID\",\"NET_BIND_SERVICE\"]"`
+	// Tmpfs are the paths a writable tmpfs is mounted on, since the root filesystem is read-only.
+	Tmpfs []string `json:"tmpfs" yaml:"tmpfs" default:"[\"/tmp\",\"/home\"]"`
+	// TmpfsOptions are the mount options of the tmpfs mounts.
+	TmpfsOptions string `json:"tmpfsOptions" yaml:"tmpfsOptions" default:"rw,nosuid,nodev,mode=1777"`
+	// PidsLimit is the maximum number of processes in the container.
+	PidsLimit int64 `json:"pidsLimit" yaml:"pidsLimit" default:"256"`
+	// Memory is the memory limit of the container in bytes.
+	Memory int64 `json:"memory" yaml:"memory" default:"1073741824"`
+	// CPUs is the number of CPUs the container can use.
+	CPUs float64 `json:"cpus" yaml:"cpus" default:"1"`
+	// User is the user the container runs as.
+	User string `json:"user" yaml:"user" default:"1000:1000"`
+}
+
+// Validate checks the hardening configuration for errors.
+func (h HardeningConfig) Validate() error {
+	if !h.Enable {
+		return nil
+	}
+	for _, option := range h.OptOut {
+		if err := option.Validate(); err != nil {
+			return err
+		}
+	}
+	if h.enabled(HardeningOptionTmpfs) {
+		for _, tmpfs := range h.Tmpfs {
+			if !path.IsAbs(tmpfs) {
+				return fmt.Errorf("tmpfs path %s is not absolute", tmpfs)
+			}
+		}
+	}
+	if h.enabled(HardeningOptionPidsLimit) && h.PidsLimit <= 0 {
+		return fmt.Errorf("invalid pids limit: %d", h.PidsLimit)
+	}
+	if h.enabled(HardeningOptionMemory) && h.Memory <= 0 {
+		return fmt.Errorf("invalid memory limit: %d", h.Memory)
+	}
+	if h.enabled(HardeningOptionCPU) && h.CPUs <= 0 {
+		return fmt.Errorf("invalid CPU limit: %f", h.CPUs)
+	}
+	if h.enabled(HardeningOptionUser) && h.User == "" {
+		return fmt.Errorf("no user provided")
+	}
+	return nil
+}
+
+// enabled returns true if the setting of the preset has not been opted out of.
+func (h HardeningConfig) enabled(option HardeningOption) bool {
+	if !h.Enable {
+		return false
+	}
+	for _, optOut := range h.OptOut {
+		if optOut == option {
+			return false
+		}
+	}
+	return true
+}
+
+// apply fills in the settings of the preset that the launch configuration does not specify.
+func (h HardeningConfig) apply(launch *LaunchConfig) {
+	if !h.Enable {
+		return
+	}
+	// The launch config is shared between connections, so it must be copied before modification.
+	hostConfig := container.HostConfig{}
+	if launch.HostConfig != nil {
+		hostConfig = *launch.HostConfig
+	}
+	if h.enabled(HardeningOptionCapabilities) && len(hostConfig.CapDrop) == 0 && len(hostConfig.CapAdd) == 0 {
+		hostConfig.CapDrop = []string{"ALL"}
+		hostConfig.CapAdd = append([]string(nil), h.Capabilities...)
+	}
+	if h.enabled(HardeningOptionNoNewPrivileges) && !hasNoNewPrivileges(hostConfig.SecurityOpt) {
+		hostConfig.SecurityOpt = append(append([]string(nil), hostConfig.SecurityOpt...), "no-new-privileges")
+	}
+	if h.enabled(HardeningOptionReadOnlyRootFilesystem) {
+		hostConfig.ReadonlyRootfs = true
+	}
+	if h.enabled(HardeningOptionTmpfs) {
+		tmpfs := map[string]string{}
+		for target, options := range hostConfig.Tmpfs {
+			tmpfs[target] = options
+		}
+		for _, target := range h.Tmpfs {
+			if _, ok := tmpfs[target]; !ok && !hasMountTarget(hostConfig, target) {
+				tmpfs[target] = h.TmpfsOptions
+			}
+		}
+		hostConfig.Tmpfs = tmpfs
+	}
+	if h.enabled(HardeningOptionPidsLimit) && hostConfig.PidsLimit == nil {
+		pidsLimit := h.PidsLimit
+		hostConfig.PidsLimit = &pidsLimit
+	}
+	if h.enabled(HardeningOptionMemory) && hostConfig.Memory == 0 {
+		hostConfig.Memory = h.Memory
+	}
+	if h.enabled(HardeningOptionCPU) && hostConfig.NanoCPUs == 0 && hostConfig.CPUQuota == 0 {
+		hostConfig.NanoCPUs = int64(h.CPUs * 1e9)
+	}
+	launch.HostConfig = &hostConfig
+
+	if h.enabled(HardeningOptionUser) && launch.ContainerConfig != nil && launch.ContainerConfig.User == "" {
+		containerConfig := *launch.ContainerConfig
+		containerConfig.User = h.User
+		launch.ContainerConfig = &containerConfig
+	}
+}
+
+// validateHardening rejects the settings that do not work with the read-only root filesystem and the tmpfs mounts of
+// the hardening preset.
+func (c ExecutionConfig) validateHardening() error {
+	if !c.Hardening.Enable {
+		return nil
+	}
+	launch := c.Launch
+	c.Hardening.apply(&launch)
+	readOnly := launch.HostConfig.ReadonlyRootfs
+	var tmpfs []string
+	for target := range launch.HostConfig.Tmpfs {
+		tmpfs = append(tmpfs, path.Clean(target))
+	}
+	sort.Strings(tmpfs)
+
+	if readOnly && len(c.Files) > 0 {
+		return fmt.Errorf(
+			"seed files cannot be copied into a read-only root filesystem, opt out of %s",
+			HardeningOptionReadOnlyRootFilesystem,
+		)
+	}
+	if c.HomeVolume.Enable {
+		if target, ok := belowAny(homeVolumeParent(c.HomeVolume.Target), tmpfs); ok {
+			return fmt.Errorf(
+				"the home volume target %s is below the tmpfs on %s, remove it from the tmpfs paths",
+				c.HomeVolume.Target,
+				target,
+			)
+		}
+	}
+	if !c.FileTransfer.BuiltinSFTP && !c.FileTransfer.BuiltinSCP {
+		return nil
+	}
+	if len(c.FileTransfer.AllowedPaths) == 0 {
+		// The default allowed path is the home directory, which is only writable on the home volume.
+		if (readOnly || len(tmpfs) > 0) && !c.HomeVolume.Enable {
+			return fmt.Errorf(
+				"the built-in file transfers cannot access the home directory on a read-only root filesystem or " +
+					"tmpfs, enable the home volume or configure allowed paths on volumes",
+			)
+		}
+		return nil
+	}
+	volumes := mountTargets(*launch.HostConfig)
+	for _, allowedPath := range c.FileTransfer.AllowedPaths {
+		if target, ok := belowAny(path.Clean(allowedPath), tmpfs); ok {
+			return fmt.Errorf(
+				"allowed path %s is on the tmpfs on %s, which the built-in file transfers cannot access",
+				allowedPath,
+				target,
+			)
+		}
+		if _, ok := belowAny(path.Clean(allowedPath), volumes); readOnly && !ok {
+			return fmt.Errorf(
+				"allowed path %s is on the read-only root filesystem, the built-in file transfers can only write "+
+					"to volumes",
+				allowedPath,
+			)
+		}
+	}
+	return nil
+}
+
+// homeVolumeParent returns the longest directory that the rendered home volume target is always in.
+func homeVolumeParent(target string) string {
+	index := strings.Index(target, "{{")
+	if index < 0 {
+		return path.Clean(target)
+	}
+	prefix := target[:index]
+	if strings.HasSuffix(prefix, "/") {
+		return path.Clean(prefix)
+	}
+	return path.Dir(prefix)
+}
+
+// belowAny returns the directory that the absolute path is equal to or below.
+func belowAny(name string, directories []string) (string, bool) {
+	for _, directory := range directories {
+		if directory == "/" || name == directory || strings.HasPrefix(name, directory+"/") {
+			return directory, true
+		}
+	}
+	return "", false
+}
+
+// mountTargets returns the paths of the mounts and bind mounts in the host configuration.
+func mountTargets(hostConfig container.HostConfig) []string {
+	var targets []string
+	for _, m := range hostConfig.Mounts {
+		targets = append(targets, path.Clean(m.Target))
+	}
+	for _, bind := range hostConfig.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) > 1 {
+			targets = append(targets, path.Clean(parts[1]))
+		}
+	}
+	return targets
+}
+
+func hasNoNewPrivileges(securityOpt []string) bool {
+	for _, opt := range securityOpt {
+		if strings.HasPrefix(opt, "no-new-privileges") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMountTarget(hostConfig container.HostConfig, target string) bool {
+	for _, mountTarget := range mountTargets(hostConfig) {
+		if mountTarget == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/containerssh/structutils"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/docker/v2"
+)
+
+// newHardenedTestConfig returns a valid configuration with the hardening preset enabled.
+func newHardenedTestConfig() docker.Config {
+	config := docker.Config{}
+	structutils.Defaults(&config)
+	config.Execution.Hardening.Enable = true
+	return config
+}
+
+// TestHardeningDefaultsValidate tests if the defaults of the preset are valid and unknown opt-outs are rejected.
+func TestHardeningDefaultsValidate(t *testing.T) {
+	t.Parallel()
+
+	config := newHardenedTestConfig()
+	assert.NoError(t, config.Validate())
+
+	config.Execution.Hardening.OptOut = []docker.HardeningOption{"seccomp"}
+	assert.Error(t, config.Validate())
+}
+
+// TestHardeningRejectsSeedFiles tests if seed files are rejected on the read-only root filesystem.
+func TestHardeningRejectsSeedFiles(t *testing.T) {
+	t.Parallel()
+
+	config := newHardenedTestConfig()
+	config.Execution.Files = []docker.SeedFile{{Target: "/etc/motd", Content: "hello", Mode: "0644"}}
+	err := config.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "seed files")
+	}
+
+	config.Execution.Hardening.OptOut = []docker.HardeningOption{docker.HardeningOptionReadOnlyRootFilesystem}
+	assert.NoError(t, config.Validate())
+}
+
+// TestHardeningRejectsHomeVolumeBelowTmpfs tests if a home volume hidden by a tmpfs of the preset is rejected.
+func TestHardeningRejectsHomeVolumeBelowTmpfs(t *testing.T) {
+	t.Parallel()
+
+	config := newHardenedTestConfig()
+	config.Execution.HomeVolume.Enable = true
+	err := config.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "home volume")
+	}
+
+	config.Execution.HomeVolume.Target = "/home"
+	assert.Error(t, config.Validate())
+
+	config.Execution.HomeVolume.Target = "/data/{{ .Username }}"
+	assert.NoError(t, config.Validate())
+
+	config.Execution.HomeVolume.Target = "/home/{{ .Username }}"
+	config.Execution.Hardening.Tmpfs = []string{"/tmp"}
+	assert.NoError(t, config.Validate())
+}
+
+// TestHardeningRejectsFileTransfers tests if the built-in file transfers are only accepted with allowed paths on
+// volumes.
+func TestHardeningRejectsFileTransfers(t *testing.T) {
+	t.Parallel()
+
+	hostConfig := &container.HostConfig{
+		Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: "data", Target: "/data"}},
+	}
+	for _, builtin := range []string{"sftp", "scp"} {
+		config := newHardenedTestConfig()
+		config.Execution.FileTransfer.BuiltinSFTP = builtin == "sftp"
+		config.Execution.FileTransfer.BuiltinSCP = builtin == "scp"
+		config.Execution.Launch.HostConfig = hostConfig
+
+		// The default allowed path is the home directory on the tmpfs.
+		assert.Error(t, config.Validate(), builtin)
+
+		config.Execution.FileTransfer.AllowedPaths = []string{"/tmp/uploads"}
+		assert.Error(t, config.Validate(), builtin)
+
+		config.Execution.FileTransfer.AllowedPaths = []string{"/srv"}
+		assert.Error(t, config.Validate(), builtin)
+
+		config.Execution.FileTransfer.AllowedPaths = []string{"/data/uploads"}
+		assert.NoError(t, config.Validate(), builtin)
+
+		config.Execution.FileTransfer.AllowedPaths = nil
+		config.Execution.HomeVolume.Enable = true
+		config.Execution.HomeVolume.Target = "/data/{{ .Username }}"
+		assert.NoError(t, config.Validate(), builtin)
+	}
+
+	// Without the read-only root filesystem and the tmpfs mounts the default allowed path works.
+	config := newHardenedTestConfig()
+	config.Execution.FileTransfer.BuiltinSFTP = true
+	config.Execution.Hardening.OptOut = []docker.HardeningOption{
+		docker.HardeningOptionReadOnlyRootFilesystem,
+		docker.HardeningOptionTmpfs,
+	}
+	assert.NoError(t, config.Validate())
+}
@@ -0,0 +1,54 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"text/template"
+)
+
+// HomeVolumeConfig configures a persistent named volume per user that is mounted into every container of the user.
+type HomeVolumeConfig struct {
+	// Enable creates the volume on login and mounts it into the containers of the user.
+	Enable bool `json:"enable" yaml:"enable"`
+	// Name is a Go template rendered into the volume name. The template can reference {{ .Username }}. Usernames
+	// that are not valid in volume names or paths, or are longer than 64 characters, are replaced by an underscore
+	// followed by a hash of the username in both Name and Target.
+	Name string `json:"name" yaml:"name" default:"containerssh-home-{{ .Username }}"`
+	// Target is a Go template rendered into the absolute path the volume is mounted at in the container. The
+	// template can reference {{ .Username }}. The rendered path must not resolve to the root directory.
+	Target string `json:"target" yaml:"target" default:"/home/{{ .Username }}"`
+	// Driver is the volume driver to create the volume with.
+	Driver string `json:"driver" yaml:"driver" default:"local"`
+	// DriverOptions are passed to the volume driver when creating the volume.
+	DriverOptions map[string]string `json:"driverOptions,omitempty" yaml:"driverOptions,omitempty"`
+	// Size limits the size of the volume, for example "10G". It is passed to the driver as the "size" option and is
+	// only enforced by drivers that support quotas, such as the local driver on XFS with project quotas.
+	Size string `json:"size,omitempty" yaml:"size,omitempty"`
+	// Labels are added to the volume in addition to the labels identifying the user.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// Validate checks the home volume configuration for errors.
+func (h HomeVolumeConfig) Validate() error {
+	if !h.Enable {
+		return nil
+	}
+	if h.Name == "" {
+		return fmt.Errorf("no home volume name configured")
+	}
+	if h.Target == "" {
+		return fmt.Errorf("no home volume target configured")
+	}
+	if h.Driver == "" {
+		return fmt.Errorf("no home volume driver configured")
+	}
+	for _, tpl := range []string{h.Name, h.Target} {
+		if _, err := template.New("homeVolume").Parse(tpl); err != nil {
+			return fmt.Errorf("invalid template %q (%w)", tpl, err)
+		}
+	}
+	if !path.IsAbs(h.Target) {
+		return fmt.Errorf("home volume target %s is not absolute", h.Target)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package docker
+
+import (
+	"fmt"
+
+	"github.com/containerssh/metrics"
+)
+
+// LimitsConfig configures the maximum number of concurrent containers. Only the containers running the sessions are
+// counted, sidecars and egress proxies are not. A limit of 0 means unlimited.
+type LimitsConfig struct {
+	// MaxContainersPerUser is the maximum number of concurrent containers of a single username.
+	MaxContainersPerUser int `json:"maxContainersPerUser" yaml:"maxContainersPerUser"`
+	// MaxContainersPerIP is the maximum number of concurrent containers launched from a single client IP address.
+	MaxContainersPerIP int `json:"maxContainersPerIP" yaml:"maxContainersPerIP"`
+	// MaxContainersPerHost is the maximum number of concurrent containers on the configured Docker host.
+	MaxContainersPerHost int `json:"maxContainersPerHost" yaml:"maxContainersPerHost"`
+	// Message is shown to the user if a limit is reached.
+	Message string `json:"message" yaml:"message" default:"Too many concurrent sessions, please close some of your sessions and try again."`
+	// RejectionsMetric counts the containers rejected because of a limit, labeled with the limit that was reached. It
+	// can only be set programmatically.
+	RejectionsMetric metrics.SimpleCounter `json:"-" yaml:"-"`
+}
+
+// Validate checks the limits configuration for errors.
+func (l LimitsConfig) Validate() error {
+	if l.MaxContainersPerUser < 0 {
+		return fmt.Errorf("invalid maximum containers per user: %d", l.MaxContainersPerUser)
+	}
+	if l.MaxContainersPerIP < 0 {
+		return fmt.Errorf("invalid maximum containers per IP: %d", l.MaxContainersPerIP)
+	}
+	if l.MaxContainersPerHost < 0 {
+		return fmt.Errorf("invalid maximum containers per host: %d", l.MaxContainersPerHost)
+	}
+	return nil
+}
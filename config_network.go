@@ -0,0 +1,141 @@
+package docker
+
+import (
+	"fmt"
+	"net"
+)
+
+// NetworkIsolationScope determines which containers share an isolated network.
+type NetworkIsolationScope string
+
+const (
+	// NetworkIsolationScopeConnection creates a network per connection that is removed when the connection ends.
+	NetworkIsolationScopeConnection NetworkIsolationScope = "connection"
+	// NetworkIsolationScopeUser creates a network per user that is shared by all connections of the user. The network
+	// is removed when the last container of the user has been removed from it.
+	NetworkIsolationScopeUser NetworkIsolationScope = "user"
+)
+
+// Validate checks if the scope is valid.
+func (n NetworkIsolationScope) Validate() error {
+	switch n {
+	case NetworkIsolationScopeConnection:
+	case NetworkIsolationScopeUser:
+	default:
+		return fmt.Errorf("invalid network isolation scope: %s", n)
+	}
+	return nil
+}
+
+// SidecarNetworkMode determines how sidecar containers are connected.
+type SidecarNetworkMode string
+
+const (
+	// SidecarNetworkModeContainer shares the network namespace of the user container with the sidecars, so they are
+	// reachable on localhost.
+	SidecarNetworkModeContainer SidecarNetworkMode = "container"
+	// SidecarNetworkModeIsolated attaches the sidecars to the isolated network of the connection. Requires the
+	// isolated network to be enabled.
+	SidecarNetworkModeIsolated SidecarNetworkMode = "isolated"
+)
+
+// Validate checks if the sidecar network mode is valid.
+func (s SidecarNetworkMode) Validate() error {
+	switch s {
+	case SidecarNetworkModeContainer:
+	case SidecarNetworkModeIsolated:
+	default:
+		return fmt.Errorf("invalid sidecar network mode: %s", s)
+	}
+	return nil
+}
+
+// IsolatedNetworkConfig configures a dedicated Docker network for the containers of a connection or user. When
+// enabled the network replaces the network mode and network settings of the launch configuration.
+type IsolatedNetworkConfig struct {
+	// Enable creates the isolated network and attaches the containers to it.
+	Enable bool `json:"enable" yaml:"enable"`
+	// Scope determines if the network is created per connection or per user.
+	Scope NetworkIsolationScope `json:"scope" yaml:"scope" default:"connection"`
+	// Driver is the network driver.
+	Driver string `json:"driver" yaml:"driver" default:"bridge"`
+	// DriverOptions are passed to the network driver.
+	DriverOptions map[string]string `json:"driverOptions,omitempty" yaml:"driverOptions,omitempty"`
+	// Internal disables external connectivity of the network.
+	Internal bool `json:"internal" yaml:"internal"`
+	// SubnetPool contains IPv4 ranges in CIDR notation the subnets of the networks are allocated from. Docker
+	// allocates the subnet if empty.
+	SubnetPool []string `json:"subnetPool,omitempty" yaml:"subnetPool,omitempty"`
+	// SubnetSize is the prefix length of the subnets allocated from the SubnetPool.
+	SubnetSize int `json:"subnetSize" yaml:"subnetSize" default:"24"`
+	// EgressProxy is launched per isolated network, attached to both the isolated network and the EgressNetwork. It
+	// lets containers on internal networks reach the outside world in a controlled way. In the "user" scope the
+	// connections of the user share the proxy, which is removed with the network after the last connection closes.
+	EgressProxy *LaunchConfig `json:"egressProxy,omitempty" yaml:"egressProxy,omitempty"`
+	// EgressProxyAlias is the host name of the egress proxy on the isolated network.
+	EgressProxyAlias string `json:"egressProxyAlias" yaml:"egressProxyAlias" default:"egress-proxy"`
+	// EgressNetwork is the network the egress proxy uses to reach the outside world.
+	EgressNetwork string `json:"egressNetwork" yaml:"egressNetwork" default:"bridge"`
+}
+
+// Validate checks the isolated network configuration for errors.
+func (n IsolatedNetworkConfig) Validate() error {
+	if !n.Enable {
+		return nil
+	}
+	if err := n.Scope.Validate(); err != nil {
+		return err
+	}
+	if n.Driver == "" {
+		return fmt.Errorf("no network driver configured")
+	}
+	for _, pool := range n.SubnetPool {
+		_, ipNet, err := net.ParseCIDR(pool)
+		if err != nil {
+			return fmt.Errorf("invalid subnet pool %s (%w)", pool, err)
+		}
+		if ipNet.IP.To4() == nil {
+			return fmt.Errorf("subnet pool %s is not an IPv4 range", pool)
+		}
+		prefix, _ := ipNet.Mask.Size()
+		if n.SubnetSize < prefix || n.SubnetSize > 30 {
+			return fmt.Errorf("invalid subnet size %d for subnet pool %s", n.SubnetSize, pool)
+		}
+	}
+	if n.EgressProxy != nil {
+		if err := n.EgressProxy.Validate(); err != nil {
+			return fmt.Errorf("invalid egress proxy configuration (%w)", err)
+		}
+		if n.EgressNetwork == "" {
+			return fmt.Errorf("no egress network configured")
+		}
+	}
+	return nil
+}
+
+// subnets returns the subnets that can be allocated from the pool.
+func (n IsolatedNetworkConfig) subnets() []string {
+	var result []string
+	for _, pool := range n.SubnetPool {
+		_, ipNet, err := net.ParseCIDR(pool)
+		if err != nil {
+			continue
+		}
+		prefix, _ := ipNet.Mask.Size()
+		base := ipToUint32(ipNet.IP.To4())
+		count := uint32(1) << uint(n.SubnetSize-prefix)
+		for i := uint32(0); i < count; i++ {
+			ip := uint32ToIP(base + i<<uint(32-n.SubnetSize))
+			result = append(result, fmt.Sprintf("%s/%d", ip, n.SubnetSize))
+		}
+	}
+	return result
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uint32ToIP(value uint32) net.IP {
+	return net.IPv4(byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
@@ -0,0 +1,173 @@
+package docker
+
+import (
+	"fmt"
+)
+
+// PolicyAction determines what happens with a program that matches a policy rule.
+type PolicyAction string
+
+const (
+	// PolicyActionAllow lets the program run.
+	PolicyActionAllow PolicyAction = "allow"
+	// PolicyActionDeny rejects the program with the configured user message.
+	PolicyActionDeny PolicyAction = "deny"
+)
+
+// Validate checks if the policy action is valid.
+func (a PolicyAction) Validate() error {
+	switch a {
+	case PolicyActionAllow:
+		fallthrough
+	case PolicyActionDeny:
+		return nil
+	default:
+		return fmt.Errorf("invalid policy action: %s", a)
+	}
+}
+
+// PolicyRequestType is the type of SSH request a policy rule applies to.
+type PolicyRequestType string
+
+const (
+	// PolicyRequestExec matches program execution requests ("ssh host command").
+	PolicyRequestExec PolicyRequestType = "exec"
+	// PolicyRequestShell matches interactive shell requests.
+	PolicyRequestShell PolicyRequestType = "shell"
+	// PolicyRequestSubsystem matches subsystem requests, such as SFTP.
+	PolicyRequestSubsystem PolicyRequestType = "subsystem"
+)
+
+// Validate checks if the request type is valid.
+func (t PolicyRequestType) Validate() error {
+	switch t {
+	case PolicyRequestExec:
+		fallthrough
+	case PolicyRequestShell:
+		fallthrough
+	case PolicyRequestSubsystem:
+		return nil
+	default:
+		return fmt.Errorf("invalid policy request type: %s", t)
+	}
+}
+
+// UserMatch selects users either by their username or by their membership in one of the groups configured in
+// ExecutionConfig.Groups. An empty UserMatch matches all users.
+type UserMatch struct {
+	// Usernames is a list of usernames to match.
+	Usernames []string `json:"usernames,omitempty" yaml:"usernames,omitempty"`
+	// Groups is a list of group names from ExecutionConfig.Groups to match.
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+func (u UserMatch) matches(username string, groups map[string][]string) bool {
+	if len(u.Usernames) == 0 && len(u.Groups) == 0 {
+		return true
+	}
+	for _, name := range u.Usernames {
+		if name == username {
+			return true
+		}
+	}
+	for _, group := range u.Groups {
+		for _, member := range groups[group] {
+			if member == username {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PolicyRule is a single allow or deny rule in the execution policy.
+//goland:noinspection GoVetStructTag
+type PolicyRule struct {
+	// UserMatch restricts the rule to certain users. If empty, the rule applies to all users.
+	UserMatch `json:",inline" yaml:",inline"`
+
+	// Action is the action to take when the rule matches.
+	Action PolicyAction `json:"action" yaml:"action"`
+	// Types restricts the rule to certain request types. If empty, the rule applies to all request types.
+	Types []PolicyRequestType `json:"types,omitempty" yaml:"types,omitempty"`
+	// Command is a regular expression that must match the whole raw command string sent by the client. For shell
+	// requests the raw command is empty, for subsystem requests it is the subsystem name. The raw command includes
+	// quoting, so prefer Argv for matching programs.
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	// Argv is a list of regular expressions matched against the command parsed into words, before it is wrapped in a
+	// shell. The rule matches if the command has at least as many words as Argv and each expression matches the whole
+	// corresponding word. If the command is run through a shell and starts with variable assignments or contains
+	// operators, substitutions, expansions or globs, the program cannot be determined and the rule matches only if its
+	// action is deny.
+	Argv []string `json:"argv,omitempty" yaml:"argv,omitempty"`
+	// Message overrides the user-facing message of the policy when this rule denies a program.
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// Validate checks the policy rule for errors.
+func (r PolicyRule) Validate() error {
+	if err := r.Action.Validate(); err != nil {
+		return err
+	}
+	for _, t := range r.Types {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := compilePolicyExpression(r.Command); err != nil {
+		return fmt.Errorf("invalid command expression %s (%w)", r.Command, err)
+	}
+	for _, arg := range r.Argv {
+		if _, err := compilePolicyExpression(arg); err != nil {
+			return fmt.Errorf("invalid argv expression %s (%w)", arg, err)
+		}
+	}
+	return nil
+}
+
+// PolicyConfig configures which programs users may run. Rules are evaluated in order and the first matching rule
+// decides. If no rule matches the DefaultAction applies.
+type PolicyConfig struct {
+	// DefaultAction is the action to take when no rule matches. Defaults to allow if empty.
+	DefaultAction PolicyAction `json:"defaultAction" yaml:"defaultAction" default:"allow"`
+	// Rules is the ordered list of policy rules.
+	Rules []PolicyRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+	// Message is the message sent to the user when a program is rejected.
+	Message string `json:"message" yaml:"message" default:"You are not permitted to run this program."`
+}
+
+// Validate checks the policy configuration for errors.
+func (p PolicyConfig) Validate() error {
+	if p.DefaultAction != "" {
+		if err := p.DefaultAction.Validate(); err != nil {
+			return err
+		}
+	}
+	for i, rule := range p.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid policy rule %d (%w)", i, err)
+		}
+	}
+	return nil
+}
+
+// ForceCommandRule replaces the program requested by the client with a fixed command for the matching users,
+// similar to the ForceCommand option in OpenSSH. The original request is available to the forced command in the
+// SSH_ORIGINAL_COMMAND environment variable.
+//goland:noinspection GoVetStructTag
+type ForceCommandRule struct {
+	// UserMatch restricts the rule to certain users. If empty, the rule applies to all users.
+	UserMatch `json:",inline" yaml:",inline"`
+
+	// Command is the command to run instead of the requested program, shell, or subsystem. It is parsed the same way
+	// as a command sent by the client.
+	Command string `json:"command" yaml:"command"`
+}
+
+// Validate checks the forced command rule for errors.
+func (r ForceCommandRule) Validate() error {
+	if r.Command == "" {
+		return fmt.Errorf("empty forced command")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"fmt"
+)
+
+// ProgramParsingMode determines how a command sent by the client is turned into a program to execute.
+type ProgramParsingMode string
+
+const (
+	// ProgramParsingAuto executes commands starting with /, ./ or ../ directly and runs all other commands using the
+	// configured shell. This is the default behavior.
+	ProgramParsingAuto ProgramParsingMode = "auto"
+	// ProgramParsingShell always runs the command using the configured shell with the -c flag.
+	ProgramParsingShell ProgramParsingMode = "shell"
+	// ProgramParsingExec executes the parsed command directly and leaves the PATH lookup to the container. Commands
+	// that cannot be parsed are run using the configured shell.
+	ProgramParsingExec ProgramParsingMode = "exec"
+	// ProgramParsingReject executes the parsed command directly and leaves the PATH lookup to the container. Commands
+	// that cannot be parsed are rejected.
+	ProgramParsingReject ProgramParsingMode = "reject"
+)
+
+// Validate checks if the program parsing mode is valid.
+func (m ProgramParsingMode) Validate() error {
+	switch m {
+	case "":
+		fallthrough
+	case ProgramParsingAuto:
+		fallthrough
+	case ProgramParsingShell:
+		fallthrough
+	case ProgramParsingExec:
+		fallthrough
+	case ProgramParsingReject:
+		return nil
+	default:
+		return fmt.Errorf("invalid program parsing mode: %s", m)
+	}
+}
+
+// ProgramParsingConfig configures how commands sent by the client are executed.
+type ProgramParsingConfig struct {
+	// Mode is the strategy for turning a command into a program.
+	Mode ProgramParsingMode `json:"mode" yaml:"mode" default:"auto"`
+	// Shell is the shell used to run commands with the -c flag.
+	Shell string `json:"shell" yaml:"shell" default:"/bin/sh"`
+	// DetectShell looks for the first existing shell from ShellCandidates in the container and uses it instead of
+	// Shell. The result is cached per image. Only supported in execution mode "connection".
+	DetectShell bool `json:"detectShell" yaml:"detectShell"`
+	// ShellCandidates is the ordered list of shells to look for when DetectShell is enabled.
+	ShellCandidates []string `json:"shellCandidates,omitempty" yaml:"shellCandidates,omitempty" default:"[\"/bin/bash\", \"/bin/sh\", \"/bin/ash\", \"/busybox/sh\"]"`
+}
+
+// Validate checks the program parsing configuration for errors.
+func (p ProgramParsingConfig) Validate() error {
+	if err := p.Mode.Validate(); err != nil {
+		return err
+	}
+	if p.DetectShell && len(p.ShellCandidates) == 0 {
+		return fmt.Errorf("shell detection is enabled but no shell candidates are configured")
+	}
+	return nil
+}
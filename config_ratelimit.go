@@ -0,0 +1,46 @@
+package docker
+
+import (
+	"fmt"
+)
+
+// RateLimitConfig configures token bucket rate limits on launching containers. Containers rejected by another limit
+// do not count against the rate limits.
+type RateLimitConfig struct {
+	// PerIP limits the containers launched from a single client IP address.
+	PerIP RateLimit `json:"perIP" yaml:"perIP"`
+	// PerUser limits the containers launched for a single username.
+	PerUser RateLimit `json:"perUser" yaml:"perUser"`
+	// Message is shown to the user if a rate limit is exceeded.
+	Message string `json:"message" yaml:"message" default:"Too many new sessions, please wait a moment and try again."`
+}
+
+// RateLimit is the setting of a single token bucket.
+type RateLimit struct {
+	// PerMinute is the number of containers that can be launched per minute on average. 0 disables the limit.
+	PerMinute float64 `json:"perMinute" yaml:"perMinute"`
+	// Burst is the number of containers that can be launched at once. Defaults to 1 if the limit is enabled.
+	Burst int `json:"burst" yaml:"burst"`
+}
+
+// Validate checks the rate limit configuration for errors.
+func (r RateLimitConfig) Validate() error {
+	if err := r.PerIP.Validate(); err != nil {
+		return fmt.Errorf("invalid per-IP rate limit (%w)", err)
+	}
+	if err := r.PerUser.Validate(); err != nil {
+		return fmt.Errorf("invalid per-user rate limit (%w)", err)
+	}
+	return nil
+}
+
+// Validate checks the token bucket settings for errors.
+func (r RateLimit) Validate() error {
+	if r.PerMinute < 0 {
+		return fmt.Errorf("invalid rate: %f", r.PerMinute)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("invalid burst: %d", r.Burst)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+)
+
+// ReadinessCheckType is the kind of condition a readiness check waits for.
+type ReadinessCheckType string
+
+const (
+	// ReadinessCheckHealth waits for the Docker health check of the container to report "healthy". The check fails
+	// immediately if the image has no HEALTHCHECK.
+	ReadinessCheckHealth ReadinessCheckType = "health"
+	// ReadinessCheckFile waits for a file to exist in the container.
+	ReadinessCheckFile ReadinessCheckType = "file"
+	// ReadinessCheckTCP waits for a TCP port to accept connections.
+	ReadinessCheckTCP ReadinessCheckType = "tcp"
+	// ReadinessCheckCommand waits for a command run in the container to exit with status 0.
+	ReadinessCheckCommand ReadinessCheckType = "command"
+)
+
+// ReadinessConfig configures the conditions the container must meet before sessions are accepted.
+type ReadinessConfig struct {
+	// Checks are performed one after the other after the container has started. Each check is retried until it
+	// succeeds or the readiness timeout has passed. Only supported in execution modes "connection" and "user".
+	Checks []ReadinessCheck `json:"checks,omitempty" yaml:"checks,omitempty"`
+	// FailureMessage is shown to the user if the container does not become ready in time.
+	FailureMessage string `json:"failureMessage" yaml:"failureMessage" default:"Your environment did not become ready in time, please try again later."`
+}
+
+// ReadinessCheck is a single condition the container must meet.
+type ReadinessCheck struct {
+	// Type is the kind of condition to wait for.
+	Type ReadinessCheckType `json:"type" yaml:"type"`
+	// Path is the absolute path of the file to wait for in checks of type "file".
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Host is the address to connect to in checks of type "tcp". Defaults to the IP address of the container.
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Port is the port to connect to in checks of type "tcp".
+	Port int `json:"port,omitempty" yaml:"port,omitempty"`
+	// Program is the command to run in the container in checks of type "command".
+	Program []string `json:"program,omitempty" yaml:"program,omitempty"`
+}
+
+// Validate checks the readiness configuration for errors.
+func (r ReadinessConfig) Validate() error {
+	for i, check := range r.Checks {
+		if err := check.Validate(); err != nil {
+			return fmt.Errorf("invalid readiness check %d (%w)", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a readiness check for errors.
+func (r ReadinessCheck) Validate() error {
+	switch r.Type {
+	case ReadinessCheckHealth:
+	case ReadinessCheckFile:
+		if !path.IsAbs(r.Path) {
+			return fmt.Errorf("path %q is not absolute", r.Path)
+		}
+	case ReadinessCheckTCP:
+		if r.Port <= 0 || r.Port > 65535 {
+			return fmt.Errorf("invalid port: %d", r.Port)
+		}
+	case ReadinessCheckCommand:
+		if len(r.Program) == 0 {
+			return fmt.Errorf("no program provided")
+		}
+	default:
+		return fmt.Errorf("invalid readiness check type: %s", r.Type)
+	}
+	return nil
+}
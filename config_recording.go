@@ -0,0 +1,33 @@
+package docker
+
+import (
+	"fmt"
+)
+
+// RecordingConfig configures the recording of interactive sessions in the asciicast v2 format.
+type RecordingConfig struct {
+	// Enable turns on recording of interactive (PTY) sessions.
+	Enable bool `json:"enable" yaml:"enable"`
+	// Directory is the directory the recordings are written to. Files are named after the connection ID and channel
+	// ID.
+	Directory string `json:"directory,omitempty" yaml:"directory,omitempty"`
+	// MaxSize is the maximum size of a single recording file in bytes. Recording stops when the limit is reached.
+	// Set to 0 for no limit.
+	MaxSize int64 `json:"maxSize" yaml:"maxSize" default:"104857600"`
+	// RecordStdin records the input of the user in a separate file next to the output recording.
+	RecordStdin bool `json:"recordStdin" yaml:"recordStdin"`
+}
+
+// Validate checks the recording configuration for errors.
+func (r RecordingConfig) Validate() error {
+	if !r.Enable {
+		return nil
+	}
+	if r.Directory == "" {
+		return fmt.Errorf("recording is enabled but no directory is configured")
+	}
+	if r.MaxSize < 0 {
+		return fmt.Errorf("invalid maximum recording size: %d", r.MaxSize)
+	}
+	return nil
+}
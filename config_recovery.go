@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"fmt"
+)
+
+// RecoveryPolicy determines what Recover does with the containers left behind by a previous ContainerSSH process.
+type RecoveryPolicy string
+
+const (
+	// RecoveryPolicyNone leaves the containers untouched.
+	RecoveryPolicyNone RecoveryPolicy = "none"
+	// RecoveryPolicyRemove removes all containers left behind.
+	RecoveryPolicyRemove RecoveryPolicy = "remove"
+	// RecoveryPolicyAdopt adopts the running containers of execution mode "user" so returning users continue to use
+	// them, and removes all other containers. Adopted containers are removed if the user does not return within the
+	// adopt timeout.
+	RecoveryPolicyAdopt RecoveryPolicy = "adopt"
+)
+
+// Validate checks if the recovery policy is valid.
+func (r RecoveryPolicy) Validate() error {
+	switch r {
+	case RecoveryPolicyNone:
+	case RecoveryPolicyRemove:
+	case RecoveryPolicyAdopt:
+	default:
+		return fmt.Errorf("invalid recovery policy: %s", r)
+	}
+	return nil
+}
+
+// RecoveryConfig configures the handling of containers left behind by a previous ContainerSSH process.
+type RecoveryConfig struct {
+	// Policy determines what happens with the containers found by Recover.
+	Policy RecoveryPolicy `json:"policy" yaml:"policy" default:"none"`
+	// Instance is a name identifying the ContainerSSH deployment. Containers are labeled with it, and Recover only
+	// touches containers with the same label, so several ContainerSSH deployments can share a Docker host. Each
+	// deployment must use a distinct name. Required if the policy is not "none".
+	Instance string `json:"instance" yaml:"instance"`
+}
+
+// Validate checks the recovery configuration for errors.
+func (r RecoveryConfig) Validate() error {
+	if err := r.Policy.Validate(); err != nil {
+		return err
+	}
+	if r.Policy != RecoveryPolicyNone && r.Instance == "" {
+		return fmt.Errorf("recovery policy %s requires an instance name", r.Policy)
+	}
+	return nil
+}
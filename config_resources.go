@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"fmt"
+)
+
+// ResourceProfile is a named set of resource limits for the user container. The profile takes precedence over the
+// launch configuration and the hardening preset. Fields left at zero keep their values.
+type ResourceProfile struct {
+	// Memory is the memory limit in bytes.
+	Memory int64 `json:"memory,omitempty" yaml:"memory,omitempty"`
+	// CPUQuota is the CPU time in microseconds the container can use per CPU period.
+	CPUQuota int64 `json:"cpuQuota,omitempty" yaml:"cpuQuota,omitempty"`
+	// CPUPeriod is the length of the CPU period in microseconds.
+	CPUPeriod int64 `json:"cpuPeriod,omitempty" yaml:"cpuPeriod,omitempty"`
+	// CPUShares is the relative CPU weight of the container.
+	CPUShares int64 `json:"cpuShares,omitempty" yaml:"cpuShares,omitempty"`
+	// PidsLimit is the maximum number of processes in the container.
+	PidsLimit int64 `json:"pidsLimit,omitempty" yaml:"pidsLimit,omitempty"`
+	// StorageSize is the size of the writable layer of the container, e.g. "10G". Requires a storage driver that
+	// supports the "size" option.
+	StorageSize string `json:"storageSize,omitempty" yaml:"storageSize,omitempty"`
+	// Ulimits are the resource limits of the processes in the container. They replace the ulimits of the launch
+	// configuration.
+	Ulimits []ResourceUlimit `json:"ulimits,omitempty" yaml:"ulimits,omitempty"`
+}
+
+// ResourceUlimit is a single process resource limit, such as "nofile".
+type ResourceUlimit struct {
+	// Name is the name of the limit.
+	Name string `json:"name" yaml:"name"`
+	// Soft is the soft limit.
+	Soft int64 `json:"soft" yaml:"soft"`
+	// Hard is the hard limit.
+	Hard int64 `json:"hard" yaml:"hard"`
+}
+
+// Validate checks the resource profile for errors.
+func (r ResourceProfile) Validate() error {
+	if r.Memory < 0 || r.CPUQuota < 0 || r.CPUPeriod < 0 || r.CPUShares < 0 || r.PidsLimit < 0 {
+		return fmt.Errorf("resource limits cannot be negative")
+	}
+	for _, ulimit := range r.Ulimits {
+		if ulimit.Name == "" {
+			return fmt.Errorf("ulimit without name")
+		}
+		if ulimit.Soft > ulimit.Hard {
+			return fmt.Errorf("soft limit of ulimit %s is above its hard limit", ulimit.Name)
+		}
+	}
+	return nil
+}
+
+// ResourceProfileRule selects the resource profile of the matching users.
+//goland:noinspection GoVetStructTag
+type ResourceProfileRule struct {
+	// UserMatch restricts the rule to certain users. If empty, the rule applies to all users.
+	UserMatch `json:",inline" yaml:",inline"`
+
+	// Profile is the name of the profile in ExecutionConfig.ResourceProfiles.
+	Profile string `json:"profile" yaml:"profile"`
+}
@@ -0,0 +1,337 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// SecurityPolicyMode determines how settings violating the security policy are handled.
+type SecurityPolicyMode string
+
+const (
+	// SecurityPolicyModeOff does not check the launch configuration.
+	SecurityPolicyModeOff SecurityPolicyMode = "off"
+	// SecurityPolicyModeReject rejects a configuration with unsafe settings when it is validated.
+	SecurityPolicyModeReject SecurityPolicyMode = "reject"
+	// SecurityPolicyModeRewrite removes the unsafe settings from the configuration and logs a warning for each.
+	SecurityPolicyModeRewrite SecurityPolicyMode = "rewrite"
+)
+
+// Validate checks if the security policy mode is valid.
+func (s SecurityPolicyMode) Validate() error {
+	switch s {
+	case "":
+	case SecurityPolicyModeOff:
+	case SecurityPolicyModeReject:
+	case SecurityPolicyModeRewrite:
+	default:
+		return fmt.Errorf("invalid security policy mode: %s", s)
+	}
+	return nil
+}
+
+// SecurityPolicyConfig configures the checks of the launch configurations of the user container, the sidecars and
+// the egress proxy. The policy forbids privileged containers, the host network, PID, IPC, user, UTS and cgroup
+// namespaces, capabilities outside the allowlist, mounting the Docker socket, bind mounts outside the allowlist,
+// local volumes mounting host paths, device mappings, cgroup rules and requests, and disabling seccomp, AppArmor or
+// the masking of kernel paths. The settings added by the hardening preset are checked too.
+type SecurityPolicyConfig struct {
+	// Mode determines what happens with unsafe settings.
+	Mode SecurityPolicyMode `json:"mode" yaml:"mode" default:"off"`
+	// AllowedCapabilities are the capabilities that may be added with CapAdd, e.g. NET_BIND_SERVICE.
+	AllowedCapabilities []string `json:"allowedCapabilities,omitempty" yaml:"allowedCapabilities,omitempty"`
+	// AllowedBindMounts are the host paths that may be bind mounted, including their subdirectories. The Docker
+	// socket and the directories containing it can never be mounted.
+	AllowedBindMounts []string `json:"allowedBindMounts,omitempty" yaml:"allowedBindMounts,omitempty"`
+}
+
+// Validate checks the security policy configuration for errors.
+func (s SecurityPolicyConfig) Validate() error {
+	if err := s.Mode.Validate(); err != nil {
+		return err
+	}
+	for _, bindMount := range s.AllowedBindMounts {
+		if !path.IsAbs(bindMount) {
+			return fmt.Errorf("allowed bind mount %s is not absolute", bindMount)
+		}
+	}
+	return nil
+}
+
+// check returns the violations of the policy in the launch configuration. If rewrite is true the unsafe settings are
+// removed from a copy of the host configuration that replaces the original.
+func (s SecurityPolicyConfig) check(name string, launch *LaunchConfig, rewrite bool) []string {
+	if launch.HostConfig == nil {
+		return nil
+	}
+	// The host config may be shared between connections, so it must be copied before modification.
+	hostConfig := *launch.HostConfig
+	var violations []string
+	violation := func(format string, args ...interface{}) {
+		violations = append(violations, name+": "+fmt.Sprintf(format, args...))
+	}
+
+	if hostConfig.Privileged {
+		violation("privileged containers are not permitted")
+		hostConfig.Privileged = false
+	}
+	if hostConfig.NetworkMode.IsHost() {
+		violation("the host network is not permitted")
+		hostConfig.NetworkMode = ""
+	}
+	if hostConfig.PidMode.IsHost() {
+		violation("the host PID namespace is not permitted")
+		hostConfig.PidMode = ""
+	}
+	if hostConfig.IpcMode.IsHost() {
+		violation("the host IPC namespace is not permitted")
+		hostConfig.IpcMode = ""
+	}
+	if hostConfig.UsernsMode.IsHost() {
+		violation("the host user namespace is not permitted")
+		hostConfig.UsernsMode = ""
+	}
+	if hostConfig.UTSMode.IsHost() {
+		violation("the host UTS namespace is not permitted")
+		hostConfig.UTSMode = ""
+	}
+	if hostConfig.CgroupnsMode.IsHost() {
+		violation("the host cgroup namespace is not permitted")
+		hostConfig.CgroupnsMode = ""
+	}
+
+	var capAdd []string
+	for _, capability := range hostConfig.CapAdd {
+		if !s.capabilityAllowed(capability) {
+			violation("capability %s is not in the allowlist", capability)
+			continue
+		}
+		capAdd = append(capAdd, capability)
+	}
+	hostConfig.CapAdd = capAdd
+
+	var binds []string
+	for _, bind := range hostConfig.Binds {
+		source := strings.SplitN(bind, ":", 2)[0]
+		if reason := s.bindMountViolation(source); reason != "" {
+			violation("bind mount %s %s", bind, reason)
+			continue
+		}
+		binds = append(binds, bind)
+	}
+	hostConfig.Binds = binds
+
+	var mounts []mount.Mount
+	for _, m := range hostConfig.Mounts {
+		if m.Type == mount.TypeBind {
+			if reason := s.bindMountViolation(m.Source); reason != "" {
+				violation("bind mount of %s %s", m.Source, reason)
+				continue
+			}
+		}
+		if m.Type == mount.TypeVolume && volumeMountsHostPath(m) {
+			violation("volume mount of %s with a host device or bind options is not permitted", m.Source)
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	hostConfig.Mounts = mounts
+
+	if len(hostConfig.Devices) > 0 {
+		for _, device := range hostConfig.Devices {
+			violation("device mapping of %s is not permitted", device.PathOnHost)
+		}
+		hostConfig.Devices = nil
+	}
+	if len(hostConfig.DeviceCgroupRules) > 0 {
+		for _, rule := range hostConfig.DeviceCgroupRules {
+			violation("device cgroup rule %s is not permitted", rule)
+		}
+		hostConfig.DeviceCgroupRules = nil
+	}
+	if len(hostConfig.DeviceRequests) > 0 {
+		for _, request := range hostConfig.DeviceRequests {
+			violation("device request for driver %s is not permitted", request.Driver)
+		}
+		hostConfig.DeviceRequests = nil
+	}
+
+	var securityOpt []string
+	for _, opt := range hostConfig.SecurityOpt {
+		if securityOptDisablesConfinement(opt) {
+			violation("security option %s is not permitted", opt)
+			continue
+		}
+		securityOpt = append(securityOpt, opt)
+	}
+	hostConfig.SecurityOpt = securityOpt
+
+	if rewrite && len(violations) > 0 {
+		launch.HostConfig = &hostConfig
+	}
+	return violations
+}
+
+func (s SecurityPolicyConfig) capabilityAllowed(capability string) bool {
+	normalized := strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+	for _, allowed := range s.AllowedCapabilities {
+		if strings.TrimPrefix(strings.ToUpper(allowed), "CAP_") == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerSocketPaths are the default locations of the Docker socket. Mounting them or any of their parent directories
+// exposes the socket regardless of the allowed bind mounts.
+var dockerSocketPaths = []string{
+	"/var/run/docker.sock",
+	"/run/docker.sock",
+}
+
+// bindMountViolation returns the reason why the host path cannot be mounted, or an empty string if it can. Sources
+// that are not absolute paths are named volumes and always permitted.
+func (s SecurityPolicyConfig) bindMountViolation(source string) string {
+	if !path.IsAbs(source) {
+		return ""
+	}
+	source = path.Clean(source)
+	if path.Base(source) == "docker.sock" {
+		return "exposes the Docker socket"
+	}
+	for _, socket := range dockerSocketPaths {
+		if source == "/" || strings.HasPrefix(socket, source+"/") {
+			return "exposes the Docker socket"
+		}
+	}
+	for _, allowed := range s.AllowedBindMounts {
+		allowed = path.Clean(allowed)
+		if source == allowed || strings.HasPrefix(source, strings.TrimSuffix(allowed, "/")+"/") {
+			return ""
+		}
+	}
+	return "is outside the allowed bind mounts"
+}
+
+// volumeMountsHostPath returns true if the volume mount creates a volume of the local driver that mounts a host path
+// or device, which bypasses the bind mount checks.
+func volumeMountsHostPath(m mount.Mount) bool {
+	if m.VolumeOptions == nil || m.VolumeOptions.DriverConfig == nil {
+		return false
+	}
+	driver := m.VolumeOptions.DriverConfig
+	if driver.Name != "" && driver.Name != "local" {
+		return false
+	}
+	if _, ok := driver.Options["device"]; ok {
+		return true
+	}
+	for _, option := range strings.Split(driver.Options["o"], ",") {
+		switch strings.TrimSpace(option) {
+		case "bind", "rbind":
+			return true
+		}
+	}
+	return false
+}
+
+// securityOptDisablesConfinement returns true if the security option disables seccomp, AppArmor, SELinux or the
+// masking of kernel paths.
+func securityOptDisablesConfinement(opt string) bool {
+	normalized := strings.ToLower(strings.ReplaceAll(opt, ":", "="))
+	switch normalized {
+	case "seccomp=unconfined", "apparmor=unconfined", "label=disable", "systempaths=unconfined":
+		return true
+	}
+	return false
+}
+
+// namedLaunchConfig is a launch configuration with its name for error messages.
+type namedLaunchConfig struct {
+	name   string
+	launch *LaunchConfig
+}
+
+// launchConfigs returns the launch configurations the security policy applies to.
+func (c *ExecutionConfig) launchConfigs() []namedLaunchConfig {
+	result := []namedLaunchConfig{{name: "container", launch: &c.Launch}}
+	for i := range c.Sidecars {
+		result = append(result, namedLaunchConfig{name: fmt.Sprintf("sidecar %d", i), launch: &c.Sidecars[i]})
+	}
+	if c.IsolatedNetwork.EgressProxy != nil {
+		result = append(result, namedLaunchConfig{name: "egress proxy", launch: c.IsolatedNetwork.EgressProxy})
+	}
+	return result
+}
+
+// validateSecurityPolicy returns an error listing all violations of the security policy in reject mode.
+func (c ExecutionConfig) validateSecurityPolicy() error {
+	if c.SecurityPolicy.Mode != SecurityPolicyModeReject {
+		return nil
+	}
+	var violations []string
+	for _, l := range c.launchConfigs() {
+		launchCopy := *l.launch
+		if l.launch == &c.Launch {
+			// The settings of the hardening preset are subject to the policy, so they are checked as applied.
+			c.Hardening.apply(&launchCopy)
+		}
+		violations = append(violations, c.SecurityPolicy.check(l.name, &launchCopy, false)...)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("security policy violations: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// applySecurityPolicy removes the unsafe settings in rewrite mode and returns the violations that were removed.
+func (c *ExecutionConfig) applySecurityPolicy() []string {
+	if c.SecurityPolicy.Mode != SecurityPolicyModeRewrite {
+		return nil
+	}
+	// The sidecars and the egress proxy may be shared between connections, so they must be copied before
+	// modification.
+	c.Sidecars = append([]LaunchConfig(nil), c.Sidecars...)
+	if c.IsolatedNetwork.EgressProxy != nil {
+		egressProxy := *c.IsolatedNetwork.EgressProxy
+		c.IsolatedNetwork.EgressProxy = &egressProxy
+	}
+	var violations []string
+	for _, l := range c.launchConfigs() {
+		violations = append(violations, c.SecurityPolicy.check(l.name, l.launch, true)...)
+	}
+	return violations
+}
+
+// reportedSecurityViolations holds the violations already logged in rewrite mode so each is only reported once per
+// process instead of on every connection.
+var reportedSecurityViolations = struct {
+	lock       *sync.Mutex
+	violations map[string]struct{}
+}{
+	lock:       &sync.Mutex{},
+	violations: map[string]struct{}{},
+}
+
+// applySecurityPolicy rewrites the configuration according to the security policy and logs the removed settings.
+func applySecurityPolicy(config *Config, logger log.Logger) {
+	violations := config.Execution.applySecurityPolicy()
+	reportedSecurityViolations.lock.Lock()
+	defer reportedSecurityViolations.lock.Unlock()
+	for _, violation := range violations {
+		if _, ok := reportedSecurityViolations.violations[violation]; ok {
+			continue
+		}
+		reportedSecurityViolations.violations[violation] = struct{}{}
+		logger.Warning(log.NewMessage(
+			ESecurityPolicyRewrite,
+			"removed setting violating the security policy: %s",
+			violation,
+		))
+	}
+}
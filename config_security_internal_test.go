@@ -0,0 +1,46 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSecurityPolicyRewrite tests if the unsafe settings are removed from a copy of the host configuration.
+func TestSecurityPolicyRewrite(t *testing.T) {
+	t.Parallel()
+
+	policy := SecurityPolicyConfig{Mode: SecurityPolicyModeRewrite}
+	original := &container.HostConfig{
+		Privileged:  true,
+		CapAdd:      []string{"SYS_ADMIN"},
+		Binds:       []string{"/var/run/docker.sock:/var/run/docker.sock", "home:/home"},
+		SecurityOpt: []string{"systempaths=unconfined", "no-new-privileges"},
+	}
+	launch := &LaunchConfig{HostConfig: original}
+	assert.Len(t, policy.check("container", launch, true), 4)
+	assert.False(t, launch.HostConfig.Privileged)
+	assert.Empty(t, launch.HostConfig.CapAdd)
+	assert.Equal(t, []string{"home:/home"}, launch.HostConfig.Binds)
+	assert.Equal(t, []string{"no-new-privileges"}, launch.HostConfig.SecurityOpt)
+	assert.True(t, original.Privileged)
+}
+
+// TestSecurityPolicyRewritesHardening tests if the capabilities added by the hardening preset are removed in rewrite
+// mode and not added back when the preset is applied again.
+func TestSecurityPolicyRewritesHardening(t *testing.T) {
+	t.Parallel()
+
+	config := ExecutionConfig{}
+	config.Launch.ContainerConfig = &container.Config{Image: "test"}
+	config.Hardening = HardeningConfig{Enable: true, Capabilities: []string{"CHOWN", "NET_BIND_SERVICE"}}
+	config.SecurityPolicy = SecurityPolicyConfig{Mode: SecurityPolicyModeRewrite}
+	config.Hardening.apply(&config.Launch)
+	assert.Len(t, config.applySecurityPolicy(), 2)
+	assert.Empty(t, config.Launch.HostConfig.CapAdd)
+	assert.Equal(t, strslice.StrSlice{"ALL"}, config.Launch.HostConfig.CapDrop)
+	config.Hardening.apply(&config.Launch)
+	assert.Empty(t, config.Launch.HostConfig.CapAdd)
+}
@@ -0,0 +1,154 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/containerssh/structutils"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containerssh/docker/v2"
+)
+
+// newSecurityPolicyTestConfig returns a valid configuration with the security policy in reject mode.
+func newSecurityPolicyTestConfig(allowedBindMounts ...string) docker.Config {
+	config := docker.Config{}
+	structutils.Defaults(&config)
+	config.Execution.SecurityPolicy = docker.SecurityPolicyConfig{
+		Mode:                docker.SecurityPolicyModeReject,
+		AllowedCapabilities: []string{"NET_BIND_SERVICE"},
+		AllowedBindMounts:   allowedBindMounts,
+	}
+	return config
+}
+
+func assertSecurityPolicyViolation(t *testing.T, config docker.Config, name string) {
+	err := config.Validate()
+	if assert.Error(t, err, name) {
+		assert.Contains(t, err.Error(), "security policy violations", name)
+	}
+}
+
+// TestSecurityPolicyRejectsUnsafeSettings tests if each unsafe setting is rejected in reject mode and safe settings
+// are accepted.
+func TestSecurityPolicyRejectsUnsafeSettings(t *testing.T) {
+	t.Parallel()
+
+	unsafe := map[string]container.HostConfig{
+		"privileged":         {Privileged: true},
+		"host network":       {NetworkMode: "host"},
+		"host PID":           {PidMode: "host"},
+		"host IPC":           {IpcMode: "host"},
+		"host userns":        {UsernsMode: "host"},
+		"host UTS":           {UTSMode: "host"},
+		"host cgroupns":      {CgroupnsMode: "host"},
+		"capability":         {CapAdd: []string{"SYS_ADMIN"}},
+		"bind":               {Binds: []string{"/etc:/etc"}},
+		"socket":             {Binds: []string{"/srv/data/docker.sock:/var/run/docker.sock"}},
+		"device":             {Resources: container.Resources{Devices: []container.DeviceMapping{{PathOnHost: "/dev/sda"}}}},
+		"device cgroup rule": {Resources: container.Resources{DeviceCgroupRules: []string{"b 8:* rmw"}}},
+		"device request":     {Resources: container.Resources{DeviceRequests: []container.DeviceRequest{{Driver: "nvidia"}}}},
+		"seccomp":            {SecurityOpt: []string{"seccomp=unconfined"}},
+		"apparmor":           {SecurityOpt: []string{"apparmor:unconfined"}},
+		"systempaths":        {SecurityOpt: []string{"systempaths=unconfined"}},
+		"bind mount":         {Mounts: []mount.Mount{{Type: mount.TypeBind, Source: "/", Target: "/host"}}},
+		"volume bind": {Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: "host",
+			Target: "/host",
+			VolumeOptions: &mount.VolumeOptions{DriverConfig: &mount.Driver{
+				Options: map[string]string{"type": "none", "o": "bind", "device": "/"},
+			}},
+		}}},
+		"volume device": {Mounts: []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: "disk",
+			Target: "/disk",
+			VolumeOptions: &mount.VolumeOptions{DriverConfig: &mount.Driver{
+				Name:    "local",
+				Options: map[string]string{"type": "ext4", "device": "/dev/sda1"},
+			}},
+		}}},
+	}
+	for name, hostConfig := range unsafe {
+		hostConfig := hostConfig
+		config := newSecurityPolicyTestConfig("/srv/data")
+		config.Execution.Launch.HostConfig = &hostConfig
+		assertSecurityPolicyViolation(t, config, name)
+	}
+
+	safe := container.HostConfig{
+		NetworkMode: "bridge",
+		CapDrop:     []string{"ALL"},
+		CapAdd:      []string{"cap_net_bind_service"},
+		Binds:       []string{"/srv/data/shared:/shared:ro", "home:/home"},
+		SecurityOpt: []string{"no-new-privileges"},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: "/srv/data", Target: "/data"},
+			{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+			{
+				Type:   mount.TypeVolume,
+				Source: "nfs",
+				Target: "/nfs",
+				VolumeOptions: &mount.VolumeOptions{DriverConfig: &mount.Driver{
+					Name:    "other",
+					Options: map[string]string{"device": "server:/export"},
+				}},
+			},
+		},
+	}
+	config := newSecurityPolicyTestConfig("/srv/data")
+	config.Execution.Launch.HostConfig = &safe
+	assert.NoError(t, config.Validate())
+
+	// Without a policy the same settings are accepted.
+	config = newSecurityPolicyTestConfig()
+	config.Execution.SecurityPolicy.Mode = docker.SecurityPolicyModeOff
+	config.Execution.Launch.HostConfig = &container.HostConfig{Privileged: true}
+	assert.NoError(t, config.Validate())
+}
+
+// TestSecurityPolicyDockerSocket tests if the Docker socket and its parent directories cannot be mounted even if they
+// are allowed.
+func TestSecurityPolicyDockerSocket(t *testing.T) {
+	t.Parallel()
+
+	for _, source := range []string{"/", "/var", "/var/run", "/run/", "/var/run/docker.sock", "/home/docker.sock"} {
+		config := newSecurityPolicyTestConfig("/")
+		config.Execution.Launch.HostConfig = &container.HostConfig{Binds: []string{source + ":/host"}}
+		assertSecurityPolicyViolation(t, config, source)
+	}
+	for _, source := range []string{"/var/lib", "/run/lock"} {
+		config := newSecurityPolicyTestConfig("/")
+		config.Execution.Launch.HostConfig = &container.HostConfig{Binds: []string{source + ":/host"}}
+		assert.NoError(t, config.Validate(), source)
+	}
+}
+
+// TestSecurityPolicyChecksAllContainers tests if the sidecars, the egress proxy and the capabilities added by the
+// hardening preset are subject to the policy.
+func TestSecurityPolicyChecksAllContainers(t *testing.T) {
+	t.Parallel()
+
+	privileged := docker.LaunchConfig{
+		ContainerConfig: &container.Config{Image: "test"},
+		HostConfig:      &container.HostConfig{Privileged: true},
+	}
+
+	config := newSecurityPolicyTestConfig()
+	config.Execution.Sidecars = []docker.LaunchConfig{privileged}
+	assertSecurityPolicyViolation(t, config, "")
+
+	config = newSecurityPolicyTestConfig()
+	config.Execution.IsolatedNetwork.Enable = true
+	config.Execution.IsolatedNetwork.EgressProxy = &privileged
+	assertSecurityPolicyViolation(t, config, "")
+
+	config = newSecurityPolicyTestConfig()
+	config.Execution.Hardening.Enable = true
+	config.Execution.Hardening.Capabilities = []string{"CHOWN", "NET_BIND_SERVICE"}
+	assertSecurityPolicyViolation(t, config, "")
+	config.Execution.SecurityPolicy.AllowedCapabilities = []string{"CHOWN", "NET_BIND_SERVICE"}
+	assert.NoError(t, config.Validate())
+}
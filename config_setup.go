@@ -0,0 +1,42 @@
+package docker
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// SetupConfig configures commands that prepare the container before the first session of a connection.
+type SetupConfig struct {
+	// Commands are run one after the other after the container has started. Only supported in execution mode
+	// "connection".
+	Commands []SetupCommand `json:"commands,omitempty" yaml:"commands,omitempty"`
+	// FailureMessage is shown to the user if a setup command fails.
+	FailureMessage string `json:"failureMessage" yaml:"failureMessage" default:"Failed to prepare your environment, please contact your administrator."`
+}
+
+// SetupCommand is a single command run in the container during setup.
+type SetupCommand struct {
+	// Program is the program and its arguments. Each element is a Go template that can reference {{ .Username }} and
+	// {{ .ConnectionID }}. The rendered values are not quoted, so templates must not be used inside shell scripts
+	// such as the argument of "sh -c". Scripts should read the CONTAINERSSH_USERNAME and CONTAINERSSH_CONNECTION_ID
+	// environment variables instead.
+	Program []string `json:"program" yaml:"program"`
+	// Env contains additional environment variables for the program. CONTAINERSSH_USERNAME and
+	// CONTAINERSSH_CONNECTION_ID are always set by ContainerSSH and cannot be overridden.
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+}
+
+// Validate checks the setup configuration for errors.
+func (s SetupConfig) Validate() error {
+	for i, command := range s.Commands {
+		if len(command.Program) == 0 {
+			return fmt.Errorf("setup command %d has no program", i)
+		}
+		for _, arg := range command.Program {
+			if _, err := template.New("program").Parse(arg); err != nil {
+				return fmt.Errorf("invalid template in setup command %d (%w)", i, err)
+			}
+		}
+	}
+	return nil
+}
@@ -126,3 +126,79 @@ func TestLaunchInlineUnmarshal(t *testing.T) {
 		t.Fatal("image is not set in output")
 	}
 }
+
+// TestAuditRequiresDestination tests if an enabled audit trail without a file or sink is rejected.
+func TestAuditRequiresDestination(t *testing.T) {
+	t.Parallel()
+
+	config := docker.Config{}
+	structutils.Defaults(&config)
+	assert.NoError(t, config.Validate())
+
+	config.Audit.Enable = true
+	assert.Error(t, config.Validate())
+
+	config.Audit.File = "/var/log/containerssh/audit.log"
+	assert.NoError(t, config.Validate())
+}
+
+// TestSidecarValidation tests if sidecars are rejected in the session mode, without an image, and in the isolated
+// network mode without an isolated network.
+func TestSidecarValidation(t *testing.T) {
+	t.Parallel()
+
+	config := docker.Config{}
+	structutils.Defaults(&config)
+	config.Execution.Sidecars = []docker.LaunchConfig{{ContainerConfig: &container.Config{Image: "redis"}}}
+	assert.NoError(t, config.Validate())
+
+	config.Execution.SidecarNetwork = docker.SidecarNetworkModeIsolated
+	assert.Error(t, config.Validate())
+	config.Execution.IsolatedNetwork.Enable = true
+	assert.NoError(t, config.Validate())
+
+	config.Execution.Sidecars = []docker.LaunchConfig{{ContainerConfig: &container.Config{}}}
+	assert.Error(t, config.Validate())
+
+	config.Execution.Sidecars = []docker.LaunchConfig{{ContainerConfig: &container.Config{Image: "redis"}}}
+	config.Execution.Mode = docker.ExecutionModeSession
+	assert.Error(t, config.Validate())
+}
+
+// TestLimitsValidation tests if negative container limits are rejected and 0 means unlimited.
+func TestLimitsValidation(t *testing.T) {
+	t.Parallel()
+
+	config := docker.Config{}
+	structutils.Defaults(&config)
+	assert.NoError(t, config.Validate())
+
+	config.Limits = docker.LimitsConfig{MaxContainersPerUser: 1, MaxContainersPerIP: 2, MaxContainersPerHost: 3}
+	assert.NoError(t, config.Validate())
+
+	for _, limits := range []docker.LimitsConfig{
+		{MaxContainersPerUser: -1},
+		{MaxContainersPerIP: -1},
+		{MaxContainersPerHost: -1},
+	} {
+		config.Limits = limits
+		assert.Error(t, config.Validate(), limits)
+	}
+}
+
+// TestRateLimitValidation tests if negative rates and bursts are rejected.
+func TestRateLimitValidation(t *testing.T) {
+	t.Parallel()
+
+	config := docker.Config{}
+	structutils.Defaults(&config)
+	config.RateLimit.PerIP = docker.RateLimit{PerMinute: 0.5, Burst: 2}
+	assert.NoError(t, config.Validate())
+
+	config.RateLimit.PerIP = docker.RateLimit{PerMinute: -1}
+	assert.Error(t, config.Validate())
+
+	config.RateLimit.PerIP = docker.RateLimit{}
+	config.RateLimit.PerUser = docker.RateLimit{PerMinute: 1, Burst: -1}
+	assert.Error(t, config.Validate())
+}
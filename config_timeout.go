@@ -20,6 +20,21 @@ type TimeoutConfig struct {
 	Window time.Duration `json:"window" yaml:"window" default:"60s"`
 	// HTTP
 	HTTP time.Duration `json:"http" yaml:"http" default:"15s"`
+	// Setup is the maximum time all setup commands of a connection may take together.
+	Setup time.Duration `json:"setup" yaml:"setup" default:"300s"`
+	// Linger is the time the shared container is kept running after the last connection of the user has closed in
+	// execution mode "user".
+	Linger time.Duration `json:"linger" yaml:"linger" default:"0s"`
+	// Detached is the time a detached session is kept before its program is killed.
+	Detached time.Duration `json:"detached" yaml:"detached" default:"1h"`
+	// Drain is the time active connections are given to finish after draining has started before their
+	// sessions are closed.
+	Drain time.Duration `json:"drain" yaml:"drain" default:"5m"`
+	// Adopt is the time a container adopted after a restart waits for its user to reconnect before it is
+	// removed.
+	Adopt time.Duration `json:"adopt" yaml:"adopt" default:"10m"`
+	// Readiness is the time each readiness check is retried before the connection is rejected.
+	Readiness time.Duration `json:"readiness" yaml:"readiness" default:"60s"`
 }
 
 type tmpTimeoutConfig struct {
@@ -35,6 +50,21 @@ type tmpTimeoutConfig struct {
 	Window interface{} `json:"window" yaml:"window" default:"60s"`
 	// HTTP
 	HTTP interface{} `json:"http" yaml:"http" default:"15s"`
+	// Setup is the maximum time all setup commands of a connection may take together.
+	Setup interface{} `json:"setup" yaml:"setup" default:"300s"`
+	// Linger is the time the shared container is kept running after the last connection of the user has closed in
+	// execution mode "user".
+	Linger interface{} `json:"linger" yaml:"linger" default:"0s"`
+	// Detached is the time a detached session is kept before its program is killed.
+	Detached interface{} `json:"detached" yaml:"detached" default:"1h"`
+	// Drain is the time active connections are given to finish after draining has started before their
+	// sessions are closed.
+	Drain interface{} `json:"drain" yaml:"drain" default:"5m"`
+	// Adopt is the time a container adopted after a restart waits for its user to reconnect before it is
+	// removed.
+	Adopt interface{} `json:"adopt" yaml:"adopt" default:"10m"`
+	// Readiness is the time each readiness check is retried before the connection is rejected.
+	Readiness interface{} `json:"readiness" yaml:"readiness" default:"60s"`
 }
 
 // UnmarshalJSON takes a JSON byte array and unmarshalls it into a structure.
@@ -77,5 +107,23 @@ func (t *TimeoutConfig) unmarshalTmp(tmp *tmpTimeoutConfig) error {
 	if err := parseRawDuration(tmp.HTTP, &t.HTTP); err != nil {
 		return err
 	}
+	if err := parseRawDuration(tmp.Setup, &t.Setup); err != nil {
+		return err
+	}
+	if err := parseRawDuration(tmp.Linger, &t.Linger); err != nil {
+		return err
+	}
+	if err := parseRawDuration(tmp.Detached, &t.Detached); err != nil {
+		return err
+	}
+	if err := parseRawDuration(tmp.Drain, &t.Drain); err != nil {
+		return err
+	}
+	if err := parseRawDuration(tmp.Adopt, &t.Adopt); err != nil {
+		return err
+	}
+	if err := parseRawDuration(tmp.Readiness, &t.Readiness); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// UserMappingMode determines how the user the sessions run as is derived from the SSH username.
+type UserMappingMode string
+
+const (
+	// UserMappingModeNone runs sessions as the user configured in the container config.
+	UserMappingModeNone UserMappingMode = ""
+	// UserMappingModeUsername runs sessions as the SSH username.
+	UserMappingModeUsername UserMappingMode = "username"
+	// UserMappingModeTemplate renders the Template into the user, for example "{{ .Username }}" or "1000:1000".
+	UserMappingModeTemplate UserMappingMode = "template"
+	// UserMappingModeTable looks up the user in the Table and falls back to Default.
+	UserMappingModeTable UserMappingMode = "table"
+)
+
+// Validate checks if the user mapping mode is valid.
+func (u UserMappingMode) Validate() error {
+	switch u {
+	case UserMappingModeNone:
+	case UserMappingModeUsername:
+	case UserMappingModeTemplate:
+	case UserMappingModeTable:
+	default:
+		return fmt.Errorf("invalid user mapping mode: %s", u)
+	}
+	return nil
+}
+
+// UserMappingConfig configures the user sessions run as. The user can be a name or a uid[:gid] pair. Names must
+// start with a lowercase letter or underscore followed by lowercase letters, digits, underscores or dashes. Users
+// mapping to root or uid 0 are rejected unless AllowRoot is set.
+//
+// In execution mode "connection" the mapped user applies to the execs of the sessions, while the container itself
+// keeps running as the configured container user so the user can be created after the container has started. In
+// execution mode "session" the mapped user is the user of the container and must already exist in the image.
+type UserMappingConfig struct {
+	// Mode determines how the user is derived from the SSH username.
+	Mode UserMappingMode `json:"mode" yaml:"mode"`
+	// Template is a Go template rendered into the user in the "template" mode. The template can reference
+	// {{ .Username }} and {{ .ConnectionID }}.
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+	// Table maps SSH usernames to users in the "table" mode.
+	Table map[string]string `json:"table,omitempty" yaml:"table,omitempty"`
+	// Default is the user for SSH usernames missing from the Table. Sessions run as the container user if empty.
+	Default string `json:"default,omitempty" yaml:"default,omitempty"`
+	// AllowRoot permits sessions to run as root or uid 0. In the "username" and "template" modes the SSH username
+	// decides the user, so a client logging in as root would otherwise get root in the container.
+	AllowRoot bool `json:"allowRoot" yaml:"allowRoot"`
+	// CreateUser runs the CreateUserCommand as the container user before the first session to create the mapped user
+	// if it does not exist. Only supported in execution mode "connection" and for user names, not numeric IDs.
+	CreateUser bool `json:"createUser" yaml:"createUser"`
+	// CreateUserCommand creates the user passed in the CONTAINERSSH_USER environment variable if it is missing.
+	CreateUserCommand []string `json:"createUserCommand" yaml:"createUserCommand" default:"[\"/bin/sh\", \"-c\", \"id -- \\\"$CONTAINERSSH_USER\\\" >/dev/null 2>&1 || useradd -m -- \\\"$CONTAINERSSH_USER\\\" || adduser -D -- \\\"$CONTAINERSSH_USER\\\"\"]"`
+}
+
+// Validate checks the user mapping configuration for errors.
+func (u UserMappingConfig) Validate() error {
+	if err := u.Mode.Validate(); err != nil {
+		return err
+	}
+	if u.Mode == UserMappingModeTemplate {
+		if u.Template == "" {
+			return fmt.Errorf("user mapping mode \"template\" requires a template")
+		}
+		if _, err := template.New("user").Parse(u.Template); err != nil {
+			return fmt.Errorf("invalid user template (%w)", err)
+		}
+	}
+	for username, user := range u.Table {
+		if err := validateMappedUser(user, u.AllowRoot); err != nil {
+			return fmt.Errorf("invalid user for %s in the user mapping table (%w)", username, err)
+		}
+	}
+	if u.Default != "" {
+		if err := validateMappedUser(u.Default, u.AllowRoot); err != nil {
+			return fmt.Errorf("invalid default user (%w)", err)
+		}
+	}
+	if u.CreateUser && len(u.CreateUserCommand) == 0 {
+		return fmt.Errorf("creating users is enabled but no create user command is configured")
+	}
+	return nil
+}
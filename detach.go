@@ -0,0 +1,414 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// detachedSessions is the process-wide registry of detachable sessions, keyed by username and session name.
+var detachedSessions = struct {
+	lock    *sync.Mutex
+	entries map[string]*detachableSession
+}{
+	lock:    &sync.Mutex{},
+	entries: map[string]*detachableSession{},
+}
+
+func detachedSessionKey(username string, name string) string {
+	return username + "\x00" + name
+}
+
+// detachableSession is a program that keeps running in the shared container of the user when the channel it was
+// started from is closed. Its output is buffered while no channel is attached.
+type detachableSession struct {
+	key      string
+	name     string
+	username string
+	// exec is the program running in the container.
+	exec dockerExecution
+	// stdin feeds the input of the attached channel to the program.
+	stdin *io.PipeWriter
+	// container is the shared container the program runs in. The session holds a reference so the container is not
+	// removed while the session exists.
+	container  *userContainer
+	bufferSize int
+	timeout    time.Duration
+	linger     time.Duration
+	logger     log.Logger
+
+	// outputLock orders the writes to the attached channel and the recording. It is acquired before the lock and
+	// held while writing, so the lock is never held during a write that may block.
+	outputLock *sync.Mutex
+	// lock protects the fields below.
+	lock       *sync.Mutex
+	buffer     []byte
+	attachment *sessionAttachment
+	exited     bool
+	exitStatus int
+	removed    bool
+	expiry     *time.Timer
+	// recorder records the session from the first attached channel until the session is removed, including the
+	// output while detached.
+	recorder sessionRecorder
+}
+
+// findDetachableSession returns the session of the user with the given name, or nil if there is none.
+func findDetachableSession(username string, name string) *detachableSession {
+	detachedSessions.lock.Lock()
+	defer detachedSessions.lock.Unlock()
+	return detachedSessions.entries[detachedSessionKey(username, name)]
+}
+
+// reserveDetachableSession registers a new detachable session with the given name. The session is returned
+// attached to the calling channel so it cannot be claimed by another channel before the program is started.
+func (n *networkHandler) reserveDetachableSession(name string) (*sessionAttachment, error) {
+	s := &detachableSession{
+		key:        detachedSessionKey(n.username, name),
+		name:       name,
+		username:   n.username,
+		container:  n.userContainer,
+		bufferSize: n.config.Execution.Detach.BufferSize,
+		timeout:    n.config.Timeouts.Detached,
+		linger:     n.config.Timeouts.Linger,
+		logger:     n.logger,
+		outputLock: &sync.Mutex{},
+		lock:       &sync.Mutex{},
+	}
+	detachedSessions.lock.Lock()
+	if _, ok := detachedSessions.entries[s.key]; ok {
+		detachedSessions.lock.Unlock()
+		return nil, log.UserMessage(
+			ESessionExists,
+			"a session with this name already exists",
+			"detachable session %s of user %s already exists",
+			name,
+			n.username,
+		)
+	}
+	detachedSessions.entries[s.key] = s
+	detachedSessions.lock.Unlock()
+	s.container.retain()
+	return s.claim()
+}
+
+// start starts the program of a reserved session. The output is buffered until the channel attaches.
+func (s *detachableSession) start(exec dockerExecution) {
+	stdinReader, stdinWriter := io.Pipe()
+	s.exec = exec
+	s.stdin = stdinWriter
+	s.logger.Debug(log.NewMessage(MSessionDetach, "Starting detachable session %s...", s.name).Label("session", s.name))
+	exec.run(
+		stdinReader,
+		&detachableOutput{session: s},
+		&detachableOutput{session: s, stderr: true},
+		func() error {
+			return nil
+		},
+		s.onExit,
+	)
+}
+
+// claim creates the attachment of a channel. Only one channel can be attached at a time.
+func (s *detachableSession) claim() (*sessionAttachment, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.removed {
+		return nil, log.UserMessage(
+			ESessionNotFound,
+			"session not found",
+			"detachable session %s of user %s not found",
+			s.name,
+			s.username,
+		)
+	}
+	if s.attachment != nil {
+		return nil, log.UserMessage(
+			ESessionAttached,
+			"the session is already attached",
+			"detachable session %s of user %s is already attached",
+			s.name,
+			s.username,
+		)
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.attachment = &sessionAttachment{
+		session:     s,
+		doneChannel: make(chan struct{}),
+	}
+	return s.attachment, nil
+}
+
+// attach connects the streams of the channel and replays the buffered output.
+func (s *detachableSession) attach(
+	a *sessionAttachment,
+	stdin io.Reader,
+	stdout io.Writer,
+	stderr io.Writer,
+	writeClose func() error,
+	onExit func(exitStatus int),
+) {
+	s.outputLock.Lock()
+	s.lock.Lock()
+	if s.attachment != a {
+		s.lock.Unlock()
+		s.outputLock.Unlock()
+		return
+	}
+	a.stdout = stdout
+	a.stderr = stderr
+	a.writeClose = writeClose
+	a.onExit = onExit
+	a.running = true
+	buffer := s.buffer
+	exited := s.exited
+	exitStatus := s.exitStatus
+	if exited {
+		s.attachment = nil
+	}
+	s.lock.Unlock()
+	// The output lock keeps new output from overtaking the replayed buffer.
+	if len(buffer) > 0 {
+		_, _ = stdout.Write(buffer)
+	}
+	s.outputLock.Unlock()
+	if exited {
+		a.finish(exitStatus)
+		s.remove()
+		return
+	}
+	s.logger.Debug(log.NewMessage(MSessionDetach, "Attached detachable session %s.", s.name).Label("session", s.name))
+	go a.copyInput(stdin)
+}
+
+// detach disconnects the channel from the session. The program keeps running until the detach timeout has passed.
+func (s *detachableSession) detach(a *sessionAttachment) {
+	s.lock.Lock()
+	if s.attachment != a {
+		s.lock.Unlock()
+		return
+	}
+	s.attachment = nil
+	close(a.doneChannel)
+	s.expiry = time.AfterFunc(s.timeout, s.expire)
+	s.lock.Unlock()
+	s.logger.Debug(log.NewMessage(MSessionDetach, "Detached session %s.", s.name).Label("session", s.name))
+}
+
+func (s *detachableSession) onExit(exitStatus int) {
+	_ = s.stdin.Close()
+	// Wait for a replay of the buffer in progress so the exit status is delivered after the output.
+	s.outputLock.Lock()
+	s.lock.Lock()
+	s.exited = true
+	s.exitStatus = exitStatus
+	a := s.attachment
+	if a == nil || !a.running {
+		// The exit status is delivered when the session is attached again.
+		s.lock.Unlock()
+		s.outputLock.Unlock()
+		return
+	}
+	s.attachment = nil
+	s.lock.Unlock()
+	s.outputLock.Unlock()
+	a.finish(exitStatus)
+	s.remove()
+}
+
+// expire kills the program of a session that has not been reattached in time.
+func (s *detachableSession) expire() {
+	s.lock.Lock()
+	if s.attachment != nil || s.removed {
+		s.lock.Unlock()
+		return
+	}
+	exited := s.exited
+	s.lock.Unlock()
+	s.logger.Debug(
+		log.NewMessage(MSessionDetach, "Detached session %s has expired, removing...", s.name).Label("session", s.name),
+	)
+	if !exited {
+		s.exec.kill()
+	}
+	s.remove()
+}
+
+// remove removes the session from the registry, finishes the recording, and releases the shared container.
+func (s *detachableSession) remove() {
+	detachedSessions.lock.Lock()
+	if detachedSessions.entries[s.key] == s {
+		delete(detachedSessions.entries, s.key)
+	}
+	detachedSessions.lock.Unlock()
+	s.lock.Lock()
+	if s.removed {
+		s.lock.Unlock()
+		return
+	}
+	s.removed = true
+	s.buffer = nil
+	recorder := s.recorder
+	s.recorder = nil
+	s.lock.Unlock()
+	if recorder != nil {
+		recorder.close()
+	}
+	s.container.release(s.username, s.linger, s.logger)
+}
+
+// detachableOutput keeps the most recent output of the session and copies it to the attached channel.
+type detachableOutput struct {
+	session *detachableSession
+	stderr  bool
+}
+
+func (o *detachableOutput) Write(p []byte) (int, error) {
+	s := o.session
+	s.outputLock.Lock()
+	defer s.outputLock.Unlock()
+	s.lock.Lock()
+	s.buffer = append(s.buffer, p...)
+	if len(s.buffer) > s.bufferSize {
+		s.buffer = s.buffer[len(s.buffer)-s.bufferSize:]
+	}
+	var target io.Writer
+	if a := s.attachment; a != nil && a.running {
+		target = a.stdout
+		if o.stderr {
+			target = a.stderr
+		}
+	}
+	recorder := s.recorder
+	s.lock.Unlock()
+	if target != nil {
+		// A failed write means the channel is going away; the output is kept in the buffer.
+		_, _ = target.Write(p)
+	}
+	if recorder != nil {
+		_, _ = recorder.output().Write(p)
+	}
+	return len(p), nil
+}
+
+// sessionAttachment is the execution seen by a channel attached to a detachable session. Closing the channel detaches
+// the session instead of terminating the program.
+type sessionAttachment struct {
+	session     *detachableSession
+	doneChannel chan struct{}
+
+	// The fields below are protected by the session lock.
+	running    bool
+	stdout     io.Writer
+	stderr     io.Writer
+	writeClose func() error
+	onExit     func(exitStatus int)
+}
+
+func (a *sessionAttachment) resize(ctx context.Context, height uint, width uint) error {
+	s := a.session
+	s.lock.Lock()
+	recorder := s.recorder
+	s.lock.Unlock()
+	if recorder != nil {
+		recorder.resize(width, height)
+	}
+	return s.exec.resize(ctx, height, width)
+}
+
+func (a *sessionAttachment) signal(ctx context.Context, sig string) error {
+	return a.session.exec.signal(ctx, sig)
+}
+
+// record records the session through its output, so the output produced while detached is recorded as well. The
+// recording of the channel that started the session is kept until the session is removed. Channels attaching later
+// are not recorded separately.
+func (a *sessionAttachment) record(recorder sessionRecorder) {
+	s := a.session
+	s.outputLock.Lock()
+	defer s.outputLock.Unlock()
+	s.lock.Lock()
+	if s.recorder != nil || s.removed {
+		s.lock.Unlock()
+		recorder.close()
+		return
+	}
+	s.recorder = recorder
+	// The program has already been started, its output so far is in the buffer.
+	buffer := s.buffer
+	s.lock.Unlock()
+	if len(buffer) > 0 {
+		_, _ = recorder.output().Write(buffer)
+	}
+}
+
+func (a *sessionAttachment) run(
+	stdin io.Reader,
+	stdout io.Writer,
+	stderr io.Writer,
+	writeClose func() error,
+	onExit func(exitStatus int),
+) {
+	a.session.attach(a, stdin, stdout, stderr, writeClose, onExit)
+}
+
+func (a *sessionAttachment) done() <-chan struct{} {
+	return a.doneChannel
+}
+
+func (a *sessionAttachment) term(_ context.Context) {
+	a.session.detach(a)
+}
+
+func (a *sessionAttachment) kill() {
+	a.session.detach(a)
+}
+
+func (a *sessionAttachment) finish(exitStatus int) {
+	_ = a.writeClose()
+	a.onExit(exitStatus)
+	close(a.doneChannel)
+}
+
+// copyInput feeds the input of the channel to the program while the channel is attached. The end of the input is not
+// forwarded so the program keeps running after the channel is closed.
+func (a *sessionAttachment) copyInput(stdin io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			a.session.lock.Lock()
+			attached := a.session.attachment == a
+			recorder := a.session.recorder
+			a.session.lock.Unlock()
+			if !attached {
+				return
+			}
+			if _, err := a.session.stdin.Write(buf[:n]); err != nil {
+				return
+			}
+			if recorder != nil {
+				_, _ = recorder.input().Write(buf[:n])
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseAttachCommand returns the session name if the command requests attaching a detached session.
+func parseAttachCommand(attachCommand string, command string) (string, bool) {
+	fields := strings.Fields(command)
+	if len(fields) != 2 || fields[0] != attachCommand {
+		return "", false
+	}
+	return fields[1], true
+}
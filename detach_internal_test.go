@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDetachedExecution hands out the streams of the session to the test instead of running a program.
+type fakeDetachedExecution struct {
+	dockerExecution
+
+	stdout io.Writer
+	onExit func(exitStatus int)
+}
+
+func (f *fakeDetachedExecution) run(
+	_ io.Reader,
+	stdout io.Writer,
+	_ io.Writer,
+	_ func() error,
+	onExit func(exitStatus int),
+) {
+	f.stdout = stdout
+	f.onExit = onExit
+}
+
+// fakeRecorder collects the recorded output.
+type fakeRecorder struct {
+	lock   *sync.Mutex
+	out    *bytes.Buffer
+	closed bool
+}
+
+func (f *fakeRecorder) output() io.Writer {
+	return &lockedWriter{lock: f.lock, writer: f.out}
+}
+
+func (f *fakeRecorder) input() io.Writer {
+	return ioutil.Discard
+}
+
+func (f *fakeRecorder) resize(_ uint, _ uint) {}
+
+func (f *fakeRecorder) close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+}
+
+func (f *fakeRecorder) state() (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.out.String(), f.closed
+}
+
+type lockedWriter struct {
+	lock   *sync.Mutex
+	writer io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.writer.Write(p)
+}
+
+// blockingWriter blocks writes until it is released, like an SSH channel without window space.
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return len(p), nil
+}
+
+func newTestDetachableSession(t *testing.T) (*detachableSession, *fakeDetachedExecution) {
+	s := &detachableSession{
+		key:        "test",
+		name:       "test",
+		username:   "detach-test",
+		container:  &userContainer{refs: 10},
+		bufferSize: 1024,
+		timeout:    time.Minute,
+		logger:     log.NewTestLogger(t),
+		outputLock: &sync.Mutex{},
+		lock:       &sync.Mutex{},
+	}
+	exec := &fakeDetachedExecution{}
+	s.start(exec)
+	return s, exec
+}
+
+// TestDetachedSessionRecording tests if the output of a detached session is recorded and the recording is only
+// finished when the session is removed.
+func TestDetachedSessionRecording(t *testing.T) {
+	t.Parallel()
+
+	s, exec := newTestDetachableSession(t)
+	_, _ = exec.stdout.Write([]byte("before "))
+	a, err := s.claim()
+	assert.NoError(t, err)
+	recorder := &fakeRecorder{lock: &sync.Mutex{}, out: &bytes.Buffer{}}
+	a.record(recorder)
+	a.run(bytes.NewReader(nil), ioutil.Discard, ioutil.Discard, func() error { return nil }, func(int) {})
+
+	_, _ = exec.stdout.Write([]byte("attached "))
+	a.term(context.Background())
+	_, _ = exec.stdout.Write([]byte("detached"))
+	output, closed := recorder.state()
+	assert.Equal(t, "before attached detached", output)
+	assert.False(t, closed)
+
+	// A channel attaching later does not replace the recording.
+	b, err := s.claim()
+	assert.NoError(t, err)
+	second := &fakeRecorder{lock: &sync.Mutex{}, out: &bytes.Buffer{}}
+	b.record(second)
+	_, closed = second.state()
+	assert.True(t, closed)
+
+	s.remove()
+	_, closed = recorder.state()
+	assert.True(t, closed)
+}
+
+// TestDetachedSessionBlockedChannel tests if a channel that does not accept output does not block detaching.
+func TestDetachedSessionBlockedChannel(t *testing.T) {
+	t.Parallel()
+
+	s, exec := newTestDetachableSession(t)
+	a, err := s.claim()
+	assert.NoError(t, err)
+	channel := &blockingWriter{release: make(chan struct{})}
+	a.run(bytes.NewReader(nil), channel, channel, func() error { return nil }, func(int) {})
+
+	written := make(chan struct{})
+	go func() {
+		_, _ = exec.stdout.Write([]byte("output"))
+		close(written)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	detached := make(chan struct{})
+	go func() {
+		a.term(context.Background())
+		close(detached)
+	}()
+	select {
+	case <-detached:
+	case <-time.After(5 * time.Second):
+		t.Fatal("detaching blocked on the channel write")
+	}
+	close(channel.release)
+	<-written
+	s.remove()
+}
@@ -5,14 +5,16 @@ import (
 	"io"
 
 	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
 )
 
 // dockerClientFactory creates a dockerClient based on a configuration
 type dockerClientFactory interface {
 	// get takes a configuration and returns a docker client if the configuration was populated.
 	// Returns an error if the configuration is invalid. Returns errDockerClientNotConfigured if the specific client is
-	// not configured
-	get(ctx context.Context, config Config, logger log.Logger) (dockerClient, error)
+	// not configured. The audit logger receives the operations performed by the client and may be nil.
+	get(ctx context.Context, config Config, logger log.Logger, audit *auditLogger) (dockerClient, error)
 }
 
 // dockerClient is a simplified representation of a docker client.
@@ -37,10 +39,67 @@ type dockerClient interface {
 		tty *bool,
 		cmd []string,
 	) (dockerContainer, error)
+
+	// findImage returns the ID of the most recently created image that has all the specified labels. Returns an empty
+	// string if no such image exists.
+	findImage(ctx context.Context, labels map[string]string) (string, error)
+
+	// pruneImages removes the images that have all the specified labels except for the keep most recently created
+	// ones.
+	pruneImages(ctx context.Context, labels map[string]string, keep int) error
+
+	// findContainers returns the running and stopped containers that have all the specified labels. An empty label
+	// value matches any value.
+	findContainers(ctx context.Context, labels map[string]string) ([]foundContainer, error)
+
+	// useImage replaces the configured image for all subsequent operations of this client.
+	useImage(image string)
+
+	// createVolume creates a named volume unless it already exists.
+	createVolume(
+		ctx context.Context,
+		name string,
+		driver string,
+		driverOptions map[string]string,
+		labels map[string]string,
+	) error
+
+	// addMount adds a mount to all containers subsequently created by this client.
+	addMount(volumeMount mount.Mount)
+
+	// createNetwork creates a network with the specified name. Returns false if a network with the same name already
+	// exists.
+	createNetwork(ctx context.Context, name string, options types.NetworkCreate) (bool, error)
+
+	// usedSubnets returns the subnets of all existing networks in CIDR notation.
+	usedSubnets(ctx context.Context) ([]string, error)
+
+	// removeNetwork removes the network with the specified name.
+	removeNetwork(ctx context.Context, name string) error
+
+	// useUser sets the user the main process of all subsequently created containers runs as.
+	useUser(user string)
+
+	// useResources merges the resource profile into the resource limits of all subsequently created containers.
+	useResources(profile ResourceProfile)
+
+	// useNetwork attaches all containers subsequently created by this client to the specified network instead of the
+	// configured network settings.
+	useNetwork(name string)
+}
+
+// foundContainer is a container discovered on the Docker daemon that was not created by this client.
+type foundContainer struct {
+	container dockerContainer
+	labels    map[string]string
+	running   bool
 }
 
 // dockerContainer is the representation of a created container.
 type dockerContainer interface {
+	// getID returns the ID of the container.
+	getID() string
+
 	// attach attaches to the container on the main console.
 	attach(ctx context.Context) (dockerExecution, error)
 
@@ -48,11 +107,43 @@ type dockerContainer interface {
 	start(ctx context.Context) error
 
 	// createExec creates an execution process for the given program with the given parameters. The passed context is
-	// the start context.
-	createExec(ctx context.Context, program []string, env map[string]string, tty bool) (dockerExecution, error)
+	// the start context. The program runs as the specified user, or as the container user if empty.
+	createExec(
+		ctx context.Context,
+		program []string,
+		env map[string]string,
+		tty bool,
+		user string,
+	) (dockerExecution, error)
+
+	// statPath returns the file information of the specified path in the container. Returns an error if the path
+	// does not exist.
+	statPath(ctx context.Context, path string) (types.ContainerPathStat, error)
+
+	// copyFrom returns a tar archive of the specified path in the container and the file information of the path.
+	// The caller must close the returned reader.
+	copyFrom(ctx context.Context, path string) (io.ReadCloser, types.ContainerPathStat, error)
+
+	// copyTo extracts the tar archive into the specified directory in the container. If useContainerUser is true the
+	// extracted files are owned by the user of the container, otherwise by the owner recorded in the archive.
+	copyTo(ctx context.Context, directory string, archive io.Reader, useContainerUser bool) error
+
+	// commit creates an image with the specified reference and labels from the current state of the container and
+	// returns the image ID.
+	commit(ctx context.Context, reference string, labels map[string]string, pause bool) (string, error)
+
+	// connectNetwork connects the container to an additional network with the specified aliases.
+	connectNetwork(ctx context.Context, network string, aliases []string) error
+
+	// inspect returns the current state and settings of the container.
+	inspect(ctx context.Context) (types.ContainerJSON, error)
 
 	// remove removes the container within the given context.
 	remove(ctx context.Context) error
+
+	// forConnection returns a view of the same container that uses the logger and the audit logger of another
+	// connection, such as a connection joining the shared container of a user.
+	forConnection(logger log.Logger, audit *auditLogger) dockerContainer
 }
 
 // dockerExecution is an execution process on either an "exec" process or attached to the main console of a container.
@@ -62,6 +153,9 @@ type dockerExecution interface {
 	// signal sends the given signal to the currently running process. Returns an error if the process is not running,
 	// the signal is not known or permitted, or the process ID is not known.
 	signal(ctx context.Context, sig string) error
+	// record sets a recorder that receives a copy of the streams and terminal resizes of the process. Must be called
+	// before run.
+	record(recorder sessionRecorder)
 	// run runs the process in question.
 	run(stdin io.Reader, stdout io.Writer, stderr io.Writer, writeClose func() error, onExit func(exitStatus int),)
 	// done returns a channel that is closed when the program exits.
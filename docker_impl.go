@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -17,8 +18,14 @@ import (
 	"github.com/containerssh/structutils"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
 )
 
 type dockerV20ClientFactory struct {
@@ -44,7 +51,12 @@ func (f *dockerV20ClientFactory) getDockerClient(ctx context.Context, config Con
 	return cli, nil
 }
 
-func (f *dockerV20ClientFactory) get(ctx context.Context, config Config, logger log.Logger) (dockerClient, error) {
+func (f *dockerV20ClientFactory) get(
+	ctx context.Context,
+	config Config,
+	logger log.Logger,
+	audit *auditLogger,
+) (dockerClient, error) {
 	if config.Execution.Launch.ContainerConfig == nil || config.Execution.Launch.ContainerConfig.Image == "" {
 		return nil, log.NewMessage(EConfigError, "no image name specified")
 	}
@@ -58,6 +70,7 @@ func (f *dockerV20ClientFactory) get(ctx context.Context, config Config, logger
 		config:       config,
 		dockerClient: dockerClient,
 		logger:       logger,
+		audit:        audit,
 
 		backendFailuresMetric: f.backendFailuresMetric,
 		backendRequestsMetric: f.backendRequestsMetric,
@@ -68,6 +81,7 @@ type dockerV20Client struct {
 	config       Config
 	dockerClient *client.Client
 	logger       log.Logger
+	audit        *auditLogger
 
 	// backendFailuresMetric counts the failed requests to the backend.
 	backendFailuresMetric metrics.SimpleCounter
@@ -123,6 +137,7 @@ loop:
 			if lastError == nil {
 				lastError = pullReader.Close()
 				if lastError == nil {
+					d.auditImagePull(ctx, image)
 					return nil
 				}
 			}
@@ -157,6 +172,301 @@ loop:
 	return err
 }
 
+func (d *dockerV20Client) auditImagePull(ctx context.Context, image string) {
+	if d.audit == nil {
+		return
+	}
+	digest := ""
+	d.backendRequestsMetric.Increment()
+	inspectResult, _, err := d.dockerClient.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		d.logger.Debug(log.Wrap(err, EFailedImageList, "failed to inspect pulled image %s", image))
+	} else if len(inspectResult.RepoDigests) > 0 {
+		digest = inspectResult.RepoDigests[0]
+	} else {
+		digest = inspectResult.ID
+	}
+	d.audit.emit(AuditEvent{
+		Type:        AuditEventImagePull,
+		Image:       image,
+		ImageDigest: digest,
+	})
+}
+
+func (d *dockerV20Client) findImage(ctx context.Context, labels map[string]string) (string, error) {
+	filter := filters.NewArgs()
+	for key, value := range labels {
+		filter.Add("label", key+"="+value)
+	}
+	d.backendRequestsMetric.Increment()
+	images, err := d.dockerClient.ImageList(ctx, types.ImageListOptions{Filters: filter})
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.Wrap(err, EFailedImageList, "failed to list images")
+		d.logger.Debug(err)
+		return "", err
+	}
+	imageID := ""
+	var created int64
+	for _, image := range images {
+		if imageID == "" || image.Created > created {
+			imageID = image.ID
+			created = image.Created
+		}
+	}
+	return imageID, nil
+}
+
+func (d *dockerV20Client) pruneImages(ctx context.Context, labels map[string]string, keep int) error {
+	filter := filters.NewArgs()
+	for key, value := range labels {
+		filter.Add("label", key+"="+value)
+	}
+	d.backendRequestsMetric.Increment()
+	images, err := d.dockerClient.ImageList(ctx, types.ImageListOptions{Filters: filter})
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.Wrap(err, EFailedImageList, "failed to list images")
+		d.logger.Debug(err)
+		return err
+	}
+	if len(images) <= keep {
+		return nil
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].Created > images[j].Created
+	})
+	var lastError error
+	for _, image := range images[keep:] {
+		d.logger.Debug(log.NewMessage(MImageRemove, "Removing image %s...", image.ID).Label("image", image.ID))
+		d.backendRequestsMetric.Increment()
+		if _, err := d.dockerClient.ImageRemove(
+			ctx,
+			image.ID,
+			types.ImageRemoveOptions{Force: true, PruneChildren: true},
+		); err != nil && !client.IsErrNotFound(err) {
+			d.backendFailuresMetric.Increment()
+			lastError = log.Wrap(err, EFailedImageRemove, "failed to remove image %s", image.ID).
+				Label("image", image.ID)
+			d.logger.Debug(lastError)
+		}
+	}
+	return lastError
+}
+
+func (d *dockerV20Client) findContainers(ctx context.Context, labels map[string]string) ([]foundContainer, error) {
+	filter := filters.NewArgs()
+	for key, value := range labels {
+		if value == "" {
+			filter.Add("label", key)
+		} else {
+			filter.Add("label", key+"="+value)
+		}
+	}
+	d.backendRequestsMetric.Increment()
+	containers, err := d.dockerClient.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: filter})
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.Wrap(err, EFailedContainerList, "failed to list containers")
+		d.logger.Debug(err)
+		return nil, err
+	}
+	result := make([]foundContainer, len(containers))
+	for i, cnt := range containers {
+		result[i] = foundContainer{
+			container: &dockerV20Container{
+				config:                d.config,
+				containerID:           cnt.ID,
+				dockerClient:          d.dockerClient,
+				logger:                d.logger.WithLabel("containerId", cnt.ID),
+				audit:                 d.audit,
+				backendRequestsMetric: d.backendRequestsMetric,
+				backendFailuresMetric: d.backendFailuresMetric,
+				lock:                  &sync.Mutex{},
+				wg:                    &sync.WaitGroup{},
+				state:                 &containerState{},
+				removeLock:            &sync.Mutex{},
+			},
+			labels:  cnt.Labels,
+			running: cnt.State == "running",
+		}
+	}
+	return result, nil
+}
+
+func (d *dockerV20Client) useImage(image string) {
+	// The container config is shared between connections, so it must be copied before modification.
+	containerConfig := *d.config.Execution.Launch.ContainerConfig
+	containerConfig.Image = image
+	d.config.Execution.Launch.ContainerConfig = &containerConfig
+}
+
+func (d *dockerV20Client) createVolume(
+	ctx context.Context,
+	name string,
+	driver string,
+	driverOptions map[string]string,
+	labels map[string]string,
+) error {
+	d.logger.Debug(log.NewMessage(MVolumeCreate, "Creating volume %s...", name).Label("volume", name))
+	d.backendRequestsMetric.Increment()
+	// Creating a volume that already exists with the same driver returns the existing volume.
+	_, err := d.dockerClient.VolumeCreate(
+		ctx, volume.VolumeCreateBody{
+			Name:       name,
+			Driver:     driver,
+			DriverOpts: driverOptions,
+			Labels:     labels,
+		},
+	)
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.WrapUser(
+			err,
+			EFailedVolumeCreate,
+			UserMessageInitializeSSHSession,
+			"failed to create volume %s",
+			name,
+		).Label("volume", name)
+		d.logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+func (d *dockerV20Client) addMount(volumeMount mount.Mount) {
+	// The host config is shared between connections, so it must be copied before modification.
+	hostConfig := container.HostConfig{}
+	if d.config.Execution.Launch.HostConfig != nil {
+		hostConfig = *d.config.Execution.Launch.HostConfig
+	}
+	hostConfig.Mounts = append(append([]mount.Mount{}, hostConfig.Mounts...), volumeMount)
+	d.config.Execution.Launch.HostConfig = &hostConfig
+}
+
+func (d *dockerV20Client) createNetwork(ctx context.Context, name string, options types.NetworkCreate) (bool, error) {
+	d.logger.Debug(log.NewMessage(MNetworkCreate, "Creating network %s...", name).Label("network", name))
+	options.CheckDuplicate = true
+	d.backendRequestsMetric.Increment()
+	if _, err := d.dockerClient.NetworkCreate(ctx, name, options); err != nil {
+		if errdefs.IsConflict(err) {
+			return false, nil
+		}
+		d.backendFailuresMetric.Increment()
+		err = log.WrapUser(
+			err,
+			EFailedNetworkCreate,
+			UserMessageInitializeSSHSession,
+			"failed to create network %s",
+			name,
+		).Label("network", name)
+		d.logger.Debug(err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *dockerV20Client) usedSubnets(ctx context.Context) ([]string, error) {
+	d.backendRequestsMetric.Increment()
+	networks, err := d.dockerClient.NetworkList(ctx, types.NetworkListOptions{})
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.WrapUser(err, EFailedNetworkCreate, UserMessageInitializeSSHSession, "failed to list networks")
+		d.logger.Debug(err)
+		return nil, err
+	}
+	var subnets []string
+	for _, network := range networks {
+		for _, ipamConfig := range network.IPAM.Config {
+			if ipamConfig.Subnet != "" {
+				subnets = append(subnets, ipamConfig.Subnet)
+			}
+		}
+	}
+	return subnets, nil
+}
+
+func (d *dockerV20Client) removeNetwork(ctx context.Context, name string) error {
+	d.logger.Debug(log.NewMessage(MNetworkRemove, "Removing network %s...", name).Label("network", name))
+	d.backendRequestsMetric.Increment()
+	if err := d.dockerClient.NetworkRemove(ctx, name); err != nil {
+		if client.IsErrNotFound(err) {
+			return nil
+		}
+		d.backendFailuresMetric.Increment()
+		err = log.Wrap(err, EFailedNetworkRemove, "failed to remove network %s", name).Label("network", name)
+		d.logger.Debug(err)
+		return err
+	}
+	return nil
+}
+
+func (d *dockerV20Client) useUser(user string) {
+	// The container config is shared between connections, so it must be copied before modification.
+	containerConfig := *d.config.Execution.Launch.ContainerConfig
+	containerConfig.User = user
+	d.config.Execution.Launch.ContainerConfig = &containerConfig
+}
+
+func (d *dockerV20Client) useResources(profile ResourceProfile) {
+	// The host config is shared between connections, so it must be copied before modification.
+	hostConfig := container.HostConfig{}
+	if d.config.Execution.Launch.HostConfig != nil {
+		hostConfig = *d.config.Execution.Launch.HostConfig
+	}
+	if profile.Memory != 0 {
+		hostConfig.Memory = profile.Memory
+	}
+	if profile.CPUQuota != 0 || profile.CPUPeriod != 0 {
+		// Docker rejects NanoCPUs, e.g. from the hardening preset, combined with a CPU quota or period, so a CPU
+		// limit the profile keeps is converted to a quota.
+		if hostConfig.NanoCPUs != 0 && profile.CPUQuota == 0 {
+			hostConfig.CPUQuota = hostConfig.NanoCPUs * profile.CPUPeriod / 1e9
+		}
+		hostConfig.NanoCPUs = 0
+	}
+	if profile.CPUQuota != 0 {
+		hostConfig.CPUQuota = profile.CPUQuota
+	}
+	if profile.CPUPeriod != 0 {
+		hostConfig.CPUPeriod = profile.CPUPeriod
+	}
+	if profile.CPUShares != 0 {
+		hostConfig.CPUShares = profile.CPUShares
+	}
+	if profile.PidsLimit != 0 {
+		pidsLimit := profile.PidsLimit
+		hostConfig.PidsLimit = &pidsLimit
+	}
+	if profile.StorageSize != "" {
+		storageOpt := map[string]string{}
+		for key, value := range hostConfig.StorageOpt {
+			storageOpt[key] = value
+		}
+		storageOpt["size"] = profile.StorageSize
+		hostConfig.StorageOpt = storageOpt
+	}
+	if len(profile.Ulimits) > 0 {
+		hostConfig.Ulimits = make([]*units.Ulimit, len(profile.Ulimits))
+		for i, ulimit := range profile.Ulimits {
+			hostConfig.Ulimits[i] = &units.Ulimit{Name: ulimit.Name, Soft: ulimit.Soft, Hard: ulimit.Hard}
+		}
+	}
+	d.config.Execution.Launch.HostConfig = &hostConfig
+}
+
+func (d *dockerV20Client) useNetwork(name string) {
+	// The host config is shared between connections, so it must be copied before modification.
+	hostConfig := container.HostConfig{}
+	if d.config.Execution.Launch.HostConfig != nil {
+		hostConfig = *d.config.Execution.Launch.HostConfig
+	}
+	hostConfig.NetworkMode = container.NetworkMode(name)
+	d.config.Execution.Launch.HostConfig = &hostConfig
+	d.config.Execution.Launch.NetworkConfig = nil
+}
+
 func (d *dockerV20Client) createContainer(
 	ctx context.Context,
 	labels map[string]string,
@@ -186,16 +496,19 @@ loop:
 			d.config.Execution.Launch.ContainerName,
 		)
 		if lastError == nil {
+			d.auditContainerCreate(body.ID, newConfig)
 			return &dockerV20Container{
 				config:                d.config,
 				containerID:           body.ID,
 				dockerClient:          d.dockerClient,
 				logger:                logger.WithLabel("containerId", body.ID),
+				audit:                 d.audit,
 				tty:                   newConfig.Tty,
 				backendRequestsMetric: d.backendRequestsMetric,
 				backendFailuresMetric: d.backendFailuresMetric,
 				lock:                  &sync.Mutex{},
 				wg:                    &sync.WaitGroup{},
+				state:                 &containerState{},
 				removeLock:            &sync.Mutex{},
 			}, nil
 		}
@@ -220,6 +533,21 @@ loop:
 	return nil, err
 }
 
+func (d *dockerV20Client) auditContainerCreate(containerID string, containerConfig *container.Config) {
+	if d.audit == nil {
+		return
+	}
+	auditConfig := *containerConfig
+	auditConfig.Env = envKeys(containerConfig.Env)
+	d.audit.emit(AuditEvent{
+		Type:            AuditEventContainerCreate,
+		Image:           containerConfig.Image,
+		ContainerID:     containerID,
+		ContainerConfig: &auditConfig,
+		HostConfig:      d.config.Execution.Launch.HostConfig,
+	})
+}
+
 func (d *dockerV20Client) createConfig(
 	containerConfig *container.Config,
 	labels map[string]string,
@@ -258,17 +586,37 @@ type dockerV20Container struct {
 	config                Config
 	containerID           string
 	logger                log.Logger
+	audit                 *auditLogger
 	dockerClient          *client.Client
 	tty                   bool
 	backendRequestsMetric metrics.SimpleCounter
 	backendFailuresMetric metrics.SimpleCounter
 	lock                  *sync.Mutex
 	wg                    *sync.WaitGroup
-	shuttingDown          bool
-	shutdown              bool
+	state                 *containerState
 	removeLock            *sync.Mutex
 }
 
+// containerState is the shutdown state shared by all views of a container. It is protected by the lock of the
+// container.
+type containerState struct {
+	shuttingDown bool
+	shutdown     bool
+}
+
+// forConnection returns a view of the container that logs and audits the operations of another connection. The view
+// shares the state of the container, so removing either removes both.
+func (d *dockerV20Container) forConnection(logger log.Logger, audit *auditLogger) dockerContainer {
+	view := *d
+	view.logger = logger.WithLabel("containerId", d.containerID)
+	view.audit = audit
+	return &view
+}
+
+func (d *dockerV20Container) getID() string {
+	return d.containerID
+}
+
 func (d *dockerV20Container) attach(ctx context.Context) (dockerExecution, error) {
 	d.logger.Debug(log.NewMessage(MContainerAttach, "attaching to container..."))
 	var attachResult types.HijackedResponse
@@ -356,19 +704,90 @@ loop:
 	return err
 }
 
+func (d *dockerV20Container) commit(
+	ctx context.Context,
+	reference string,
+	labels map[string]string,
+	pause bool,
+) (string, error) {
+	d.logger.Debug(log.NewMessage(MContainerCommit, "Committing container to image %s...", reference).
+		Label("image", reference))
+	d.backendRequestsMetric.Increment()
+	response, err := d.dockerClient.ContainerCommit(
+		ctx, d.containerID, types.ContainerCommitOptions{
+			Reference: reference,
+			Pause:     pause,
+			Config: &container.Config{
+				Labels: labels,
+			},
+		},
+	)
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.Wrap(err, EFailedContainerCommit, "failed to commit container to image %s", reference).
+			Label("image", reference)
+		d.logger.Debug(err)
+		return "", err
+	}
+	d.logger.Debug(log.NewMessage(MContainerCommitSuccessful, "Container committed to image %s.", reference).
+		Label("image", reference))
+	d.audit.emit(AuditEvent{
+		Type:        AuditEventContainerCommit,
+		ContainerID: d.containerID,
+		Image:       reference,
+		ImageID:     response.ID,
+	})
+	return response.ID, nil
+}
+
+func (d *dockerV20Container) connectNetwork(ctx context.Context, networkName string, aliases []string) error {
+	d.logger.Debug(log.NewMessage(MNetworkConnect, "Connecting container to network %s...", networkName).
+		Label("network", networkName))
+	d.backendRequestsMetric.Increment()
+	if err := d.dockerClient.NetworkConnect(
+		ctx, networkName, d.containerID, &network.EndpointSettings{
+			Aliases: aliases,
+		},
+	); err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.WrapUser(
+			err,
+			EFailedNetworkConnect,
+			UserMessageInitializeSSHSession,
+			"failed to connect container to network %s",
+			networkName,
+		).Label("network", networkName)
+		d.logger.Debug(err)
+		return err
+	}
+	return nil
+}
+
+func (d *dockerV20Container) inspect(ctx context.Context) (types.ContainerJSON, error) {
+	d.backendRequestsMetric.Increment()
+	result, err := d.dockerClient.ContainerInspect(ctx, d.containerID)
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.Wrap(err, EFailedContainerInspect, "failed to inspect container")
+		d.logger.Debug(err)
+		return types.ContainerJSON{}, err
+	}
+	return result, nil
+}
+
 func (d *dockerV20Container) remove(ctx context.Context) error {
 	d.removeLock.Lock()
 	defer d.removeLock.Unlock()
-	if d.shuttingDown {
+	if d.state.shuttingDown {
 		return nil
 	}
 
 	d.lock.Lock()
-	d.shuttingDown = true
+	d.state.shuttingDown = true
 	d.lock.Unlock()
 	d.wg.Wait()
 	d.lock.Lock()
-	d.shutdown = true
+	d.state.shutdown = true
 	d.lock.Unlock()
 
 	d.logger.Debug(log.NewMessage(MContainerRemove, "Removing container..."))
@@ -390,6 +809,10 @@ loop:
 			)
 			if lastError == nil {
 				d.logger.Debug(log.NewMessage(MContainerRemoveSuccessful, "Container removed."))
+				d.audit.emit(AuditEvent{
+					Type:        AuditEventContainerRemove,
+					ContainerID: d.containerID,
+				})
 				return nil
 			}
 		}
@@ -419,14 +842,101 @@ loop:
 	return err
 }
 
+func (d *dockerV20Container) statPath(ctx context.Context, path string) (types.ContainerPathStat, error) {
+	d.logger.Debug(log.NewMessage(MContainerStatPath, "Checking path %s in container...", path).Label("path", path))
+	var lastError error
+loop:
+	for {
+		var stat types.ContainerPathStat
+		d.backendRequestsMetric.Increment()
+		stat, lastError = d.dockerClient.ContainerStatPath(ctx, d.containerID, path)
+		if lastError == nil {
+			return stat, nil
+		}
+		d.backendFailuresMetric.Increment()
+		if isPermanentError(lastError) {
+			return types.ContainerPathStat{}, log.Wrap(
+				lastError,
+				EFailedContainerStatPath,
+				"failed to check path %s in container, permanent error",
+				path,
+			).Label("path", path)
+		}
+		d.logger.Debug(log.Wrap(
+			lastError,
+			EFailedContainerStatPath,
+			"failed to check path %s in container, retrying in 10 seconds",
+			path,
+		).Label("path", path))
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-time.After(10 * time.Second):
+		}
+	}
+	if lastError == nil {
+		lastError = fmt.Errorf("timeout")
+	}
+	err := log.Wrap(lastError, EFailedContainerStatPath, "failed to check path %s in container, giving up", path).
+		Label("path", path)
+	d.logger.Debug(err)
+	return types.ContainerPathStat{}, err
+}
+
+func (d *dockerV20Container) copyFrom(
+	ctx context.Context,
+	path string,
+) (io.ReadCloser, types.ContainerPathStat, error) {
+	d.logger.Debug(log.NewMessage(MContainerCopyFrom, "Copying %s from container...", path).Label("path", path))
+	d.backendRequestsMetric.Increment()
+	reader, stat, err := d.dockerClient.CopyFromContainer(ctx, d.containerID, path)
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.Wrap(err, EFailedContainerCopyFrom, "failed to copy %s from container", path).Label("path", path)
+		d.logger.Debug(err)
+		return nil, types.ContainerPathStat{}, err
+	}
+	return reader, stat, nil
+}
+
+func (d *dockerV20Container) copyTo(
+	ctx context.Context,
+	directory string,
+	archive io.Reader,
+	useContainerUser bool,
+) error {
+	d.logger.Debug(log.NewMessage(MContainerCopyTo, "Copying files to %s in container...", directory).
+		Label("path", directory))
+	d.backendRequestsMetric.Increment()
+	err := d.dockerClient.CopyToContainer(
+		ctx,
+		d.containerID,
+		directory,
+		archive,
+		types.CopyToContainerOptions{
+			AllowOverwriteDirWithFile: false,
+			CopyUIDGID:                useContainerUser,
+		},
+	)
+	if err != nil {
+		d.backendFailuresMetric.Increment()
+		err = log.Wrap(err, EFailedContainerCopyTo, "failed to copy files to %s in container", directory).
+			Label("path", directory)
+		d.logger.Debug(err)
+		return err
+	}
+	return nil
+}
+
 func (d *dockerV20Container) createExec(
 	ctx context.Context,
 	program []string,
 	env map[string]string,
 	tty bool,
+	user string,
 ) (dockerExecution, error) {
 	d.lock.Lock()
-	if d.shuttingDown {
+	if d.state.shuttingDown {
 		return nil, log.UserMessage(
 			EShuttingDown,
 			"Server is shutting down",
@@ -436,7 +946,7 @@ func (d *dockerV20Container) createExec(
 	d.wg.Add(1)
 	d.lock.Unlock()
 
-	return d.lockedCreateExec(ctx, program, env, tty)
+	return d.lockedCreateExec(ctx, program, env, tty, user)
 }
 
 func (d *dockerV20Container) lockedCreateExec(
@@ -444,15 +954,26 @@ func (d *dockerV20Container) lockedCreateExec(
 	program []string,
 	env map[string]string,
 	tty bool,
+	user string,
 ) (dockerExecution, error) {
 	d.logger.Debug(log.NewMessage(MExec, "Creating and attaching to container exec..."))
-	execConfig := d.createExecConfig(env, tty, program)
+	execConfig := d.createExecConfig(env, tty, program, user)
 	execID, err := d.realCreateExec(ctx, execConfig)
 	if err != nil {
 		d.wg.Done()
 		return nil, err
 	}
 
+	d.audit.emit(AuditEvent{
+		Type:        AuditEventExecCreate,
+		ContainerID: d.containerID,
+		ExecID:      execID,
+		Program:     execConfig.Cmd,
+		EnvKeys:     envKeys(execConfig.Env),
+		TTY:         &execConfig.Tty,
+		User:        execConfig.User,
+	})
+
 	attachResult, err := d.attachExec(ctx, execID, execConfig)
 	if err != nil {
 		d.wg.Done()
@@ -507,7 +1028,12 @@ loop:
 	return "", err
 }
 
-func (d *dockerV20Container) createExecConfig(env map[string]string, tty bool, program []string) types.ExecConfig {
+func (d *dockerV20Container) createExecConfig(
+	env map[string]string,
+	tty bool,
+	program []string,
+	user string,
+) types.ExecConfig {
 	dockerEnv := createEnv(env)
 	if !d.config.Execution.DisableAgent {
 		agentPrefix := []string{
@@ -525,6 +1051,7 @@ func (d *dockerV20Container) createExecConfig(env map[string]string, tty bool, p
 		AttachStdout: true,
 		Env:          dockerEnv,
 		Cmd:          program,
+		User:         user,
 	}
 	return execConfig
 }
@@ -586,6 +1113,13 @@ type dockerV20Exec struct {
 	pid          int
 	doneChan     chan struct{}
 	lock         *sync.Mutex
+	recorder     sessionRecorder
+}
+
+func (d *dockerV20Exec) record(recorder sessionRecorder) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.recorder = recorder
 }
 
 func (d *dockerV20Exec) term(ctx context.Context) {
@@ -616,10 +1150,21 @@ func (d *dockerV20Exec) signal(ctx context.Context, sig string) error {
 	if d.pid <= 0 {
 		return log.UserMessage(EFailedSignalNoPID, "Cannot send signal to process", "could not send signal to exec, process ID not found")
 	}
+	var err error
 	if d.pid == 1 {
-		return d.sendSignalToContainer(ctx, sig)
+		err = d.sendSignalToContainer(ctx, sig)
+	} else {
+		err = d.sendSignalToProcess(ctx, sig)
 	}
-	return d.sendSignalToProcess(ctx, sig)
+	if err == nil {
+		d.container.audit.emit(AuditEvent{
+			Type:        AuditEventSignal,
+			ContainerID: d.container.containerID,
+			ExecID:      d.execID,
+			Signal:      sig,
+		})
+	}
+	return err
 }
 
 func (d *dockerV20Exec) sendSignalToProcess(ctx context.Context, sig string) error {
@@ -634,7 +1179,7 @@ func (d *dockerV20Exec) sendSignalToProcess(ctx context.Context, sig string) err
 		return err
 	}
 	d.lock.Lock()
-	if d.container.shutdown {
+	if d.container.state.shutdown {
 		err := log.UserMessage(
 			EFailedExecSignal,
 			"Cannot send signal to process.",
@@ -692,7 +1237,7 @@ func (d *dockerV20Exec) realSendSignal(ctx context.Context, sig string, pid int)
 			strconv.Itoa(pid),
 			"--signal",
 			sig,
-		}, map[string]string{}, false,
+		}, map[string]string{}, false, "",
 	)
 	if err != nil {
 		return err
@@ -791,6 +1336,16 @@ loop:
 			)
 		}
 		if lastError == nil {
+			d.container.audit.emit(AuditEvent{
+				Type:        AuditEventResize,
+				ContainerID: d.container.containerID,
+				ExecID:      d.execID,
+				Width:       width,
+				Height:      height,
+			})
+			if d.recorder != nil {
+				d.recorder.resize(width, height)
+			}
 			return nil
 		}
 		if isPermanentError(lastError) {
@@ -853,7 +1408,7 @@ func (d *dockerV20Exec) run(
 ) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	if d.container.config.Execution.Mode == ExecutionModeConnection && !d.container.config.Execution.DisableAgent {
+	if d.container.config.Execution.Mode != ExecutionModeSession && !d.container.config.Execution.DisableAgent {
 		if err := d.readPIDFromStdout(stdout); err != nil {
 			d.logger.Error(log.Wrap(
 				err,
@@ -869,6 +1424,11 @@ func (d *dockerV20Exec) run(
 	exitFunc := func() {
 		d.finished(onExit)
 	}
+	if d.recorder != nil {
+		stdin = io.TeeReader(stdin, d.recorder.input())
+		stdout = io.MultiWriter(stdout, d.recorder.output())
+		stderr = io.MultiWriter(stderr, d.recorder.output())
+	}
 	go d.processOutput(once, exitFunc, stdout, stderr, writeClose)
 	go d.processInput(stdin, once, exitFunc)
 }
@@ -999,6 +1559,9 @@ func (d *dockerV20Exec) finished(onExit func(exitStatus int)) {
 	}
 	d.lock.Unlock()
 	close(d.doneChan)
+	if d.recorder != nil {
+		d.recorder.close()
+	}
 	ctx, cancelFunc := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancelFunc()
 	var lastError error
@@ -1129,3 +1692,13 @@ func isPermanentError(err error) bool {
 		client.IsErrPluginPermissionDenied(err) ||
 		client.IsErrUnauthorized(err)
 }
+
+// isNotFoundError checks if the error or any error it wraps is a Docker "not found" error.
+func isNotFoundError(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if client.IsErrNotFound(err) {
+			return true
+		}
+	}
+	return false
+}
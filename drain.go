@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// drainState is the process-wide registry of the active connections used for draining.
+var drainState = struct {
+	lock        *sync.Mutex
+	draining    bool
+	connections map[*networkHandler]struct{}
+	// drained is closed when the last connection has closed during draining.
+	drained chan struct{}
+}{
+	lock:        &sync.Mutex{},
+	connections: map[*networkHandler]struct{}{},
+}
+
+// Drain stops accepting new connections and sessions and notifies the active sessions that the server is being
+// updated. The sessions of each connection are closed and its containers released after the drain timeout of the
+// connection. Drain returns when all connections have closed, or with an error when the context is cancelled first.
+func Drain(ctx context.Context) error {
+	drainState.lock.Lock()
+	if !drainState.draining {
+		drainState.draining = true
+		drainState.drained = make(chan struct{})
+		if len(drainState.connections) == 0 {
+			close(drainState.drained)
+		}
+	}
+	drained := drainState.drained
+	connections := make([]*networkHandler, 0, len(drainState.connections))
+	for n := range drainState.connections {
+		connections = append(connections, n)
+	}
+	drainState.lock.Unlock()
+
+	for _, n := range connections {
+		n.drain()
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isDraining returns true if Drain has been called.
+func isDraining() bool {
+	drainState.lock.Lock()
+	defer drainState.lock.Unlock()
+	return drainState.draining
+}
+
+// registerConnection adds the connection to the drain registry or rejects it if the backend is draining.
+func (n *networkHandler) registerConnection() error {
+	drainState.lock.Lock()
+	defer drainState.lock.Unlock()
+	if drainState.draining {
+		return log.UserMessage(
+			EDraining,
+			n.config.Drain.Message,
+			"rejecting connection, the backend is draining",
+		)
+	}
+	drainState.connections[n] = struct{}{}
+	return nil
+}
+
+// checkDraining returns an error for the user if the backend is draining and no new programs may be started.
+func (n *networkHandler) checkDraining() error {
+	if !isDraining() {
+		return nil
+	}
+	return log.UserMessage(
+		EDraining,
+		n.config.Drain.Message,
+		"rejecting program, the backend is draining",
+	)
+}
+
+// unregisterConnection removes the connection from the drain registry.
+func (n *networkHandler) unregisterConnection() {
+	drainState.lock.Lock()
+	defer drainState.lock.Unlock()
+	if _, ok := drainState.connections[n]; !ok {
+		return
+	}
+	delete(drainState.connections, n)
+	if drainState.draining && len(drainState.connections) == 0 {
+		close(drainState.drained)
+	}
+}
+
+// drain notifies the sessions of the connection and closes the connection after the drain timeout.
+func (n *networkHandler) drain() {
+	n.logger.Info(log.NewMessage(MDrain, "Draining connection, sessions will be closed in %s...", n.config.Timeouts.Drain))
+	notice, err := n.drainNotice()
+	if err != nil {
+		n.logger.Warning(log.Wrap(err, EConfigError, "failed to render drain notice"))
+	}
+	if notice != "" {
+		// The notice is written outside the lock since writing to a channel without window space blocks.
+		n.mutex.Lock()
+		channels := make([]*channelHandler, 0, len(n.channels))
+		for _, c := range n.channels {
+			if c.exec != nil {
+				channels = append(channels, c)
+			}
+		}
+		n.mutex.Unlock()
+		for _, c := range channels {
+			c.notify(notice)
+		}
+	}
+	time.AfterFunc(n.config.Timeouts.Drain, n.closeConnection)
+}
+
+func (n *networkHandler) drainNotice() (string, error) {
+	if n.config.Drain.Notice == "" {
+		return "", nil
+	}
+	tpl, err := template.New("notice").Option("missingkey=error").Parse(n.config.Drain.Notice)
+	if err != nil {
+		return "", err
+	}
+	result := &strings.Builder{}
+	if err := tpl.Execute(result, struct{ Window time.Duration }{n.config.Timeouts.Drain}); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// closeChannels ends the programs of the connection and closes its channels.
+func (n *networkHandler) closeChannels() {
+	n.mutex.Lock()
+	channels := make([]*channelHandler, 0, len(n.channels))
+	for _, c := range n.channels {
+		channels = append(channels, c)
+	}
+	n.mutex.Unlock()
+	for _, c := range channels {
+		c.closeForDrain()
+	}
+}
+
+// closeConnection closes the channels of the connection and releases its containers as if the client had
+// disconnected, so draining does not wait for clients that keep the connection open without a session.
+func (n *networkHandler) closeConnection() {
+	n.closeChannels()
+	n.OnDisconnect()
+}
+
+// handingOver returns true if the containers of the connection are left running for a new ContainerSSH process.
+func (n *networkHandler) handingOver() bool {
+	return n.config.Drain.Handover && isDraining()
+}
@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/sshserver"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDrainedSession struct {
+	sshserver.SessionChannel
+
+	closed chan struct{}
+}
+
+func (f *fakeDrainedSession) Close() error {
+	close(f.closed)
+	return nil
+}
+
+// TestDrainRejectsSessions tests if new sessions and programs are rejected while draining and Drain returns once the
+// drain timeout of the remaining connection has passed, even if the client keeps the connection open.
+//
+// The test is not parallel since the drain state is shared by the whole process.
+func TestDrainRejectsSessions(t *testing.T) {
+	defer func() {
+		drainState.lock.Lock()
+		drainState.draining = false
+		drainState.drained = nil
+		drainState.connections = map[*networkHandler]struct{}{}
+		drainState.lock.Unlock()
+	}()
+
+	config := Config{}
+	config.Drain.Message = "The server is being updated."
+	config.Timeouts.Drain = 100 * time.Millisecond
+	n := &networkHandler{
+		mutex:    &sync.Mutex{},
+		username: "alice",
+		config:   config,
+		logger:   log.NewTestLogger(t),
+		channels: map[uint64]*channelHandler{},
+		done:     make(chan struct{}),
+	}
+	assert.NoError(t, n.registerConnection())
+	ssh := &sshConnectionHandler{networkHandler: n, username: "alice"}
+	session := &fakeDrainedSession{closed: make(chan struct{})}
+	existing, rejection := ssh.OnSessionChannel(0, nil, session)
+	assert.Nil(t, rejection)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFunc()
+	drained := make(chan error, 1)
+	go func() {
+		drained <- Drain(ctx)
+	}()
+	assert.Eventually(t, isDraining, time.Second, time.Millisecond)
+
+	channel, rejection := ssh.OnSessionChannel(1, nil, nil)
+	assert.Nil(t, channel)
+	if assert.NotNil(t, rejection) {
+		assert.Equal(t, EDraining, rejection.Code())
+		assert.Equal(t, "The server is being updated.", rejection.UserMessage())
+	}
+	n.mutex.Lock()
+	assert.Len(t, n.channels, 1)
+	n.mutex.Unlock()
+	for _, err := range []error{existing.OnExecRequest(0, "ls"), existing.OnShell(0), existing.OnSubsystem(0, "sftp")} {
+		var message log.Message
+		if assert.ErrorAs(t, err, &message) {
+			assert.Equal(t, EDraining, message.Code())
+		}
+	}
+
+	select {
+	case err := <-drained:
+		assert.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("Drain did not return after the drain timeout")
+	}
+	select {
+	case <-session.closed:
+	default:
+		t.Fatal("the session was not closed")
+	}
+	select {
+	case <-n.done:
+	default:
+		t.Fatal("the connection was not closed")
+	}
+	assert.Error(t, n.registerConnection())
+}
@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerssh/log"
+)
+
+// exportFiles copies the configured paths from the container into a single tar archive and writes it to the
+// configured directory and sink. Paths that do not exist in the container are skipped.
+func exportFiles(
+	ctx context.Context,
+	cnt dockerContainer,
+	config ExportConfig,
+	data templateData,
+	logger log.Logger,
+) error {
+	if len(config.Paths) == 0 {
+		return nil
+	}
+	name := fmt.Sprintf("%s-%s.tar", sanitizeFileName(data.Username), data.ConnectionID)
+	logger.Debug(log.NewMessage(MExport, "Exporting files from the container to %s...", name))
+	if config.Directory != "" {
+		if err := exportToDirectory(ctx, cnt, config.Paths, config.Directory, name, logger); err != nil {
+			return log.Wrap(err, EFailedExport, "failed to export files from the container to %s", name)
+		}
+	}
+	if config.Sink != nil {
+		reader, writer := io.Pipe()
+		go func() {
+			_ = writer.CloseWithError(writeExportArchive(ctx, cnt, config.Paths, writer, logger))
+		}()
+		err := config.Sink.Write(name, reader)
+		_ = reader.Close()
+		if err != nil {
+			return log.Wrap(err, EFailedExport, "failed to export files from the container to %s", name)
+		}
+	}
+	return nil
+}
+
+func exportToDirectory(
+	ctx context.Context,
+	cnt dockerContainer,
+	paths []string,
+	directory string,
+	name string,
+	logger log.Logger,
+) error {
+	target := filepath.Join(directory, name)
+	fh, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeExportArchive(ctx, cnt, paths, fh, logger); err != nil {
+		_ = fh.Close()
+		_ = os.Remove(target)
+		return err
+	}
+	return fh.Close()
+}
+
+// writeExportArchive writes a tar archive of the paths to the writer. Entries are named after their full path in
+// the container without the leading slash.
+func writeExportArchive(
+	ctx context.Context,
+	cnt dockerContainer,
+	paths []string,
+	writer io.Writer,
+	logger log.Logger,
+) error {
+	tarWriter := tar.NewWriter(writer)
+	for _, exportPath := range paths {
+		if err := copyExportPath(ctx, cnt, exportPath, tarWriter); err != nil {
+			if !isNotFoundError(err) {
+				return err
+			}
+			logger.Debug(log.NewMessage(MExport, "Export path %s does not exist in the container, skipping.", exportPath))
+		}
+	}
+	return tarWriter.Close()
+}
+
+func copyExportPath(ctx context.Context, cnt dockerContainer, exportPath string, tarWriter *tar.Writer) error {
+	reader, _, err := cnt.copyFrom(ctx, exportPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	// The archive from Docker names entries relative to the parent directory of the path.
+	prefix := strings.TrimPrefix(path.Dir(path.Clean(exportPath)), "/")
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, header.Name)
+		if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tarWriter, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// sanitizeFileName replaces characters that are not safe in file names.
+func sanitizeFileName(name string) string {
+	return strings.Map(
+		func(r rune) rune {
+			if r == '/' || r == '\\' || r == 0 || r == ':' {
+				return '_'
+			}
+			return r
+		}, name,
+	)
+}
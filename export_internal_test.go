@@ -0,0 +1,126 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeExportSink struct {
+	lock     *sync.Mutex
+	archives map[string][]byte
+}
+
+func (f *fakeExportSink) Write(name string, archive io.Reader) error {
+	data, err := ioutil.ReadAll(archive)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.archives[name] = data
+	return nil
+}
+
+// readExportArchive returns the content of the regular files and the names of the other entries in the archive.
+func readExportArchive(t *testing.T, data []byte) map[string]string {
+	entries := map[string]string{}
+	tarReader := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if !assert.NoError(t, err) {
+			return entries
+		}
+		content, err := ioutil.ReadAll(tarReader)
+		assert.NoError(t, err)
+		entries[header.Name] = string(content)
+	}
+}
+
+// TestExportFiles tests if the configured paths are exported under their full path into both the directory and the
+// sink, and missing paths are skipped.
+func TestExportFiles(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	sink := &fakeExportSink{lock: &sync.Mutex{}, archives: map[string][]byte{}}
+	config := ExportConfig{
+		Paths:     []string{"/home/alice/docs", "/srv/readonly", "/var/missing"},
+		Directory: directory,
+		Sink:      sink,
+	}
+	data := templateData{Username: "domain/alice", ConnectionID: "0123"}
+
+	assert.NoError(t, exportFiles(context.Background(), newFakeFilesystemContainer(), config, data, log.NewTestLogger(t)))
+
+	expected := map[string]string{
+		"home/alice/docs/":  "",
+		"home/alice/docs/b": "b",
+		"srv/readonly":      "readonly",
+	}
+	fileData, err := ioutil.ReadFile(filepath.Join(directory, "domain_alice-0123.tar"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, readExportArchive(t, fileData))
+	assert.Equal(t, expected, readExportArchive(t, sink.archives["domain_alice-0123.tar"]))
+}
+
+// TestExportFilesDisabled tests if nothing is read from the container or written when no paths are configured.
+func TestExportFilesDisabled(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	cnt := newFakeFilesystemContainer()
+	config := ExportConfig{Directory: directory}
+
+	assert.NoError(t, exportFiles(context.Background(), cnt, config, templateData{}, log.NewTestLogger(t)))
+
+	assert.Empty(t, cnt.copied)
+	entries, err := ioutil.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// failingExportContainer fails to read the paths after the first one.
+type failingExportContainer struct {
+	*fakeFilesystemContainer
+}
+
+func (f *failingExportContainer) copyFrom(ctx context.Context, filePath string) (
+	io.ReadCloser,
+	types.ContainerPathStat,
+	error,
+) {
+	if len(f.copied) > 0 {
+		return nil, types.ContainerPathStat{}, errors.New("connection reset")
+	}
+	return f.fakeFilesystemContainer.copyFrom(ctx, filePath)
+}
+
+// TestExportFilesFailure tests if the partial archive is removed from the directory when the export fails.
+func TestExportFilesFailure(t *testing.T) {
+	t.Parallel()
+
+	directory := t.TempDir()
+	cnt := &failingExportContainer{newFakeFilesystemContainer()}
+	config := ExportConfig{Paths: []string{"/srv/readonly", "/home/alice/docs"}, Directory: directory}
+	data := templateData{Username: "alice", ConnectionID: "0123"}
+
+	assert.Error(t, exportFiles(context.Background(), cnt, config, data, log.NewTestLogger(t)))
+
+	entries, err := ioutil.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
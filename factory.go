@@ -21,6 +21,9 @@ func New(
 	sshserver.NetworkConnectionHandler,
 	error,
 ) {
+	// The preset is applied first so its settings are subject to the security policy.
+	config.Execution.Hardening.apply(&config.Execution.Launch)
+	applySecurityPolicy(&config, logger)
 	if err := config.Validate(); err != nil {
 		return nil, err
 	}
@@ -40,6 +43,7 @@ func New(
 			backendFailuresMetric: backendFailuresMetric,
 			backendRequestsMetric: backendRequestsMetric,
 		},
-		done: make(chan struct{}),
+		done:     make(chan struct{}),
+		channels: map[uint64]*channelHandler{},
 	}, nil
 }
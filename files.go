@@ -0,0 +1,107 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// templateData is the data available in templated configuration options.
+type templateData struct {
+	// Username is the username the user authenticated with.
+	Username string
+	// ConnectionID is the unique ID of the SSH connection.
+	ConnectionID string
+	// Timestamp is the current UTC time in the YYYYMMDDhhmmss format.
+	Timestamp string
+}
+
+func renderTemplate(name string, text string, data templateData) (string, error) {
+	tpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	result := &strings.Builder{}
+	if err := tpl.Execute(result, data); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// seedFiles copies the configured files into the container in a single archive. The container does not need to be
+// running.
+func seedFiles(
+	ctx context.Context,
+	cnt dockerContainer,
+	files []SeedFile,
+	data templateData,
+	logger log.Logger,
+) error {
+	if len(files) == 0 {
+		return nil
+	}
+	logger.Debug(log.NewMessage(MSeedFiles, "Copying %d files into the container...", len(files)))
+	archive, err := createSeedArchive(files, data)
+	if err != nil {
+		return log.Wrap(err, EFailedSeedFiles, "failed to prepare files for the container")
+	}
+	if err := cnt.copyTo(ctx, "/", archive, false); err != nil {
+		return log.Wrap(err, EFailedSeedFiles, "failed to copy files into the container")
+	}
+	return nil
+}
+
+func createSeedArchive(files []SeedFile, data templateData) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buf)
+	now := time.Now()
+	for _, file := range files {
+		content, err := file.getContent(data)
+		if err != nil {
+			return nil, err
+		}
+		mode, err := file.parseMode()
+		if err != nil {
+			return nil, err
+		}
+		if err := tarWriter.WriteHeader(
+			&tar.Header{
+				Typeflag: tar.TypeReg,
+				// The archive is extracted in the root directory.
+				Name:    strings.TrimPrefix(file.Target, "/"),
+				Mode:    mode,
+				Uid:     file.UID,
+				Gid:     file.GID,
+				Size:    int64(len(content)),
+				ModTime: now,
+			},
+		); err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s SeedFile) getContent(data templateData) ([]byte, error) {
+	switch {
+	case s.Source != "":
+		return ioutil.ReadFile(s.Source)
+	case s.Template != "":
+		content, err := renderTemplate(s.Target, s.Template, data)
+		return []byte(content), err
+	default:
+		return []byte(s.Content), nil
+	}
+}
@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRenderTemplate tests if templates are rendered with the connection data and unknown fields are rejected.
+func TestRenderTemplate(t *testing.T) {
+	t.Parallel()
+
+	data := templateData{Username: "alice", ConnectionID: "0123", Timestamp: "20210102030405"}
+	result, err := renderTemplate("name", "home-{{ .Username }}-{{ .ConnectionID }}-{{ .Timestamp }}", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "home-alice-0123-20210102030405", result)
+
+	_, err = renderTemplate("name", "{{ .Password }}", data)
+	assert.Error(t, err)
+	_, err = renderTemplate("name", "{{ .Username ", data)
+	assert.Error(t, err)
+}
+
+// TestSeedFiles tests if the seed files are copied into the root of the container in a single archive with their
+// content, mode and owner.
+func TestSeedFiles(t *testing.T) {
+	t.Parallel()
+
+	source := filepath.Join(t.TempDir(), "motd")
+	assert.NoError(t, ioutil.WriteFile(source, []byte("Welcome!"), 0600))
+	cnt := newFakeFilesystemContainer()
+	files := []SeedFile{
+		{Target: "/etc/motd", Source: source},
+		{Target: "/home/alice/.profile", Content: "export A=1\n", Mode: "0600", UID: 1000, GID: 1000},
+		{Target: "/etc/connection", Template: "{{ .Username }}/{{ .ConnectionID }}", Mode: "0444"},
+	}
+	data := templateData{Username: "alice", ConnectionID: "0123"}
+
+	assert.NoError(t, seedFiles(context.Background(), cnt, files, data, log.NewTestLogger(t)))
+
+	if !assert.Len(t, cnt.uploads, 1) {
+		return
+	}
+	upload := cnt.uploads[0]
+	assert.Equal(t, "/", upload.directory)
+	assert.Equal(t, "Welcome!export A=1\nalice/0123", upload.content)
+	if !assert.Len(t, upload.headers, 3) {
+		return
+	}
+	assert.Equal(t, "etc/motd", upload.headers[0].Name)
+	assert.Equal(t, int64(0644), upload.headers[0].Mode)
+	assert.Equal(t, "home/alice/.profile", upload.headers[1].Name)
+	assert.Equal(t, int64(0600), upload.headers[1].Mode)
+	assert.Equal(t, 1000, upload.headers[1].Uid)
+	assert.Equal(t, 1000, upload.headers[1].Gid)
+	assert.Equal(t, "etc/connection", upload.headers[2].Name)
+	assert.Equal(t, int64(0444), upload.headers[2].Mode)
+}
+
+// TestSeedFilesFailures tests if nothing is copied into the container when there are no files or a file cannot be
+// prepared.
+func TestSeedFilesFailures(t *testing.T) {
+	t.Parallel()
+
+	cnt := newFakeFilesystemContainer()
+	logger := log.NewTestLogger(t)
+	data := templateData{Username: "alice"}
+
+	assert.NoError(t, seedFiles(context.Background(), cnt, nil, data, logger))
+	err := seedFiles(
+		context.Background(), cnt, []SeedFile{{Target: "/etc/x", Template: "{{ .Password }}"}}, data, logger,
+	)
+	assert.Error(t, err)
+	err = seedFiles(
+		context.Background(),
+		cnt,
+		[]SeedFile{{Target: "/etc/x", Source: filepath.Join(t.TempDir(), "missing")}},
+		data,
+		logger,
+	)
+	assert.Error(t, err)
+	assert.Empty(t, cnt.uploads)
+}
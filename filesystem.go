@@ -0,0 +1,1025 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxSymlinks is the maximum number of symbolic links followed while resolving a path, the same limit as in Linux.
+const maxSymlinks = 40
+
+// maxAccountFileSize is the maximum size of /etc/passwd and /etc/group read from the container.
+const maxAccountFileSize = 4 * 1024 * 1024
+
+// maxListArchiveSize is the maximum size of the archive read to list a directory. The archive contains the whole tree
+// below the directory, so listing a large tree is rejected instead of making the daemon archive all of it.
+const maxListArchiveSize = 64 * 1024 * 1024
+
+// errProgramUnavailable is returned by the operations that run a program in the container if the container does not
+// have the program, such as distroless images.
+var errProgramUnavailable = errors.New("program not available in the container")
+
+// Access bits checked against the owner, group or other permissions of a file.
+const (
+	accessRead    os.FileMode = 4
+	accessWrite   os.FileMode = 2
+	accessExecute os.FileMode = 1
+)
+
+// containerFilesystem performs file operations in a container on behalf of the session user. Reads and writes go
+// through the Docker archive API so they work on images without any tools installed. The daemon performs archive
+// operations as root, so the filesystem enforces the permissions of the session user itself: it resolves symbolic
+// links inside the container one path component at a time, checks the owner and mode of every file on the way, and
+// only then checks the resolved path against the allowed paths. Removing and renaming files is not possible through
+// the archive API, these operations run rmdir, rm and mv in the container as the session user and fail with
+// errProgramUnavailable if the image does not have them.
+type containerFilesystem struct {
+	container dockerContainer
+	timeout   time.Duration
+	// user is the user the sessions run as. Empty for the container user.
+	user string
+	// allowedPaths restricts all operations to these directories. Defaults to the home directory of the user.
+	allowedPaths []string
+	// readOnly rejects all operations that modify the filesystem.
+	readOnly bool
+	// maxUploadSize is the maximum size of an uploaded file in bytes, 0 for no limit.
+	maxUploadSize int64
+
+	lock     *sync.Mutex
+	identity *fileIdentity
+}
+
+func newContainerFilesystem(
+	container dockerContainer,
+	timeout time.Duration,
+	user string,
+	config FileTransferConfig,
+) *containerFilesystem {
+	return &containerFilesystem{
+		container:     container,
+		timeout:       timeout,
+		user:          user,
+		allowedPaths:  config.AllowedPaths,
+		readOnly:      config.ReadOnly,
+		maxUploadSize: config.MaxUploadSize,
+		lock:          &sync.Mutex{},
+	}
+}
+
+// fileIdentity is the user and groups the permissions of the session user are checked for.
+type fileIdentity struct {
+	uid    int
+	gid    int
+	groups []int
+	home   string
+}
+
+// permits checks if the identity has all requested access bits on the file. Root has access to every file.
+func (i *fileIdentity) permits(file *containerFile, access os.FileMode) bool {
+	if i.uid == 0 {
+		return true
+	}
+	perm := file.mode.Perm()
+	switch {
+	case i.uid == file.uid:
+		perm >>= 6
+	case i.inGroup(file.gid):
+		perm >>= 3
+	}
+	return perm&access == access
+}
+
+func (i *fileIdentity) inGroup(gid int) bool {
+	if i.gid == gid {
+		return true
+	}
+	for _, group := range i.groups {
+		if group == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupIdentity resolves a Docker user specification, a user name or uid with an optional group name or gid, against
+// the passwd and group files of the container. Like Docker, a numeric uid missing from the passwd file runs with gid 0.
+func lookupIdentity(user string, passwd []byte, group []byte) (*fileIdentity, error) {
+	userPart, groupPart := user, ""
+	if i := strings.Index(user, ":"); i >= 0 {
+		userPart, groupPart = user[:i], user[i+1:]
+	}
+	if userPart == "" {
+		userPart = "0"
+	}
+	identity := &fileIdentity{home: "/"}
+	name := ""
+	uid, uidErr := strconv.Atoi(userPart)
+	found := false
+	for _, fields := range parseAccountFile(passwd, 7) {
+		entryUID, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if (uidErr != nil && fields[0] == userPart) || (uidErr == nil && entryUID == uid) {
+			name = fields[0]
+			identity.uid = entryUID
+			identity.gid, _ = strconv.Atoi(fields[3])
+			if fields[5] != "" {
+				identity.home = path.Clean(fields[5])
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf("user %s not found in the container", userPart)
+		}
+		identity.uid = uid
+		identity.gid = 0
+	}
+
+	groups := parseAccountFile(group, 4)
+	if groupPart != "" {
+		gid, err := strconv.Atoi(groupPart)
+		if err != nil {
+			gid = -1
+			for _, fields := range groups {
+				if fields[0] == groupPart {
+					gid, err = strconv.Atoi(fields[2])
+					break
+				}
+			}
+			if gid < 0 || err != nil {
+				return nil, fmt.Errorf("group %s not found in the container", groupPart)
+			}
+		}
+		identity.gid = gid
+	}
+	if name != "" {
+		for _, fields := range groups {
+			for _, member := range strings.Split(fields[3], ",") {
+				if member == name {
+					if gid, err := strconv.Atoi(fields[2]); err == nil {
+						identity.groups = append(identity.groups, gid)
+					}
+					break
+				}
+			}
+		}
+	}
+	return identity, nil
+}
+
+// parseAccountFile splits the lines of a passwd or group file into fields, skipping lines with fewer fields.
+func parseAccountFile(data []byte, fieldCount int) [][]string {
+	var result [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < fieldCount {
+			continue
+		}
+		result = append(result, fields)
+	}
+	return result
+}
+
+// getIdentity returns the identity of the session user, reading the account files from the container on first use.
+func (f *containerFilesystem) getIdentity(ctx context.Context) (*fileIdentity, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.identity != nil {
+		return f.identity, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	user := f.user
+	if user == "" {
+		info, err := f.container.inspect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if info.Config != nil {
+			user = info.Config.User
+		}
+	}
+	passwd, err := f.readAccountFile(ctx, "/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+	group, err := f.readAccountFile(ctx, "/etc/group")
+	if err != nil {
+		return nil, err
+	}
+	identity, err := lookupIdentity(user, passwd, group)
+	if err != nil {
+		return nil, err
+	}
+	f.identity = identity
+	return identity, nil
+}
+
+// readAccountFile returns the content of the file, or nil if it does not exist.
+func (f *containerFilesystem) readAccountFile(ctx context.Context, filePath string) ([]byte, error) {
+	reader, _, err := f.container.copyFrom(ctx, filePath)
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	tarReader := tar.NewReader(reader)
+	if _, err := tarReader.Next(); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(io.LimitReader(tarReader, maxAccountFileSize))
+}
+
+// homeDirectory returns the home directory of the session user, relative paths are resolved against it.
+func (f *containerFilesystem) homeDirectory(ctx context.Context) (string, error) {
+	identity, err := f.getIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	return identity.home, nil
+}
+
+// containerFile is the metadata of a file in the container as recorded in the archive. It implements os.FileInfo.
+type containerFile struct {
+	name       string
+	uid        int
+	gid        int
+	mode       os.FileMode
+	size       int64
+	modTime    time.Time
+	linkTarget string
+}
+
+func newContainerFile(name string, header *tar.Header) *containerFile {
+	info := header.FileInfo()
+	return &containerFile{
+		name:       name,
+		uid:        header.Uid,
+		gid:        header.Gid,
+		mode:       info.Mode(),
+		size:       info.Size(),
+		modTime:    info.ModTime(),
+		linkTarget: header.Linkname,
+	}
+}
+
+// rootDirectory is the metadata assumed for the root directory, which cannot be archived on its own.
+func rootDirectory() *containerFile {
+	return &containerFile{name: "/", mode: os.ModeDir | 0755}
+}
+
+func (c *containerFile) Name() string       { return c.name }
+func (c *containerFile) Size() int64        { return c.size }
+func (c *containerFile) Mode() os.FileMode  { return c.mode }
+func (c *containerFile) ModTime() time.Time { return c.modTime }
+func (c *containerFile) IsDir() bool        { return c.mode.IsDir() }
+func (c *containerFile) Sys() interface{}   { return nil }
+
+// tarMode returns the mode of the file in the format of the tar header, including the special permission bits.
+func (c *containerFile) tarMode() int64 {
+	mode := int64(c.mode.Perm())
+	if c.mode&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if c.mode&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if c.mode&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// lstat returns the metadata of the file without following a symbolic link in the last component. The parent
+// directories must not contain symbolic links. The stat API reports neither the owner nor the unresolved target of a
+// link, so these are read from the first header of the archive of the path. The archive is only requested for
+// existing paths, and closed after the first header so the daemon stops archiving the rest of a directory.
+func (f *containerFilesystem) lstat(ctx context.Context, filePath string) (*containerFile, error) {
+	if filePath == "/" {
+		return rootDirectory(), nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	if _, err := f.container.statPath(ctx, filePath); err != nil {
+		return nil, f.mapError(err, filePath)
+	}
+	reader, _, err := f.container.copyFrom(ctx, filePath)
+	if err != nil {
+		return nil, f.mapError(err, filePath)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	header, err := tar.NewReader(reader).Next()
+	if err != nil {
+		return nil, err
+	}
+	return newContainerFile(path.Base(filePath), header), nil
+}
+
+// resolvedDirectory is a directory on the way to a resolved file.
+type resolvedDirectory struct {
+	path string
+	file *containerFile
+}
+
+// resolvedFile is a path in the container without symbolic links.
+type resolvedFile struct {
+	path string
+	// file is the metadata of the file, nil if it does not exist.
+	file *containerFile
+	// directories are the directories from the root to the parent of the file.
+	directories []resolvedDirectory
+}
+
+// parent returns the metadata of the directory containing the file.
+func (r resolvedFile) parent() *containerFile {
+	return r.directories[len(r.directories)-1].file
+}
+
+// resolve makes the path absolute relative to the home directory and resolves all symbolic links in it inside the
+// container, checking that the user may search every directory on the way. The last component is only followed if
+// followLast is true and may be missing, all other components must exist. The resolved path must be inside the
+// allowed paths.
+func (f *containerFilesystem) resolve(ctx context.Context, filePath string, followLast bool) (resolvedFile, error) {
+	identity, err := f.getIdentity(ctx)
+	if err != nil {
+		return resolvedFile{}, err
+	}
+	if !path.IsAbs(filePath) {
+		filePath = path.Join(identity.home, filePath)
+	}
+	stack := []resolvedDirectory{{path: "/", file: rootDirectory()}}
+	remaining := splitPath(filePath)
+	links := 0
+	for len(remaining) > 0 {
+		name := remaining[0]
+		remaining = remaining[1:]
+		if name == ".." {
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		current := stack[len(stack)-1]
+		if !current.file.IsDir() {
+			return resolvedFile{}, &os.PathError{Op: "open", Path: filePath, Err: syscall.ENOTDIR}
+		}
+		if !identity.permits(current.file, accessExecute) {
+			return resolvedFile{}, &os.PathError{Op: "open", Path: filePath, Err: os.ErrPermission}
+		}
+		nextPath := path.Join(current.path, name)
+		next, err := f.lstat(ctx, nextPath)
+		if err != nil {
+			if os.IsNotExist(err) && len(remaining) == 0 {
+				result := resolvedFile{path: nextPath, directories: stack}
+				return result, f.checkAllowed(identity, filePath, nextPath)
+			}
+			return resolvedFile{}, err
+		}
+		if next.mode&os.ModeSymlink != 0 && (followLast || len(remaining) > 0) {
+			links++
+			if links > maxSymlinks {
+				return resolvedFile{}, &os.PathError{Op: "open", Path: filePath, Err: syscall.ELOOP}
+			}
+			// Relative targets are resolved from the directory containing the link.
+			if path.IsAbs(next.linkTarget) {
+				stack = stack[:1]
+			}
+			remaining = append(splitPath(next.linkTarget), remaining...)
+			continue
+		}
+		stack = append(stack, resolvedDirectory{path: nextPath, file: next})
+	}
+	current := stack[len(stack)-1]
+	result := resolvedFile{path: current.path, file: current.file, directories: stack[:len(stack)-1]}
+	if len(stack) == 1 {
+		// The root directory is its own parent.
+		result.directories = stack
+	}
+	return result, f.checkAllowed(identity, filePath, current.path)
+}
+
+// splitPath returns the components of the path, ignoring empty and "." components.
+func splitPath(filePath string) []string {
+	var result []string
+	for _, name := range strings.Split(filePath, "/") {
+		if name != "" && name != "." {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// checkAllowed returns an error recognized by os.IsPermission if the resolved path is outside the allowed paths.
+func (f *containerFilesystem) checkAllowed(identity *fileIdentity, filePath string, resolvedPath string) error {
+	if allowedBase(f.getAllowedPaths(identity), resolvedPath) == "" {
+		return &os.PathError{Op: "open", Path: filePath, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+func (f *containerFilesystem) getAllowedPaths(identity *fileIdentity) []string {
+	if len(f.allowedPaths) == 0 {
+		return []string{identity.home}
+	}
+	return f.allowedPaths
+}
+
+// allowedBase returns the longest allowed path containing the resolved path, or an empty string if there is none.
+func allowedBase(allowedPaths []string, resolvedPath string) string {
+	base := ""
+	for _, allowedPath := range allowedPaths {
+		allowedPath = path.Clean(allowedPath)
+		if allowedPath == "/" || resolvedPath == allowedPath || strings.HasPrefix(resolvedPath, allowedPath+"/") {
+			if len(allowedPath) > len(base) {
+				base = allowedPath
+			}
+		}
+	}
+	return base
+}
+
+// checkWrite returns an error recognized by os.IsPermission if the filesystem is read-only.
+func (f *containerFilesystem) checkWrite(filePath string) error {
+	if f.readOnly {
+		return &os.PathError{Op: "write", Path: filePath, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// stat returns the file information of the path in the container, following symbolic links.
+func (f *containerFilesystem) stat(ctx context.Context, filePath string) (os.FileInfo, error) {
+	resolved, err := f.resolve(ctx, filePath, true)
+	if err != nil {
+		return nil, err
+	}
+	if resolved.file == nil {
+		return nil, &os.PathError{Op: "stat", Path: filePath, Err: os.ErrNotExist}
+	}
+	return resolved.file, nil
+}
+
+// list returns the entries of the directory in the container. The archive API cannot list a single level, so the
+// archive of the directory is streamed and only the direct children are kept. Reading the archive is limited to
+// maxListArchiveSize and the timeout of the filesystem.
+func (f *containerFilesystem) list(ctx context.Context, directory string) ([]os.FileInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	var result []os.FileInfo
+	collect := func(name string, file *containerFile, _ io.Reader) (bool, error) {
+		if name != "" {
+			result = append(result, file)
+		}
+		// Only the directory itself is descended into.
+		return name == "", nil
+	}
+	err := f.walkLimited(ctx, directory, maxListArchiveSize, collect)
+	if errors.Is(err, errArchiveTooLarge) {
+		return nil, fmt.Errorf("directory %s is too large to list (%w)", directory, err)
+	}
+	return result, err
+}
+
+// errArchiveTooLarge is returned if an archive exceeds the size limit of the operation reading it.
+var errArchiveTooLarge = errors.New("archive too large")
+
+// limitedReader reads up to limit bytes and then fails with errArchiveTooLarge.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// walkFunc is called for each entry of a directory tree with its path relative to the walked directory, which is
+// empty for the directory itself. The content can only be read during the call. Returning false skips the entries
+// below a directory.
+type walkFunc func(name string, file *containerFile, content io.Reader) (bool, error)
+
+// walk streams the archive of the directory once and calls fn for the directory and the entries below it. The
+// session user must be able to read and search the directory.
+func (f *containerFilesystem) walk(ctx context.Context, directory string, fn walkFunc) error {
+	return f.walkLimited(ctx, directory, 0, fn)
+}
+
+// walkLimited walks the directory like walk, but fails with errArchiveTooLarge after reading limit bytes of the
+// archive. A limit of 0 reads the whole archive.
+func (f *containerFilesystem) walkLimited(ctx context.Context, directory string, limit int64, fn walkFunc) error {
+	resolved, err := f.resolve(ctx, directory, true)
+	if err != nil {
+		return err
+	}
+	if resolved.file == nil {
+		return &os.PathError{Op: "open", Path: directory, Err: os.ErrNotExist}
+	}
+	if !resolved.file.IsDir() {
+		return &os.PathError{Op: "open", Path: directory, Err: syscall.ENOTDIR}
+	}
+	identity, err := f.getIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	reader, _, err := f.container.copyFrom(ctx, resolved.path)
+	if err != nil {
+		return f.mapError(err, directory)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	var archive io.Reader = reader
+	if limit > 0 {
+		archive = &limitedReader{reader: reader, remaining: limit}
+	}
+	tarReader := tar.NewReader(archive)
+	root := ""
+	first := true
+	var skipped []string
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(header.Name, "./"), "/")
+		if first {
+			// The first entry is the directory itself, all other entries are prefixed with its name.
+			root = name
+			first = false
+			file := newContainerFile(path.Base(resolved.path), header)
+			// Check the archived metadata again in case the directory changed since it was resolved.
+			if !file.IsDir() || !identity.permits(file, accessRead|accessExecute) {
+				return &os.PathError{Op: "open", Path: directory, Err: os.ErrPermission}
+			}
+			if _, err := fn("", file, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if root != "" && root != "." {
+			if !strings.HasPrefix(name, root+"/") {
+				continue
+			}
+			name = name[len(root)+1:]
+		}
+		parent := path.Dir(name)
+		if parent != "." && isBelowAny(parent, skipped) {
+			continue
+		}
+		file := newContainerFile(path.Base(name), header)
+		descend, err := fn(name, file, tarReader)
+		if err != nil {
+			return err
+		}
+		if file.IsDir() && (!descend || !identity.permits(file, accessRead|accessExecute)) {
+			skipped = append(skipped, name)
+		}
+	}
+}
+
+// isBelowAny checks if the relative path is one of the directories or below one of them.
+func isBelowAny(name string, directories []string) bool {
+	for _, directory := range directories {
+		if name == directory || strings.HasPrefix(name, directory+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// read returns a stream of the content of the regular file in the container. The caller must close the stream.
+func (f *containerFilesystem) read(ctx context.Context, filePath string) (io.ReadCloser, os.FileInfo, error) {
+	resolved, err := f.resolve(ctx, filePath, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resolved.file == nil {
+		return nil, nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+	}
+	if !resolved.file.mode.IsRegular() {
+		return nil, nil, fmt.Errorf("%s is not a regular file", filePath)
+	}
+	identity, err := f.getIdentity(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !identity.permits(resolved.file, accessRead) {
+		return nil, nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrPermission}
+	}
+	reader, file, err := f.open(ctx, resolved.path)
+	if err != nil {
+		return nil, nil, f.mapError(err, filePath)
+	}
+	// Check the archived metadata again in case the file changed since it was resolved.
+	if !file.mode.IsRegular() || !identity.permits(file, accessRead) {
+		_ = reader.Close()
+		return nil, nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrPermission}
+	}
+	return reader, file, nil
+}
+
+// open returns a stream of the single archive entry at the resolved path and its metadata.
+func (f *containerFilesystem) open(ctx context.Context, resolvedPath string) (io.ReadCloser, *containerFile, error) {
+	reader, _, err := f.container.copyFrom(ctx, resolvedPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	tarReader := tar.NewReader(reader)
+	header, err := tarReader.Next()
+	if err != nil {
+		_ = reader.Close()
+		return nil, nil, err
+	}
+	return &archiveEntryReader{Reader: tarReader, closer: reader}, newContainerFile(path.Base(resolvedPath), header), nil
+}
+
+// archiveEntryReader reads a single entry of an archive and closes the archive when closed.
+type archiveEntryReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (a *archiveEntryReader) Close() error {
+	return a.closer.Close()
+}
+
+// write uploads the content as a regular file to the container. A new file is owned by the session user, an existing
+// file keeps its owner and mode.
+func (f *containerFilesystem) write(
+	ctx context.Context,
+	filePath string,
+	mode os.FileMode,
+	content io.Reader,
+	size int64,
+) error {
+	if err := f.checkSize(filePath, size); err != nil {
+		return err
+	}
+	resolved, identity, header, err := f.prepareWrite(ctx, filePath, mode)
+	if err != nil {
+		return err
+	}
+	header.Size = size
+	return f.upload(ctx, identity, resolved, header, content)
+}
+
+// checkWritable checks if the session user may write the file, so uploads that are staged before writing can be
+// rejected before any data is transferred. The file is checked again when it is written.
+func (f *containerFilesystem) checkWritable(ctx context.Context, filePath string) error {
+	_, _, _, err := f.prepareWrite(ctx, filePath, 0)
+	return err
+}
+
+// checkSize checks if the size of an upload is within the configured limit.
+func (f *containerFilesystem) checkSize(filePath string, size int64) error {
+	if f.maxUploadSize > 0 && size > f.maxUploadSize {
+		return &os.PathError{Op: "write", Path: filePath, Err: errUploadTooLarge}
+	}
+	return nil
+}
+
+// errUploadTooLarge is returned if an upload exceeds the configured maximum size.
+var errUploadTooLarge = errors.New("upload exceeds the maximum size")
+
+// prepareWrite resolves the file, checks if the session user may write it, and returns the header of the upload
+// without the size.
+func (f *containerFilesystem) prepareWrite(
+	ctx context.Context,
+	filePath string,
+	mode os.FileMode,
+) (resolvedFile, *fileIdentity, *tar.Header, error) {
+	if err := f.checkWrite(filePath); err != nil {
+		return resolvedFile{}, nil, nil, err
+	}
+	resolved, err := f.resolve(ctx, filePath, true)
+	if err != nil {
+		return resolvedFile{}, nil, nil, err
+	}
+	identity, err := f.getIdentity(ctx)
+	if err != nil {
+		return resolvedFile{}, nil, nil, err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Mode:     int64(mode.Perm()),
+		ModTime:  time.Now(),
+	}
+	if resolved.file != nil {
+		if resolved.file.IsDir() {
+			return resolvedFile{}, nil, nil, &os.PathError{Op: "open", Path: filePath, Err: syscall.EISDIR}
+		}
+		if !resolved.file.mode.IsRegular() {
+			return resolvedFile{}, nil, nil, fmt.Errorf("%s is not a regular file", filePath)
+		}
+		if !identity.permits(resolved.file, accessWrite) {
+			return resolvedFile{}, nil, nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrPermission}
+		}
+		header.Uid = resolved.file.uid
+		header.Gid = resolved.file.gid
+		header.Mode = int64(resolved.file.mode.Perm())
+	} else {
+		if err := f.checkCreate(identity, resolved, filePath); err != nil {
+			return resolvedFile{}, nil, nil, err
+		}
+		f.setNewOwner(identity, resolved, header)
+	}
+	return resolved, identity, header, nil
+}
+
+// mkdir creates a directory in the container owned by the session user.
+func (f *containerFilesystem) mkdir(ctx context.Context, directory string, mode os.FileMode) error {
+	if err := f.checkWrite(directory); err != nil {
+		return err
+	}
+	resolved, err := f.resolve(ctx, directory, false)
+	if err != nil {
+		return err
+	}
+	if resolved.file != nil {
+		return &os.PathError{Op: "mkdir", Path: directory, Err: os.ErrExist}
+	}
+	identity, err := f.getIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if err := f.checkCreate(identity, resolved, directory); err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Mode:     int64(mode.Perm()),
+		ModTime:  time.Now(),
+	}
+	f.setNewOwner(identity, resolved, header)
+	return f.upload(ctx, identity, resolved, header, nil)
+}
+
+// checkCreate checks if the session user may create the missing file in its parent directory.
+func (f *containerFilesystem) checkCreate(identity *fileIdentity, resolved resolvedFile, filePath string) error {
+	if !identity.permits(resolved.parent(), accessWrite|accessExecute) {
+		return &os.PathError{Op: "open", Path: filePath, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// setNewOwner sets the owner of a new file to the session user. Like in Linux, files created in a directory with the
+// setgid bit inherit the group of the directory.
+func (f *containerFilesystem) setNewOwner(identity *fileIdentity, resolved resolvedFile, header *tar.Header) {
+	header.Uid = identity.uid
+	header.Gid = identity.gid
+	if parent := resolved.parent(); parent.mode&os.ModeSetgid != 0 {
+		header.Gid = parent.gid
+	}
+}
+
+// upload extracts a single entry at the resolved path in the container. The archive is extracted into the allowed
+// path containing the entry and also contains the directories between the two with the metadata seen during
+// resolution. If one of them has been replaced with a symbolic link in the meantime Docker replaces the link with a
+// directory instead of following it.
+func (f *containerFilesystem) upload(
+	ctx context.Context,
+	identity *fileIdentity,
+	resolved resolvedFile,
+	header *tar.Header,
+	content io.Reader,
+) error {
+	base := allowedBase(f.getAllowedPaths(identity), resolved.path)
+	if base == resolved.path {
+		// The allowed path itself is created or modified, which is only possible from its parent.
+		base = path.Dir(base)
+	}
+	var headers []*tar.Header
+	for i, directory := range resolved.directories {
+		if !strings.HasPrefix(directory.path, strings.TrimSuffix(base, "/")+"/") {
+			continue
+		}
+		modTime := directory.file.modTime
+		if i == len(resolved.directories)-1 {
+			modTime = time.Now()
+		}
+		headers = append(headers, &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     relativePath(base, directory.path) + "/",
+			Mode:     directory.file.tarMode(),
+			Uid:      directory.file.uid,
+			Gid:      directory.file.gid,
+			ModTime:  modTime,
+		})
+	}
+	header.Name = relativePath(base, resolved.path)
+	if header.Typeflag == tar.TypeDir {
+		header.Name += "/"
+	}
+	headers = append(headers, header)
+
+	reader, writer := io.Pipe()
+	go func() {
+		tarWriter := tar.NewWriter(writer)
+		var err error
+		for _, h := range headers {
+			if err = tarWriter.WriteHeader(h); err != nil {
+				break
+			}
+		}
+		if err == nil && content != nil {
+			_, err = io.CopyN(tarWriter, content, header.Size)
+		}
+		if err == nil {
+			err = tarWriter.Close()
+		}
+		_ = writer.CloseWithError(err)
+	}()
+	err := f.container.copyTo(ctx, base, reader, false)
+	_ = reader.Close()
+	return f.mapError(err, resolved.path)
+}
+
+// relativePath returns the path of the target relative to the base directory containing it.
+func relativePath(base string, target string) string {
+	if base == "/" {
+		return strings.TrimPrefix(target, "/")
+	}
+	return strings.TrimPrefix(target, base+"/")
+}
+
+// remove removes a file or an empty directory in the container as the session user.
+func (f *containerFilesystem) remove(ctx context.Context, filePath string, directory bool) error {
+	if err := f.checkWrite(filePath); err != nil {
+		return err
+	}
+	resolved, err := f.resolve(ctx, filePath, false)
+	if err != nil {
+		return err
+	}
+	if resolved.file == nil {
+		return &os.PathError{Op: "remove", Path: filePath, Err: os.ErrNotExist}
+	}
+	if directory {
+		return f.runCommand(ctx, []string{"rmdir", "--", resolved.path})
+	}
+	if resolved.file.IsDir() {
+		return &os.PathError{Op: "remove", Path: filePath, Err: syscall.EISDIR}
+	}
+	return f.runCommand(ctx, []string{"rm", "-f", "--", resolved.path})
+}
+
+// rename moves a file or directory in the container as the session user. The target must not exist.
+func (f *containerFilesystem) rename(ctx context.Context, oldPath string, newPath string) error {
+	if err := f.checkWrite(oldPath); err != nil {
+		return err
+	}
+	oldResolved, err := f.resolve(ctx, oldPath, false)
+	if err != nil {
+		return err
+	}
+	if oldResolved.file == nil {
+		return &os.PathError{Op: "rename", Path: oldPath, Err: os.ErrNotExist}
+	}
+	newResolved, err := f.resolve(ctx, newPath, false)
+	if err != nil {
+		return err
+	}
+	if newResolved.file != nil {
+		return &os.PathError{Op: "rename", Path: newPath, Err: os.ErrExist}
+	}
+	return f.runCommand(ctx, []string{"mv", "--", oldResolved.path, newResolved.path})
+}
+
+// chmod changes the permissions of a file or directory owned by the session user. The new mode is applied through
+// the archive API by extracting the directory or the content of the file again.
+func (f *containerFilesystem) chmod(ctx context.Context, filePath string, mode os.FileMode) error {
+	if err := f.checkWrite(filePath); err != nil {
+		return err
+	}
+	resolved, err := f.resolve(ctx, filePath, true)
+	if err != nil {
+		return err
+	}
+	if resolved.file == nil {
+		return &os.PathError{Op: "chmod", Path: filePath, Err: os.ErrNotExist}
+	}
+	identity, err := f.getIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	if identity.uid != 0 && identity.uid != resolved.file.uid {
+		return &os.PathError{Op: "chmod", Path: filePath, Err: os.ErrPermission}
+	}
+	header := &tar.Header{
+		Mode:    int64(mode.Perm()),
+		Uid:     resolved.file.uid,
+		Gid:     resolved.file.gid,
+		ModTime: resolved.file.modTime,
+	}
+	switch {
+	case resolved.file.IsDir():
+		header.Typeflag = tar.TypeDir
+		// Directories keep their special bits, such as the sticky bit of shared directories.
+		header.Mode |= resolved.file.tarMode() &^ 0777
+		return f.upload(ctx, identity, resolved, header, nil)
+	case resolved.file.mode.IsRegular():
+		reader, file, err := f.open(ctx, resolved.path)
+		if err != nil {
+			return f.mapError(err, filePath)
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		header.Typeflag = tar.TypeReg
+		header.Size = file.size
+		return f.upload(ctx, identity, resolved, header, reader)
+	default:
+		return fmt.Errorf("%s is not a regular file or directory", filePath)
+	}
+}
+
+// runCommand runs a program in the container as the session user.
+func (f *containerFilesystem) runCommand(ctx context.Context, program []string) error {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	result, err := runCommand(ctx, f.container, program, map[string]string{}, f.user)
+	if err != nil {
+		return err
+	}
+	switch result.exitStatus {
+	case 0:
+		return nil
+	case 126, 127:
+		return fmt.Errorf("%s cannot be run in the container (%w)", program[0], errProgramUnavailable)
+	default:
+		return fmt.Errorf(
+			"%s exited with status %d: %s",
+			program[0],
+			result.exitStatus,
+			strings.TrimSpace(string(result.stderr)),
+		)
+	}
+}
+
+// mapError converts not found errors from Docker into errors recognized by os.IsNotExist.
+func (f *containerFilesystem) mapError(err error, filePath string) error {
+	if err == nil {
+		return nil
+	}
+	if isNotFoundError(err) {
+		return &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+// temporaryFile is a file on the host that is removed when closed.
+type temporaryFile struct {
+	*os.File
+}
+
+func newTemporaryFile() (*temporaryFile, error) {
+	fh, err := ioutil.TempFile("", "containerssh-")
+	if err != nil {
+		return nil, err
+	}
+	return &temporaryFile{fh}, nil
+}
+
+func (t *temporaryFile) Close() error {
+	err := t.File.Close()
+	_ = os.Remove(t.File.Name())
+	return err
+}
@@ -0,0 +1,507 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeFile struct {
+	mode    os.FileMode
+	uid     int
+	gid     int
+	content string
+	link    string
+}
+
+type fakeUpload struct {
+	directory string
+	headers   []*tar.Header
+	content   string
+}
+
+// fakeFilesystemContainer serves the archive API from an in-memory directory tree. Like the Docker daemon it does not
+// check permissions, but unlike the daemon it does not follow symbolic links either, so every path the filesystem
+// passes must already be resolved.
+type fakeFilesystemContainer struct {
+	dockerContainer
+
+	lock    *sync.Mutex
+	user    string
+	files   map[string]*fakeFile
+	uploads []fakeUpload
+	copied  []string
+}
+
+func newFakeFilesystemContainer() *fakeFilesystemContainer {
+	return &fakeFilesystemContainer{
+		lock: &sync.Mutex{},
+		user: "alice",
+		files: map[string]*fakeFile{
+			"/etc":               {mode: os.ModeDir | 0755},
+			"/etc/passwd":        {mode: 0644, content: "root:x:0:0:root:/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/sh\nbob:x:1001:1001::/home/bob:/bin/sh\n"},
+			"/etc/group":         {mode: 0644, content: "root:x:0:\nalice:x:1000:\nbob:x:1001:\nshared:x:2000:alice,bob\n"},
+			"/etc/shadow":        {mode: 0640, content: "root:secret"},
+			"/home":              {mode: os.ModeDir | 0755},
+			"/home/alice":        {mode: os.ModeDir | 0750, uid: 1000, gid: 1000},
+			"/home/alice/a.txt":  {mode: 0644, uid: 1000, gid: 1000, content: "hello world"},
+			"/home/alice/escape": {mode: os.ModeSymlink | 0777, uid: 1000, gid: 1000, link: "/etc"},
+			"/home/alice/up":     {mode: os.ModeSymlink | 0777, uid: 1000, gid: 1000, link: "../bob"},
+			"/home/alice/self":   {mode: os.ModeSymlink | 0777, uid: 1000, gid: 1000, link: "docs/../a.txt"},
+			"/home/alice/loop":   {mode: os.ModeSymlink | 0777, uid: 1000, gid: 1000, link: "loop"},
+			"/home/alice/docs":   {mode: os.ModeDir | 0755, uid: 1000, gid: 1000},
+			"/home/alice/docs/b": {mode: 0600, uid: 1000, gid: 1000, content: "b"},
+			"/home/bob":          {mode: os.ModeDir | 0700, uid: 1001, gid: 1001},
+			"/home/bob/secret":   {mode: 0644, uid: 1001, gid: 1001, content: "secret"},
+			"/srv":               {mode: os.ModeDir | 0775, gid: 2000},
+			"/srv/readonly":      {mode: 0444, gid: 2000, content: "readonly"},
+		},
+	}
+}
+
+func (f *fakeFilesystemContainer) inspect(_ context.Context) (types.ContainerJSON, error) {
+	return types.ContainerJSON{Config: &container.Config{User: f.user}}, nil
+}
+
+func (f *fakeFilesystemContainer) copyFrom(_ context.Context, filePath string) (
+	io.ReadCloser,
+	types.ContainerPathStat,
+	error,
+) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.copied = append(f.copied, filePath)
+	root, ok := f.files[filePath]
+	if !ok {
+		return nil, types.ContainerPathStat{}, errdefs.NotFound(errors.New("not found"))
+	}
+	var names []string
+	for name := range f.files {
+		if strings.HasPrefix(name, filePath+"/") && root.mode.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{filePath}, names...)
+
+	buf := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buf)
+	for _, name := range names {
+		file := f.files[name]
+		header := &tar.Header{
+			Name:    path.Join(path.Base(filePath), strings.TrimPrefix(name, filePath)),
+			Mode:    int64(file.mode.Perm()),
+			Uid:     file.uid,
+			Gid:     file.gid,
+			ModTime: time.Unix(1600000000, 0),
+		}
+		switch {
+		case file.mode.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		case file.mode&os.ModeSymlink != 0:
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = file.link
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(file.content))
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, types.ContainerPathStat{}, err
+		}
+		if _, err := tarWriter.Write([]byte(file.content)); err != nil {
+			return nil, types.ContainerPathStat{}, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, types.ContainerPathStat{}, err
+	}
+	return ioutil.NopCloser(buf), types.ContainerPathStat{Name: path.Base(filePath), Mode: root.mode}, nil
+}
+
+func (f *fakeFilesystemContainer) statPath(_ context.Context, filePath string) (types.ContainerPathStat, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	file, ok := f.files[filePath]
+	if !ok {
+		return types.ContainerPathStat{}, errdefs.NotFound(errors.New("not found"))
+	}
+	return types.ContainerPathStat{Name: path.Base(filePath), Mode: file.mode, Size: int64(len(file.content))}, nil
+}
+
+// createExec runs the programs as if the image did not have them, like distroless images.
+func (f *fakeFilesystemContainer) createExec(
+	_ context.Context,
+	_ []string,
+	_ map[string]string,
+	_ bool,
+	_ string,
+) (dockerExecution, error) {
+	return &fakeMissingProgram{}, nil
+}
+
+type fakeMissingProgram struct {
+	dockerExecution
+}
+
+func (f *fakeMissingProgram) run(
+	_ io.Reader,
+	_ io.Writer,
+	_ io.Writer,
+	_ func() error,
+	onExit func(exitStatus int),
+) {
+	onExit(127)
+}
+
+func (f *fakeFilesystemContainer) copyTo(_ context.Context, directory string, archive io.Reader, _ bool) error {
+	upload := fakeUpload{directory: directory}
+	tarReader := tar.NewReader(archive)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		upload.headers = append(upload.headers, header)
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return err
+		}
+		upload.content += string(content)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.uploads = append(f.uploads, upload)
+	return nil
+}
+
+func newTestFilesystem(cnt *fakeFilesystemContainer, allowedPaths ...string) *containerFilesystem {
+	return newContainerFilesystem(cnt, time.Second, "", FileTransferConfig{AllowedPaths: allowedPaths})
+}
+
+// TestLookupIdentity tests if Docker user specifications are resolved against the account files.
+func TestLookupIdentity(t *testing.T) {
+	t.Parallel()
+
+	passwd := []byte("root:x:0:0:root:/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/sh\n")
+	group := []byte("root:x:0:\nalice:x:1000:\nshared:x:2000:bob,alice\n")
+
+	identity, err := lookupIdentity("alice", passwd, group)
+	assert.NoError(t, err)
+	assert.Equal(t, &fileIdentity{uid: 1000, gid: 1000, groups: []int{2000}, home: "/home/alice"}, identity)
+
+	identity, err = lookupIdentity("1000:shared", passwd, group)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, identity.uid)
+	assert.Equal(t, 2000, identity.gid)
+
+	identity, err = lookupIdentity("", passwd, group)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, identity.uid)
+	assert.Equal(t, "/root", identity.home)
+
+	// A numeric user missing from the passwd file runs with the root group, like in Docker.
+	identity, err = lookupIdentity("1234", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &fileIdentity{uid: 1234, gid: 0, home: "/"}, identity)
+
+	_, err = lookupIdentity("mallory", passwd, group)
+	assert.Error(t, err)
+	_, err = lookupIdentity("alice:nogroup", passwd, group)
+	assert.Error(t, err)
+}
+
+// TestIdentityPermits tests if the owner, group and other permission bits are checked.
+func TestIdentityPermits(t *testing.T) {
+	t.Parallel()
+
+	identity := &fileIdentity{uid: 1000, gid: 1000, groups: []int{2000}}
+	assert.True(t, identity.permits(&containerFile{uid: 1000, gid: 0, mode: 0600}, accessRead|accessWrite))
+	assert.False(t, identity.permits(&containerFile{uid: 1000, gid: 2000, mode: 0077}, accessRead))
+	assert.True(t, identity.permits(&containerFile{uid: 0, gid: 2000, mode: 0050}, accessRead|accessExecute))
+	assert.False(t, identity.permits(&containerFile{uid: 0, gid: 2000, mode: 0707}, accessRead))
+	assert.True(t, identity.permits(&containerFile{uid: 0, gid: 0, mode: 0004}, accessRead))
+	assert.False(t, identity.permits(&containerFile{uid: 0, gid: 0, mode: 0004}, accessWrite))
+
+	root := &fileIdentity{}
+	assert.True(t, root.permits(&containerFile{uid: 1000, mode: 0}, accessRead|accessWrite))
+}
+
+// TestAllowedBase tests if the longest allowed path containing the path is selected.
+func TestAllowedBase(t *testing.T) {
+	t.Parallel()
+
+	allowed := []string{"/home", "/home/alice/", "/srv"}
+	assert.Equal(t, "/home/alice", allowedBase(allowed, "/home/alice/a.txt"))
+	assert.Equal(t, "/home/alice", allowedBase(allowed, "/home/alice"))
+	assert.Equal(t, "/home", allowedBase(allowed, "/home/bob"))
+	assert.Equal(t, "", allowedBase(allowed, "/home2/x"))
+	assert.Equal(t, "", allowedBase(allowed, "/etc/passwd"))
+	assert.Equal(t, "/", allowedBase([]string{"/"}, "/etc/passwd"))
+}
+
+// TestFilesystemDefaultsToHome tests if relative paths and the allowed paths default to the home directory.
+func TestFilesystemDefaultsToHome(t *testing.T) {
+	t.Parallel()
+
+	fs := newTestFilesystem(newFakeFilesystemContainer())
+	ctx := context.Background()
+
+	info, err := fs.stat(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), info.Size())
+
+	_, err = fs.stat(ctx, "/srv/readonly")
+	assert.True(t, os.IsPermission(err))
+
+	files, err := fs.list(ctx, ".")
+	assert.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.Equal(t, []string{"a.txt", "docs", "escape", "loop", "self", "up"}, names)
+}
+
+// TestFilesystemSymlinks tests if symbolic links are resolved inside the container before the allowed paths are
+// checked.
+func TestFilesystemSymlinks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fs := newTestFilesystem(newFakeFilesystemContainer(), "/home/alice")
+
+	_, _, err := fs.read(ctx, "/home/alice/escape/shadow")
+	assert.True(t, os.IsPermission(err))
+	_, _, err = fs.read(ctx, "/home/alice/escape/passwd")
+	assert.True(t, os.IsPermission(err))
+
+	reader, _, err := fs.read(ctx, "/home/alice/self")
+	assert.NoError(t, err)
+	content, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+	assert.NoError(t, reader.Close())
+
+	_, err = fs.stat(ctx, "/home/alice/loop")
+	assert.Error(t, err)
+
+	// The link itself is inside the allowed path and can be removed without following it.
+	resolved, err := fs.resolve(ctx, "/home/alice/escape", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/alice/escape", resolved.path)
+}
+
+// TestFilesystemPermissions tests if the permissions of the session user are enforced even though the archive API
+// runs as root.
+func TestFilesystemPermissions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cnt := newFakeFilesystemContainer()
+	fs := newTestFilesystem(cnt, "/")
+
+	_, _, err := fs.read(ctx, "/etc/shadow")
+	assert.True(t, os.IsPermission(err))
+	// Bob's home directory cannot be searched by alice, even through a link.
+	_, err = fs.stat(ctx, "/home/alice/up/secret")
+	assert.True(t, os.IsPermission(err))
+	_, err = fs.list(ctx, "/home/bob")
+	assert.True(t, os.IsPermission(err))
+
+	assert.True(t, os.IsPermission(fs.write(ctx, "/etc/new", 0644, strings.NewReader("x"), 1)))
+	assert.True(t, os.IsPermission(fs.write(ctx, "/srv/readonly", 0644, strings.NewReader("x"), 1)))
+	assert.True(t, os.IsPermission(fs.chmod(ctx, "/srv/readonly", 0666)))
+	// Alice can create files in /srv through the shared group.
+	assert.NoError(t, fs.write(ctx, "/srv/new", 0640, strings.NewReader("x"), 1))
+
+	cnt.user = "root"
+	rootFS := newTestFilesystem(cnt, "/")
+	_, _, err = rootFS.read(ctx, "/etc/shadow")
+	assert.NoError(t, err)
+}
+
+// TestFilesystemUpload tests if uploads are extracted into the allowed path with the session user as owner and the
+// directories on the way pinned.
+func TestFilesystemUpload(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cnt := newFakeFilesystemContainer()
+	fs := newTestFilesystem(cnt)
+
+	assert.NoError(t, fs.write(ctx, "docs/new.txt", 0640, strings.NewReader("content"), 7))
+	assert.Len(t, cnt.uploads, 1)
+	upload := cnt.uploads[0]
+	assert.Equal(t, "/home/alice", upload.directory)
+	assert.Len(t, upload.headers, 2)
+	assert.Equal(t, "docs/", upload.headers[0].Name)
+	assert.Equal(t, byte(tar.TypeDir), upload.headers[0].Typeflag)
+	assert.Equal(t, int64(0755), upload.headers[0].Mode)
+	assert.Equal(t, "docs/new.txt", upload.headers[1].Name)
+	assert.Equal(t, 1000, upload.headers[1].Uid)
+	assert.Equal(t, 1000, upload.headers[1].Gid)
+	assert.Equal(t, int64(0640), upload.headers[1].Mode)
+	assert.Equal(t, "content", upload.content)
+
+	// Overwriting keeps the owner and mode of the existing file.
+	assert.NoError(t, fs.write(ctx, "docs/b", 0777, strings.NewReader("new"), 3))
+	assert.Equal(t, int64(0600), cnt.uploads[1].headers[1].Mode)
+
+	// Changing the mode of a file extracts its content again.
+	assert.NoError(t, fs.chmod(ctx, "a.txt", 0600))
+	upload = cnt.uploads[2]
+	assert.Len(t, upload.headers, 1)
+	assert.Equal(t, "a.txt", upload.headers[0].Name)
+	assert.Equal(t, int64(0600), upload.headers[0].Mode)
+	assert.Equal(t, "hello world", upload.content)
+
+	assert.True(t, os.IsExist(fs.mkdir(ctx, "docs", 0755)))
+	assert.NoError(t, fs.mkdir(ctx, "docs/sub", 0700))
+	assert.Equal(t, "docs/sub/", cnt.uploads[3].headers[1].Name)
+}
+
+// TestFilesystemReadOnly tests if the read-only setting rejects all modifications.
+func TestFilesystemReadOnly(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cnt := newFakeFilesystemContainer()
+	fs := newContainerFilesystem(cnt, time.Second, "", FileTransferConfig{ReadOnly: true})
+
+	assert.True(t, os.IsPermission(fs.write(ctx, "new.txt", 0644, strings.NewReader("x"), 1)))
+	assert.True(t, os.IsPermission(fs.mkdir(ctx, "new", 0755)))
+	assert.True(t, os.IsPermission(fs.chmod(ctx, "a.txt", 0600)))
+	assert.True(t, os.IsPermission(fs.remove(ctx, "a.txt", false)))
+	assert.True(t, os.IsPermission(fs.rename(ctx, "a.txt", "b.txt")))
+	assert.Empty(t, cnt.uploads)
+}
+
+// TestSFTPDownloadOutOfOrder tests if reads arriving out of order are served without reopening the stream.
+func TestSFTPDownloadOutOfOrder(t *testing.T) {
+	t.Parallel()
+
+	content := make([]byte, 100000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	opened := 0
+	open := func() (io.ReadCloser, error) {
+		opened++
+		return ioutil.NopCloser(bytes.NewReader(content)), nil
+	}
+	reader, _ := open()
+	download := &sftpDownload{lock: &sync.Mutex{}, open: open, reader: reader}
+
+	buf := make([]byte, 32768)
+	for _, offset := range []int64{32768, 0, 65536} {
+		n, err := download.ReadAt(buf, offset)
+		assert.NoError(t, err)
+		assert.Equal(t, content[offset:offset+int64(n)], buf[:n])
+	}
+	n, err := download.ReadAt(buf, 98304)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 100000-98304, n)
+	assert.Equal(t, content[98304:], buf[:n])
+	assert.Equal(t, 1, opened)
+}
+
+// TestFilesystemMissingPaths tests if missing paths are not archived.
+func TestFilesystemMissingPaths(t *testing.T) {
+	t.Parallel()
+
+	cnt := newFakeFilesystemContainer()
+	fs := newTestFilesystem(cnt)
+	_, err := fs.stat(context.Background(), "missing")
+	assert.True(t, os.IsNotExist(err))
+	assert.NotContains(t, cnt.copied, "/home/alice/missing")
+}
+
+// TestFilesystemListLimit tests if reading the archive of a directory stops at the limit.
+func TestFilesystemListLimit(t *testing.T) {
+	t.Parallel()
+
+	cnt := newFakeFilesystemContainer()
+	cnt.files["/home/alice/large"] = &fakeFile{mode: 0644, uid: 1000, gid: 1000, content: strings.Repeat("x", 100000)}
+	fs := newTestFilesystem(cnt)
+	ignore := func(_ string, _ *containerFile, _ io.Reader) (bool, error) {
+		return true, nil
+	}
+	err := fs.walkLimited(context.Background(), "/home/alice", 10000, ignore)
+	assert.True(t, errors.Is(err, errArchiveTooLarge))
+	assert.NoError(t, fs.walkLimited(context.Background(), "/home/alice", 1000000, ignore))
+
+	files, err := fs.list(context.Background(), "/home/alice")
+	assert.NoError(t, err)
+	assert.Len(t, files, 7)
+}
+
+// TestSFTPUnsupportedWithoutPrograms tests if removing and renaming are reported as unsupported if the image does not
+// have the programs.
+func TestSFTPUnsupportedWithoutPrograms(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fs := newTestFilesystem(newFakeFilesystemContainer())
+	err := fs.remove(ctx, "a.txt", false)
+	assert.True(t, errors.Is(err, errProgramUnavailable))
+	err = fs.rename(ctx, "a.txt", "c.txt")
+	assert.True(t, errors.Is(err, errProgramUnavailable))
+
+	handler := &sftpHandler{fs: fs, logger: log.NewTestLogger(t)}
+	assert.Equal(t, sftp.ErrSSHFxOpUnsupported, handler.mapUnsupported(err))
+	assert.True(t, os.IsNotExist(handler.mapUnsupported(fs.remove(ctx, "missing", false))))
+}
+
+// TestSFTPUploadChecks tests if uploads the user may not write are rejected before they are staged, and uploads are
+// limited to the maximum size.
+func TestSFTPUploadChecks(t *testing.T) {
+	t.Parallel()
+
+	cnt := newFakeFilesystemContainer()
+	fs := newContainerFilesystem(cnt, time.Second, "", FileTransferConfig{MaxUploadSize: 10})
+	handler := &sftpHandler{fs: fs, logger: log.NewTestLogger(t)}
+
+	_, err := handler.Filewrite(sftp.NewRequest("Put", "/etc/passwd"))
+	assert.Error(t, err)
+	_, err = handler.Filewrite(sftp.NewRequest("Put", "/home/bob/new"))
+	assert.True(t, os.IsPermission(err))
+
+	// The existing content exceeds the limit.
+	_, err = handler.Filewrite(sftp.NewRequest("Put", "/home/alice/a.txt"))
+	assert.True(t, errors.Is(err, errUploadTooLarge))
+
+	writer, err := handler.Filewrite(sftp.NewRequest("Put", "/home/alice/new.txt"))
+	assert.NoError(t, err)
+	_, err = writer.WriteAt([]byte("0123456789"), 0)
+	assert.NoError(t, err)
+	_, err = writer.WriteAt([]byte("x"), 10)
+	assert.True(t, errors.Is(err, errUploadTooLarge))
+	assert.NoError(t, writer.(io.Closer).Close())
+	assert.Equal(t, "0123456789", cnt.uploads[0].content)
+
+	// Streamed uploads are limited too.
+	err = fs.write(context.Background(), "other.txt", 0644, strings.NewReader("01234567890"), 11)
+	assert.True(t, errors.Is(err, errUploadTooLarge))
+	assert.Len(t, cnt.uploads, 1)
+}
@@ -3,6 +3,7 @@ package docker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 
@@ -24,6 +25,8 @@ type channelHandler struct {
 	exitSent       bool
 	exec           dockerExecution
 	session        sshserver.SessionChannel
+	// containerSlot counts the container of the session towards the concurrency limits in execution mode "session".
+	containerSlot *containerSlot
 }
 
 func (c *channelHandler) OnEnvRequest(_ uint64, name string, value string) error {
@@ -57,20 +60,45 @@ func (c *channelHandler) OnPtyRequest(
 	return nil
 }
 
-func (c *channelHandler) parseProgram(program string) []string {
+// parseProgram turns the command into a program. The returned flag is true if the program runs the command through
+// the shell.
+func (c *channelHandler) parseProgram(ctx context.Context, program string) ([]string, bool, error) {
+	parsing := c.networkHandler.config.Execution.ProgramParsing
+	if parsing.Mode == ProgramParsingShell {
+		return c.shellProgram(ctx, program), true, nil
+	}
 	programParts, err := unixutils.ParseCMD(program)
+	if err == nil && len(programParts) == 0 {
+		err = fmt.Errorf("empty command")
+	}
 	if err != nil {
-		return []string{"/bin/sh", "-c", program}
-	} else {
-		if strings.HasPrefix(programParts[0], "/") || strings.HasPrefix(
-			programParts[0],
-			"./",
-		) || strings.HasPrefix(programParts[0], "../") {
-			return programParts
-		} else {
-			return []string{"/bin/sh", "-c", program}
+		if parsing.Mode == ProgramParsingReject {
+			return nil, false, log.WrapUser(
+				err,
+				EProgramParseFailed,
+				"Cannot parse the requested command.",
+				"failed to parse the requested command",
+			)
 		}
+		return c.shellProgram(ctx, program), true, nil
+	}
+	switch parsing.Mode {
+	case ProgramParsingExec:
+		fallthrough
+	case ProgramParsingReject:
+		return programParts, false, nil
+	}
+	if strings.HasPrefix(programParts[0], "/") || strings.HasPrefix(
+		programParts[0],
+		"./",
+	) || strings.HasPrefix(programParts[0], "../") {
+		return programParts, false, nil
 	}
+	return c.shellProgram(ctx, program), true, nil
+}
+
+func (c *channelHandler) shellProgram(ctx context.Context, program string) []string {
+	return []string{c.networkHandler.getShell(ctx), "-c", program}
 }
 
 func (c *channelHandler) run(
@@ -86,6 +114,8 @@ func (c *channelHandler) run(
 	var err error
 	switch c.networkHandler.config.Execution.Mode {
 	case ExecutionModeConnection:
+		fallthrough
+	case ExecutionModeUser:
 		err = c.handleExecModeConnection(ctx, program)
 	case ExecutionModeSession:
 		err = c.handleExecModeSession(ctx, program)
@@ -100,6 +130,25 @@ func (c *channelHandler) run(
 	if err != nil {
 		return err
 	}
+	c.attachExec()
+	return nil
+}
+
+// runBuiltin runs a program implemented in ContainerSSH on the channel instead of a program in the container.
+func (c *channelHandler) runBuiltin(exec dockerExecution) error {
+	c.networkHandler.mutex.Lock()
+	defer c.networkHandler.mutex.Unlock()
+	if c.exec != nil {
+		return log.UserMessage(EProgramAlreadyRunning, "program already running", "program already running")
+	}
+	c.exec = exec
+	c.attachExec()
+	return nil
+}
+
+// attachExec connects the streams of the SSH channel to the current execution.
+func (c *channelHandler) attachExec() {
+	c.startRecording()
 
 	c.exec.run(
 		c.session.Stdin(),
@@ -117,15 +166,35 @@ func (c *channelHandler) run(
 			}
 		},
 	)
+}
 
-	return nil
+func (c *channelHandler) startRecording() {
+	config := c.networkHandler.config.Recording
+	if !config.Enable || !c.pty {
+		return
+	}
+	recorder, err := newAsciicastRecorder(
+		config,
+		c.networkHandler.connectionID,
+		c.channelID,
+		c.columns,
+		c.rows,
+		c.env,
+		c.networkHandler.logger,
+	)
+	if err != nil {
+		// A failed recording should not prevent the user from working.
+		c.networkHandler.logger.Error(err)
+		return
+	}
+	c.exec.record(recorder)
 }
 
 func (c *channelHandler) handleExecModeConnection(
 	ctx context.Context,
 	program []string,
 ) error {
-	exec, err := c.networkHandler.container.createExec(ctx, program, c.env, c.pty)
+	exec, err := c.networkHandler.container.createExec(ctx, program, c.env, c.pty, c.networkHandler.execUser)
 	if err != nil {
 		return err
 	}
@@ -143,6 +212,10 @@ func (c *channelHandler) handleExecModeSession(
 	ctx context.Context,
 	program []string,
 ) error {
+	slot, err := c.networkHandler.admitContainer()
+	if err != nil {
+		return err
+	}
 	cnt, err := c.networkHandler.dockerClient.createContainer(
 		ctx,
 		c.networkHandler.labels,
@@ -151,14 +224,21 @@ func (c *channelHandler) handleExecModeSession(
 		program,
 	)
 	if err != nil {
+		slot.release()
 		return err
 	}
+	c.containerSlot = slot
 	removeContainer := func() {
 		ctx, cancelFunc := context.WithTimeout(
 			context.Background(), c.networkHandler.config.Timeouts.ContainerStop,
 		)
 		defer cancelFunc()
 		_ = cnt.remove(ctx)
+		c.containerSlot.release()
+	}
+	if err := c.networkHandler.seedFiles(ctx, cnt); err != nil {
+		removeContainer()
+		return err
 	}
 	c.exec, err = cnt.attach(ctx)
 	if err != nil {
@@ -183,6 +263,9 @@ func (c *channelHandler) OnExecRequest(
 	_ uint64,
 	program string,
 ) error {
+	if err := c.networkHandler.checkDraining(); err != nil {
+		return err
+	}
 	if c.networkHandler.config.Execution.disableCommand {
 		return log.UserMessage(
 			EProgramExecutionDisabled,
@@ -190,21 +273,22 @@ func (c *channelHandler) OnExecRequest(
 			"Command execution is disabled.",
 		)
 	}
-	startContext, cancelFunc := context.WithTimeout(context.Background(), c.networkHandler.config.Timeouts.CommandStart)
-	defer cancelFunc()
-	return c.run(
-		startContext,
-		c.parseProgram(program),
-	)
+	if forcedCommand, ok := c.getForcedCommand(); ok {
+		return c.startCommand(PolicyRequestExec, forcedCommand, program)
+	}
+	return c.startCommand(PolicyRequestExec, program, "")
 }
 
 func (c *channelHandler) OnShell(
 	_ uint64,
 ) error {
-	startContext, cancelFunc := context.WithTimeout(context.Background(), c.networkHandler.config.Timeouts.CommandStart)
-	defer cancelFunc()
-
-	return c.run(startContext, c.getDefaultShell())
+	if err := c.networkHandler.checkDraining(); err != nil {
+		return err
+	}
+	if forcedCommand, ok := c.getForcedCommand(); ok {
+		return c.startCommand(PolicyRequestShell, forcedCommand, "")
+	}
+	return c.start(PolicyRequestShell, "", c.getDefaultShell(), c.getDefaultShell(), false, "")
 }
 
 func (c *channelHandler) getDefaultShell() []string {
@@ -215,13 +299,169 @@ func (c *channelHandler) OnSubsystem(
 	_ uint64,
 	subsystem string,
 ) error {
+	if err := c.networkHandler.checkDraining(); err != nil {
+		return err
+	}
+	execution := c.networkHandler.config.Execution
+	binary, ok := execution.Subsystems[subsystem]
+	builtinSFTP := subsystem == "sftp" && execution.FileTransfer.BuiltinSFTP
+	if !ok && !builtinSFTP {
+		return log.UserMessage(ESubsystemNotSupported, "subsystem not supported", "the specified subsystem is not supported (%s)", subsystem)
+	}
+	if forcedCommand, ok := c.getForcedCommand(); ok {
+		return c.startCommand(PolicyRequestSubsystem, forcedCommand, subsystem)
+	}
+	if builtinSFTP {
+		if err := c.checkPolicy(PolicyRequestSubsystem, subsystem, nil, false); err != nil {
+			return err
+		}
+		return c.runBuiltin(newSFTPExecution(c.getFilesystem(), c.networkHandler.logger))
+	}
+	return c.start(PolicyRequestSubsystem, subsystem, []string{binary}, []string{binary}, false, "")
+}
+
+// getFilesystem returns the filesystem of the container of the current connection.
+func (c *channelHandler) getFilesystem() *containerFilesystem {
+	return newContainerFilesystem(
+		c.networkHandler.container,
+		c.networkHandler.config.Timeouts.CommandStart,
+		c.networkHandler.execUser,
+		c.networkHandler.config.Execution.FileTransfer,
+	)
+}
+
+// getForcedCommand returns the command configured to replace the client request for the current user, if any.
+func (c *channelHandler) getForcedCommand() (string, bool) {
+	execution := c.networkHandler.config.Execution
+	for _, rule := range execution.ForceCommand {
+		if rule.matches(c.username, execution.Groups) {
+			return rule.Command, true
+		}
+	}
+	return "", false
+}
+
+// startCommand parses the command and starts the resulting program.
+func (c *channelHandler) startCommand(requestType PolicyRequestType, command string, originalCommand string) error {
+	parseContext, cancelFunc := context.WithTimeout(context.Background(), c.networkHandler.config.Timeouts.CommandStart)
+	defer cancelFunc()
+	program, shell, err := c.parseProgram(parseContext, command)
+	if err != nil {
+		c.networkHandler.logger.Debug(err)
+		return err
+	}
+	argv, opaque := program, false
+	if shell {
+		argv, opaque = policyArgv(command)
+	}
+	return c.start(requestType, command, program, argv, opaque, originalCommand)
+}
+
+// start checks the execution policy and runs the program. The argv is the program before it is wrapped in a shell,
+// opaque if the shell may run a different program. If a forced command replaced the client request, the
+// originalCommand is exposed to the program in the SSH_ORIGINAL_COMMAND environment variable.
+func (c *channelHandler) start(
+	requestType PolicyRequestType,
+	command string,
+	program []string,
+	argv []string,
+	opaque bool,
+	originalCommand string,
+) error {
+	if err := c.checkPolicy(requestType, command, argv, opaque); err != nil {
+		return err
+	}
+	// SCP is detected on the command as the client sent it, since the program may already be wrapped in a shell.
+	if c.networkHandler.config.Execution.FileTransfer.BuiltinSCP && !opaque {
+		if options, ok := parseSCPProgram(argv); ok {
+			return c.runBuiltin(newSCPExecution(options, c.getFilesystem(), c.networkHandler.logger))
+		}
+	}
+	if originalCommand != "" {
+		c.networkHandler.mutex.Lock()
+		c.env["SSH_ORIGINAL_COMMAND"] = originalCommand
+		c.networkHandler.mutex.Unlock()
+	}
+
 	startContext, cancelFunc := context.WithTimeout(context.Background(), c.networkHandler.config.Timeouts.CommandStart)
 	defer cancelFunc()
 
-	if binary, ok := c.networkHandler.config.Execution.Subsystems[subsystem]; ok {
-		return c.run(startContext, []string{binary})
+	if detach := c.networkHandler.config.Execution.Detach; detach.Enable {
+		if name, ok := parseAttachCommand(detach.AttachCommand, command); ok && requestType == PolicyRequestExec {
+			return c.attachSession(name)
+		}
+		c.networkHandler.mutex.Lock()
+		name := c.env[detach.SessionEnv]
+		c.networkHandler.mutex.Unlock()
+		if name != "" {
+			return c.runDetachable(startContext, name, program)
+		}
+	}
+	return c.run(startContext, program)
+}
+
+// attachSession attaches the channel to a detached session of the user.
+func (c *channelHandler) attachSession(name string) error {
+	c.networkHandler.mutex.Lock()
+	defer c.networkHandler.mutex.Unlock()
+	if c.exec != nil {
+		return log.UserMessage(EProgramAlreadyRunning, "program already running", "program already running")
+	}
+	session := findDetachableSession(c.networkHandler.username, name)
+	if session == nil {
+		return log.UserMessage(
+			ESessionNotFound,
+			"session not found",
+			"detachable session %s of user %s not found",
+			name,
+			c.networkHandler.username,
+		)
+	}
+	attachment, err := session.claim()
+	if err != nil {
+		return err
+	}
+	c.exec = attachment
+	c.attachExec()
+	return nil
+}
+
+// runDetachable attaches the named session if it exists, or starts the program as a new detachable session.
+func (c *channelHandler) runDetachable(ctx context.Context, name string, program []string) error {
+	if findDetachableSession(c.networkHandler.username, name) != nil {
+		return c.attachSession(name)
 	}
-	return log.UserMessage(ESubsystemNotSupported, "subsystem not supported", "the specified subsystem is not supported (%s)", subsystem)
+	c.networkHandler.mutex.Lock()
+	defer c.networkHandler.mutex.Unlock()
+	if c.exec != nil {
+		return log.UserMessage(EProgramAlreadyRunning, "program already running", "program already running")
+	}
+	attachment, err := c.networkHandler.reserveDetachableSession(name)
+	if err != nil {
+		return err
+	}
+	if err := c.handleExecModeConnection(ctx, program); err != nil {
+		attachment.session.remove()
+		return err
+	}
+	attachment.session.start(c.exec)
+	c.exec = attachment
+	c.attachExec()
+	return nil
+}
+
+func (c *channelHandler) checkPolicy(
+	requestType PolicyRequestType,
+	command string,
+	argv []string,
+	opaque bool,
+) error {
+	execution := c.networkHandler.config.Execution
+	err := execution.Policy.check(requestType, c.username, execution.Groups, command, argv, opaque)
+	if err != nil {
+		c.networkHandler.logger.Debug(err)
+	}
+	return err
 }
 
 func (c *channelHandler) OnSignal(_ uint64, signal string) error {
@@ -259,6 +499,9 @@ func (c *channelHandler) OnWindow(_ uint64, columns uint32, rows uint32, _ uint3
 }
 
 func (c *channelHandler) OnClose() {
+	c.networkHandler.mutex.Lock()
+	delete(c.networkHandler.channels, c.channelID)
+	c.networkHandler.mutex.Unlock()
 	if c.exec != nil {
 		c.exec.kill()
 	}
@@ -268,6 +511,7 @@ func (c *channelHandler) OnClose() {
 		defer cancel()
 		_ = container.remove(ctx)
 	}
+	c.containerSlot.release()
 }
 
 func (c *channelHandler) OnShutdown(shutdownContext context.Context) {
@@ -282,3 +526,34 @@ func (c *channelHandler) OnShutdown(shutdownContext context.Context) {
 		}
 	}
 }
+
+// notify writes a message from the server to the stderr of the channel.
+func (c *channelHandler) notify(message string) {
+	lineEnding := "\n"
+	if c.pty {
+		lineEnding = "\r\n"
+	}
+	if _, err := c.session.Stderr().Write([]byte(message + lineEnding)); err != nil {
+		c.networkHandler.logger.Debug(log.Wrap(err, EFailedOutputCloseWriting, "failed to write notice"))
+	}
+}
+
+// closeForDrain ends the program of the channel and closes the channel when the drain timeout has passed.
+func (c *channelHandler) closeForDrain() {
+	c.networkHandler.mutex.Lock()
+	exec := c.exec
+	c.networkHandler.mutex.Unlock()
+	if exec != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.networkHandler.config.Timeouts.ContainerStop)
+		defer cancel()
+		exec.term(ctx)
+		select {
+		case <-ctx.Done():
+			exec.kill()
+		case <-exec.done():
+		}
+	}
+	if err := c.session.Close(); err != nil && !errors.Is(err, io.EOF) {
+		c.networkHandler.logger.Debug(log.Wrap(err, EFailedOutputCloseWriting, "failed to close session"))
+	}
+}
@@ -0,0 +1,26 @@
+package docker
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/containerssh/log"
+)
+
+// newTestHandler returns the handler of a connection of the user from the IP address. It has no Docker client or
+// container, tests set the parts they need.
+func newTestHandler(t *testing.T, username string, ip string, config Config) *networkHandler {
+	n := &networkHandler{
+		mutex:        &sync.Mutex{},
+		username:     username,
+		connectionID: "0123",
+		config:       config,
+		logger:       log.NewTestLogger(t),
+		labels:       map[string]string{},
+		channels:     map[uint64]*channelHandler{},
+		done:         make(chan struct{}),
+	}
+	n.client.IP = net.ParseIP(ip)
+	return n
+}
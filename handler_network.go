@@ -2,13 +2,19 @@ package docker
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"path"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/containerssh/log"
 	"github.com/containerssh/sshserver"
+	"github.com/docker/docker/api/types/mount"
 )
 
 type networkHandler struct {
@@ -26,6 +32,21 @@ type networkHandler struct {
 	disconnected        bool
 	labels              map[string]string
 	done                chan struct{}
+	audit               *auditLogger
+	// execUser is the user the execs of the sessions run as. Empty for the container user.
+	execUser string
+	// userContainer is the shared container of the user in execution mode "user".
+	userContainer *userContainer
+	// network is the name of the isolated network of the connection or user, if any.
+	network string
+	// userNetwork is the isolated network shared by the connections of the user in the "user" scope.
+	userNetwork *userNetwork
+	// auxiliaryContainers are started for the connection in addition to the user container and removed with it.
+	auxiliaryContainers []dockerContainer
+	// channels are the open session channels of the connection, keyed by channel ID.
+	channels map[uint64]*channelHandler
+	// containerSlot counts the container of the connection towards the concurrency limits.
+	containerSlot *containerSlot
 }
 
 func (n *networkHandler) OnAuthPassword(_ string, _ []byte) (response sshserver.AuthResponse, reason error) {
@@ -48,27 +69,51 @@ func (n *networkHandler) OnHandshakeSuccess(username string) (
 		context.Background(),
 		n.config.Timeouts.ContainerStart)
 	defer cancelFunc()
-	n.username = username
-
-	if err := n.setupDockerClient(ctx, n.config); err != nil {
-		return nil, err
-	}
-	if err := n.pullImage(ctx); err != nil {
+	if err := n.registerConnection(); err != nil {
 		return nil, err
 	}
+	n.username = username
+	n.audit = newAuditLogger(n.config.Audit, n.connectionID, username, n.client.IP.String(), n.logger)
+
 	labels := map[string]string{}
 	labels["containerssh_connection_id"] = n.connectionID
 	labels["containerssh_ip"] = n.client.IP.String()
 	labels["containerssh_username"] = n.username
+	labels[executionModeLabel] = string(n.config.Execution.Mode)
+	if n.config.Recovery.Instance != "" {
+		labels[instanceLabel] = n.config.Recovery.Instance
+	}
 	n.labels = labels
-	var cnt dockerContainer
-	var err error
-	if n.config.Execution.Mode == ExecutionModeConnection {
-		if cnt, err = n.dockerClient.createContainer(ctx, labels, nil, nil, nil); err != nil {
+
+	if err := n.setupDockerClient(ctx, n.config); err != nil {
+		return nil, err
+	}
+	if err := n.setupNetwork(ctx); err != nil {
+		return nil, err
+	}
+	if err := n.setupHomeVolume(ctx); err != nil {
+		return nil, err
+	}
+	if err := n.setupUserMapping(); err != nil {
+		return nil, err
+	}
+	n.setupResourceProfile()
+	reused, err := n.reuseCommittedImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !reused {
+		if err := n.pullImage(ctx, n.dockerClient); err != nil {
 			return nil, err
 		}
-		n.container = cnt
-		if err := n.container.start(ctx); err != nil {
+	}
+	switch n.config.Execution.Mode {
+	case ExecutionModeConnection:
+		if err := n.launchContainer(ctx); err != nil {
+			return nil, err
+		}
+	case ExecutionModeUser:
+		if err := n.acquireUserContainer(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -79,7 +124,178 @@ func (n *networkHandler) OnHandshakeSuccess(username string) (
 	}, nil
 }
 
-func (n *networkHandler) pullNeeded(ctx context.Context) (bool, error) {
+// launchContainer creates, prepares, and starts the container of the connection.
+func (n *networkHandler) launchContainer(ctx context.Context) error {
+	slot, err := n.admitContainer()
+	if err != nil {
+		return err
+	}
+	n.containerSlot = slot
+	cnt, err := n.dockerClient.createContainer(ctx, n.labels, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	n.container = cnt
+	if err := n.seedFiles(ctx, cnt); err != nil {
+		return err
+	}
+	if err := n.container.start(ctx); err != nil {
+		return err
+	}
+	if err := n.startSidecars(ctx); err != nil {
+		return err
+	}
+	if err := n.waitForReadiness(); err != nil {
+		return err
+	}
+	if err := n.createUser(); err != nil {
+		return err
+	}
+	return n.runSetupCommands()
+}
+
+// templateData returns the data for templated configuration options of this connection.
+func (n *networkHandler) templateData() templateData {
+	return templateData{
+		Username:     n.username,
+		ConnectionID: n.connectionID,
+		Timestamp:    time.Now().UTC().Format("20060102150405"),
+	}
+}
+
+func (n *networkHandler) seedFiles(ctx context.Context, cnt dockerContainer) error {
+	return seedFiles(ctx, cnt, n.config.Execution.Files, n.templateData(), n.logger)
+}
+
+// setupResourceProfile applies the resource profile selected for the user to the containers of the connection.
+func (n *networkHandler) setupResourceProfile() {
+	execution := n.config.Execution
+	for _, rule := range execution.ResourceProfileRules {
+		if rule.UserMatch.matches(n.username, execution.Groups) {
+			n.logger.Debug(
+				log.NewMessage(MResourceProfile, "Using resource profile %s.", rule.Profile).
+					Label("profile", rule.Profile),
+			)
+			n.dockerClient.useResources(execution.ResourceProfiles[rule.Profile])
+			return
+		}
+	}
+}
+
+// setupHomeVolume creates the home volume of the user and mounts it into all containers of the connection.
+func (n *networkHandler) setupHomeVolume(ctx context.Context) error {
+	homeVolume := n.config.Execution.HomeVolume
+	if !homeVolume.Enable {
+		return nil
+	}
+	name, target, err := renderHomeVolume(homeVolume, n.templateData())
+	if err != nil {
+		return log.WrapUser(err, EFailedVolumeCreate, UserMessageInitializeSSHSession, "failed to render the home volume")
+	}
+	driverOptions := map[string]string{}
+	for key, value := range homeVolume.DriverOptions {
+		driverOptions[key] = value
+	}
+	if homeVolume.Size != "" {
+		driverOptions["size"] = homeVolume.Size
+	}
+	labels := map[string]string{}
+	for key, value := range homeVolume.Labels {
+		labels[key] = value
+	}
+	labels["containerssh_home_username"] = n.username
+	if err := n.dockerClient.createVolume(ctx, name, homeVolume.Driver, driverOptions, labels); err != nil {
+		return err
+	}
+	n.dockerClient.addMount(
+		mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: name,
+			Target: target,
+		},
+	)
+	return nil
+}
+
+// homeVolumeUsernamePattern matches the usernames that can be used in volume names and paths unchanged.
+var homeVolumeUsernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// homeVolumeUsername returns the username as it is rendered into the home volume name and target. Usernames that
+// are not safe in volume names or paths are replaced by a hash. The hash starts with an underscore so it cannot
+// collide with a username that is used unchanged.
+func homeVolumeUsername(username string) string {
+	if homeVolumeUsernamePattern.MatchString(username) {
+		return username
+	}
+	hash := sha256.Sum256([]byte(username))
+	return "_" + hex.EncodeToString(hash[:])[:24]
+}
+
+// renderHomeVolume renders the name and the mount target of the home volume with the sanitized username.
+func renderHomeVolume(homeVolume HomeVolumeConfig, data templateData) (name string, target string, err error) {
+	data.Username = homeVolumeUsername(data.Username)
+	name, err = renderTemplate("name", homeVolume.Name, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render the home volume name (%w)", err)
+	}
+	target, err = renderTemplate("target", homeVolume.Target, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render the home volume target (%w)", err)
+	}
+	target = path.Clean(target)
+	if !path.IsAbs(target) || target == "/" {
+		return "", "", fmt.Errorf("the home volume target %q is not an absolute path below the root directory", target)
+	}
+	return name, target, nil
+}
+
+// commitLabel is the image label identifying the user a committed image belongs to.
+const commitLabel = "containerssh_commit_username"
+
+// reuseCommittedImage switches the Docker client to the last image committed for the user if configured. Returns
+// true if a committed image is used.
+func (n *networkHandler) reuseCommittedImage(ctx context.Context) (bool, error) {
+	commit := n.config.Execution.Commit
+	if !commit.Enable || !commit.Reuse {
+		return false, nil
+	}
+	image, err := n.dockerClient.findImage(ctx, map[string]string{commitLabel: n.username})
+	if err != nil || image == "" {
+		return false, err
+	}
+	n.logger.Debug(log.NewMessage(MImageReuse, "Using last committed image %s for user %s.", image, n.username).
+		Label("image", image))
+	n.dockerClient.useImage(image)
+	return true, nil
+}
+
+// commitContainer commits the container to an image before it is removed and removes the images of the user
+// exceeding the configured number.
+func (n *networkHandler) commitContainer() {
+	commit := n.config.Execution.Commit
+	if !commit.Enable {
+		return
+	}
+	reference, err := renderImageReference(commit.Reference, n.templateData())
+	if err != nil {
+		n.logger.Error(log.Wrap(err, EFailedContainerCommit, "failed to render the image reference"))
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.ContainerStop)
+	defer cancelFunc()
+	labels := map[string]string{commitLabel: n.username}
+	if _, err := n.container.commit(ctx, reference, labels, commit.Pause); err != nil {
+		n.logger.Error(err)
+		return
+	}
+	if commit.Keep > 0 {
+		if err := n.dockerClient.pruneImages(ctx, labels, commit.Keep); err != nil {
+			n.logger.Warning(err)
+		}
+	}
+}
+
+func (n *networkHandler) pullNeeded(ctx context.Context, dockerClient dockerClient) (bool, error) {
 	n.logger.Debug(log.NewMessage(MImagePullNeeded, "Checking if an image pull is needed..."))
 	switch n.config.Execution.ImagePullPolicy {
 	case ImagePullPolicyNever:
@@ -90,13 +306,13 @@ func (n *networkHandler) pullNeeded(ctx context.Context) (bool, error) {
 		return true, nil
 	}
 
-	image := n.dockerClient.getImageName()
+	image := dockerClient.getImageName()
 	if !strings.Contains(image, ":") || strings.HasSuffix(image, ":latest") {
 		n.logger.Debug(log.NewMessage(MImagePullNeeded, "Image pull policy is \"IfNotPresent\" and the image name is \"latest\", pulling image."))
 		return true, nil
 	}
 
-	hasImage, err := n.dockerClient.hasImage(ctx)
+	hasImage, err := dockerClient.hasImage(ctx)
 	if err != nil {
 		n.logger.Debug(log.NewMessage(MImagePullNeeded, "Failed to determine if image is present locally, pulling image."))
 		return true, err
@@ -110,18 +326,18 @@ func (n *networkHandler) pullNeeded(ctx context.Context) (bool, error) {
 	return !hasImage, nil
 }
 
-func (n *networkHandler) pullImage(ctx context.Context) (err error) {
-	pullNeeded, err := n.pullNeeded(ctx)
+func (n *networkHandler) pullImage(ctx context.Context, dockerClient dockerClient) (err error) {
+	pullNeeded, err := n.pullNeeded(ctx, dockerClient)
 	if err != nil || !pullNeeded {
 		return err
 	}
 
-	return n.dockerClient.pullImage(ctx)
+	return dockerClient.pullImage(ctx)
 }
 
 func (n *networkHandler) setupDockerClient(ctx context.Context, config Config) error {
 	if n.dockerClient == nil {
-		dockerClient, err := n.dockerClientFactory.get(ctx, config, n.logger)
+		dockerClient, err := n.dockerClientFactory.get(ctx, config, n.logger, n.audit)
 		if err != nil {
 			return fmt.Errorf("failed to create Docker client (%w)", err)
 		}
@@ -137,12 +353,43 @@ func (n *networkHandler) OnDisconnect() {
 		return
 	}
 	n.disconnected = true
-	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.ContainerStop)
-	defer cancelFunc()
+	n.unregisterConnection()
+	if n.userContainer != nil {
+		n.releaseUserContainer()
+	} else {
+		n.cleanup()
+	}
+	close(n.done)
+}
+
+// cleanup removes the containers and network of the connection.
+func (n *networkHandler) cleanup() {
+	if n.handingOver() {
+		n.logger.Info(log.NewMessage(MContainerHandover, "Leaving the container running for adoption after the restart."))
+		return
+	}
 	if n.container != nil {
+		n.exportFiles()
+		n.commitContainer()
+		ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.ContainerStop)
+		defer cancelFunc()
 		_ = n.container.remove(ctx)
 	}
-	close(n.done)
+	n.removeAuxiliaryContainers()
+	n.removeNetwork()
+	n.containerSlot.release()
+}
+
+// exportFiles exports the configured paths from the container before it is removed.
+func (n *networkHandler) exportFiles() {
+	if len(n.config.Export.Paths) == 0 {
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.ContainerStop)
+	defer cancelFunc()
+	if err := exportFiles(ctx, n.container, n.config.Export, n.templateData(), n.logger); err != nil {
+		n.logger.Error(err)
+	}
 }
 
 func (n *networkHandler) OnShutdown(shutdownContext context.Context) {
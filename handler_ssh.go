@@ -2,6 +2,7 @@ package docker
 
 import (
 	"github.com/containerssh/sshserver"
+	"golang.org/x/crypto/ssh"
 )
 
 type sshConnectionHandler struct {
@@ -23,12 +24,24 @@ func (s *sshConnectionHandler) OnSessionChannel(
 	channel sshserver.SessionChannelHandler,
 	failureReason sshserver.ChannelRejection,
 ) {
-	return &channelHandler{
+	if isDraining() {
+		return nil, sshserver.NewChannelRejection(
+			ssh.Prohibited,
+			EDraining,
+			s.networkHandler.config.Drain.Message,
+			"rejecting session channel, the backend is draining",
+		)
+	}
+	handler := &channelHandler{
 		channelID:      channelID,
 		networkHandler: s.networkHandler,
 		username:       s.username,
 		exitSent:       false,
 		env:            map[string]string{},
 		session:        session,
-	}, nil
+	}
+	s.networkHandler.mutex.Lock()
+	s.networkHandler.channels[channelID] = handler
+	s.networkHandler.mutex.Unlock()
+	return handler, nil
 }
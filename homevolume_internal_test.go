@@ -0,0 +1,47 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRenderHomeVolume tests if unsafe usernames are replaced before they are rendered into the home volume name
+// and target.
+func TestRenderHomeVolume(t *testing.T) {
+	t.Parallel()
+
+	config := HomeVolumeConfig{Name: "containerssh-home-{{ .Username }}", Target: "/home/{{ .Username }}"}
+
+	name, target, err := renderHomeVolume(config, templateData{Username: "alice.smith"})
+	assert.NoError(t, err)
+	assert.Equal(t, "containerssh-home-alice.smith", name)
+	assert.Equal(t, "/home/alice.smith", target)
+
+	for _, username := range []string{"..", "../../etc", "alice/../bob", "alice bob", "-alice", strings.Repeat("a", 65)} {
+		name, target, err := renderHomeVolume(config, templateData{Username: username})
+		assert.NoError(t, err)
+		hashed := homeVolumeUsername(username)
+		assert.Regexp(t, "^_[0-9a-f]{24}$", hashed)
+		assert.Equal(t, "containerssh-home-"+hashed, name)
+		assert.Equal(t, "/home/"+hashed, target)
+	}
+	assert.NotEqual(t, homeVolumeUsername("alice bob"), homeVolumeUsername("alice/bob"))
+}
+
+// TestRenderHomeVolumeTarget tests if the target is cleaned and must be an absolute path below the root directory.
+func TestRenderHomeVolumeTarget(t *testing.T) {
+	t.Parallel()
+
+	data := templateData{Username: "alice", ConnectionID: "0123"}
+
+	_, target, err := renderHomeVolume(HomeVolumeConfig{Target: "/home//{{ .Username }}/./data/"}, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/alice/data", target)
+
+	for _, tpl := range []string{"/home/..", "/{{ .Username }}/../", "{{ .Username }}", "{{ .Password }}"} {
+		_, _, err := renderHomeVolume(HomeVolumeConfig{Target: tpl}, data)
+		assert.Error(t, err, tpl)
+	}
+}
@@ -2,6 +2,8 @@ package docker
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/docker/distribution/reference"
@@ -22,3 +24,61 @@ func getCanonicalImageName(image string) (string, error) {
 	}
 	return image, nil
 }
+
+var (
+	imageNameInvalid    = regexp.MustCompile(`[^a-z0-9._-]+`)
+	imageNameSeparators = regexp.MustCompile(`[._-]{2,}`)
+	imageTagInvalid     = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// maxImageTagLength is the maximum length of an image tag accepted by Docker.
+const maxImageTagLength = 128
+
+// renderImageReference renders the repository:tag template and replaces the characters Docker does not accept in
+// image references, since the template data contains user-controlled values.
+func renderImageReference(text string, data templateData) (string, error) {
+	rendered, err := renderTemplate("reference", text, data)
+	if err != nil {
+		return "", err
+	}
+	return sanitizeImageReference(rendered)
+}
+
+// sanitizeImageReference lowercases the repository and replaces invalid characters in the repository path and the
+// tag. Returns an error if the result is still not a valid reference.
+func sanitizeImageReference(ref string) (string, error) {
+	name := ref
+	tag := ""
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name = ref[:i]
+		tag = ref[i+1:]
+	}
+	components := strings.Split(strings.ToLower(name), "/")
+	for i, component := range components {
+		// The registry host may contain a port and is kept apart from lowercasing.
+		if i == 0 && len(components) > 1 &&
+			(strings.ContainsAny(component, ".:") || component == "localhost") {
+			continue
+		}
+		component = imageNameInvalid.ReplaceAllString(component, "-")
+		component = imageNameSeparators.ReplaceAllStringFunc(component, func(separators string) string {
+			if separators == "__" || strings.Trim(separators, "-") == "" {
+				return separators
+			}
+			return "-"
+		})
+		components[i] = strings.Trim(component, "._-")
+	}
+	result := strings.Join(components, "/")
+	if tag != "" {
+		tag = strings.TrimLeft(imageTagInvalid.ReplaceAllString(tag, "-"), ".-")
+		if len(tag) > maxImageTagLength {
+			tag = tag[:maxImageTagLength]
+		}
+		result += ":" + tag
+	}
+	if _, err := reference.ParseNormalizedNamed(result); err != nil {
+		return "", fmt.Errorf("invalid image reference %s (%w)", result, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,53 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSanitizeImageReference tests if user-controlled values are turned into valid image references.
+func TestSanitizeImageReference(t *testing.T) {
+	t.Parallel()
+
+	for ref, expected := range map[string]string{
+		"containerssh-commit/alice:20210102030405":     "containerssh-commit/alice:20210102030405",
+		"containerssh-commit/Alice:20210102030405":     "containerssh-commit/alice:20210102030405",
+		"containerssh-commit/alice@corp.com:1":         "containerssh-commit/alice-corp.com:1",
+		"containerssh-commit/-.alice_.-bob..:1":        "containerssh-commit/alice-bob:1",
+		"containerssh-commit/a__b--c:1":                "containerssh-commit/a__b--c:1",
+		"Registry.Example.com:5000/commit/Alice:Tag@1": "registry.example.com:5000/commit/alice:Tag-1",
+		"localhost/commit/alice":                       "localhost/commit/alice",
+		"containerssh-commit/":                         "",
+	} {
+		result, err := sanitizeImageReference(ref)
+		if expected == "" {
+			assert.Error(t, err, ref)
+			continue
+		}
+		assert.NoError(t, err, ref)
+		assert.Equal(t, expected, result, ref)
+	}
+
+	result, err := sanitizeImageReference("commit/alice:" + strings.Repeat("a", 200))
+	assert.NoError(t, err)
+	assert.Equal(t, "commit/alice:"+strings.Repeat("a", 128), result)
+
+	_, err = sanitizeImageReference("commit/@@@:1")
+	assert.Error(t, err)
+}
+
+// TestRenderImageReference tests if the commit reference is rendered and sanitized.
+func TestRenderImageReference(t *testing.T) {
+	t.Parallel()
+
+	data := templateData{Username: "Alice Smith", Timestamp: "20210102030405"}
+	result, err := renderImageReference("containerssh-commit/{{ .Username }}:{{ .Timestamp }}", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "containerssh-commit/alice-smith:20210102030405", result)
+
+	assert.Error(t, CommitConfig{Enable: true, Reference: "commit/{{ .Username }}:{{ .Timestamp }}", Keep: -1}.Validate())
+	assert.Error(t, CommitConfig{Enable: true, Reference: "commit/{{ .Password }}"}.Validate())
+	assert.NoError(t, CommitConfig{Enable: true, Reference: "commit/{{ .Username }}:{{ .Timestamp }}", Keep: 3}.Validate())
+}
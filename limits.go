@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"sync"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/metrics"
+)
+
+// containerCounts is the process-wide registry of the number of concurrent containers per user, client IP and Docker
+// host. Containers adopted by Recover are counted as well, so the counts survive a restart.
+var containerCounts = struct {
+	lock   *sync.Mutex
+	counts map[string]int
+}{
+	lock:   &sync.Mutex{},
+	counts: map[string]int{},
+}
+
+// containerLimit is a single limit checked when a container is launched.
+type containerLimit struct {
+	// name identifies the kind of limit in logs and metrics.
+	name string
+	// key is the registry key of the counted value.
+	key string
+	// max is the maximum number of containers, 0 for unlimited.
+	max int
+}
+
+// containerSlot is a counted container. It must be released when the container is removed.
+type containerSlot struct {
+	keys     []string
+	released bool
+}
+
+func (n *networkHandler) containerLimits() []containerLimit {
+	limits := n.config.Limits
+	return []containerLimit{
+		{name: "user", key: "user\x00" + n.username, max: limits.MaxContainersPerUser},
+		{name: "ip", key: "ip\x00" + n.client.IP.String(), max: limits.MaxContainersPerIP},
+		{name: "host", key: "host\x00" + n.config.Connection.Host, max: limits.MaxContainersPerHost},
+	}
+}
+
+// admitContainer counts a new container of the connection, or returns an error if a concurrency or rate limit has
+// been reached. Rate limit tokens are only taken for containers within the concurrency limits.
+func (n *networkHandler) admitContainer() (*containerSlot, error) {
+	slot, err := n.acquireContainerSlot()
+	if err != nil {
+		return nil, err
+	}
+	if err := n.checkRateLimit(); err != nil {
+		slot.release()
+		return nil, err
+	}
+	return slot, nil
+}
+
+// acquireContainerSlot counts a new container of the connection or returns an error if a limit has been reached.
+func (n *networkHandler) acquireContainerSlot() (*containerSlot, error) {
+	limits := n.containerLimits()
+	containerCounts.lock.Lock()
+	defer containerCounts.lock.Unlock()
+	for _, limit := range limits {
+		if limit.max > 0 && containerCounts.counts[limit.key] >= limit.max {
+			if n.config.Limits.RejectionsMetric != nil {
+				n.config.Limits.RejectionsMetric.Increment(metrics.Label("limit", limit.name))
+			}
+			err := log.UserMessage(
+				ELimitReached,
+				n.config.Limits.Message,
+				"the maximum number of %d concurrent containers per %s has been reached",
+				limit.max,
+				limit.name,
+			).Label("limit", limit.name)
+			n.logger.Notice(err)
+			return nil, err
+		}
+	}
+	return countContainer(limits), nil
+}
+
+// countContainer counts a container without checking the limits. The caller must hold the registry lock.
+func countContainer(limits []containerLimit) *containerSlot {
+	slot := &containerSlot{}
+	for _, limit := range limits {
+		containerCounts.counts[limit.key]++
+		slot.keys = append(slot.keys, limit.key)
+	}
+	return slot
+}
+
+// countAdoptedContainer counts the container of the connection without checking the limits, since it is already
+// running.
+func (n *networkHandler) countAdoptedContainer() {
+	containerCounts.lock.Lock()
+	defer containerCounts.lock.Unlock()
+	n.containerSlot = countContainer(n.containerLimits())
+}
+
+// release stops counting the container. Releasing a nil or already released slot has no effect.
+func (s *containerSlot) release() {
+	if s == nil {
+		return
+	}
+	containerCounts.lock.Lock()
+	defer containerCounts.lock.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	for _, key := range s.keys {
+		containerCounts.counts[key]--
+		if containerCounts.counts[key] <= 0 {
+			delete(containerCounts.counts, key)
+		}
+	}
+}
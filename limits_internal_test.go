@@ -0,0 +1,84 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLimitsTestHandler(t *testing.T, username string, ip string, host string, limits LimitsConfig) *networkHandler {
+	config := Config{}
+	config.Limits = limits
+	config.Connection.Host = host
+	return newTestHandler(t, username, ip, config)
+}
+
+// TestContainerSlotsPerUser tests if a user cannot exceed the limit until a container is released.
+func TestContainerSlotsPerUser(t *testing.T) {
+	t.Parallel()
+
+	// The values are unique to this test since the counts are shared by the whole process.
+	limits := LimitsConfig{MaxContainersPerUser: 2}
+	first, err := newLimitsTestHandler(t, "slots-user", "192.0.2.1", "slots-user-host", limits).acquireContainerSlot()
+	assert.NoError(t, err)
+	second, err := newLimitsTestHandler(t, "slots-user", "192.0.2.2", "slots-user-host", limits).acquireContainerSlot()
+	assert.NoError(t, err)
+
+	_, err = newLimitsTestHandler(t, "slots-user", "192.0.2.3", "slots-user-host", limits).acquireContainerSlot()
+	assert.Error(t, err)
+	var typedErr log.Message
+	if assert.ErrorAs(t, err, &typedErr) {
+		assert.Equal(t, ELimitReached, typedErr.Code())
+	}
+	// Other users are not affected.
+	other, err := newLimitsTestHandler(t, "slots-other", "192.0.2.3", "slots-user-host", limits).acquireContainerSlot()
+	assert.NoError(t, err)
+
+	first.release()
+	// Releasing twice must not free a second slot.
+	first.release()
+	third, err := newLimitsTestHandler(t, "slots-user", "192.0.2.3", "slots-user-host", limits).acquireContainerSlot()
+	assert.NoError(t, err)
+	_, err = newLimitsTestHandler(t, "slots-user", "192.0.2.4", "slots-user-host", limits).acquireContainerSlot()
+	assert.Error(t, err)
+
+	second.release()
+	third.release()
+	other.release()
+	var nilSlot *containerSlot
+	nilSlot.release()
+}
+
+// TestContainerSlotsPerIPAndHost tests if the IP and host limits count the containers of all users.
+func TestContainerSlotsPerIPAndHost(t *testing.T) {
+	t.Parallel()
+
+	ipLimits := LimitsConfig{MaxContainersPerIP: 1}
+	slot, err := newLimitsTestHandler(t, "slots-a", "198.51.100.1", "slots-ip-host", ipLimits).acquireContainerSlot()
+	assert.NoError(t, err)
+	_, err = newLimitsTestHandler(t, "slots-b", "198.51.100.1", "slots-ip-host", ipLimits).acquireContainerSlot()
+	assert.Error(t, err)
+	slot.release()
+
+	hostLimits := LimitsConfig{MaxContainersPerHost: 2}
+	var slots []*containerSlot
+	for _, ip := range []string{"198.51.100.2", "198.51.100.3"} {
+		slot, err := newLimitsTestHandler(t, "slots-"+ip, ip, "slots-host", hostLimits).acquireContainerSlot()
+		assert.NoError(t, err)
+		slots = append(slots, slot)
+	}
+	_, err = newLimitsTestHandler(t, "slots-c", "198.51.100.4", "slots-host", hostLimits).acquireContainerSlot()
+	assert.Error(t, err)
+	// Adopted containers are counted without checking the limit.
+	adopted := newLimitsTestHandler(t, "slots-d", "198.51.100.5", "slots-host", hostLimits)
+	adopted.countAdoptedContainer()
+	for _, slot := range slots {
+		slot.release()
+	}
+	_, err = newLimitsTestHandler(t, "slots-e", "198.51.100.6", "slots-host", hostLimits).acquireContainerSlot()
+	assert.NoError(t, err)
+	_, err = newLimitsTestHandler(t, "slots-f", "198.51.100.7", "slots-host", hostLimits).acquireContainerSlot()
+	assert.Error(t, err)
+	adopted.containerSlot.release()
+}
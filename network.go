@@ -0,0 +1,393 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// maxSubnetAttempts is the number of free subnets tried when creating a network. Creating a network can fail if
+// another connection allocated the same subnet concurrently.
+const maxSubnetAttempts = 3
+
+// setupNetwork creates the isolated network of the connection or user, attaches all containers of the connection to
+// it, and starts the egress proxy.
+func (n *networkHandler) setupNetwork(ctx context.Context) error {
+	config := n.config.Execution.IsolatedNetwork
+	if !config.Enable {
+		return nil
+	}
+	if config.Scope == NetworkIsolationScopeUser {
+		return n.acquireUserNetwork(ctx)
+	}
+	name := "containerssh-" + n.connectionID
+	labels := map[string]string{}
+	for key, value := range n.labels {
+		labels[key] = value
+	}
+	if err := n.createNetwork(ctx, name, n.networkOptions(labels), config.subnets()); err != nil {
+		return err
+	}
+	n.network = name
+	n.dockerClient.useNetwork(name)
+	if config.EgressProxy != nil {
+		cnt, err := n.startEgressProxy(ctx, name, config)
+		if cnt != nil {
+			n.auxiliaryContainers = append(n.auxiliaryContainers, cnt)
+		}
+		return err
+	}
+	return nil
+}
+
+func (n *networkHandler) networkOptions(labels map[string]string) types.NetworkCreate {
+	config := n.config.Execution.IsolatedNetwork
+	return types.NetworkCreate{
+		Driver:   config.Driver,
+		Options:  config.DriverOptions,
+		Internal: config.Internal,
+		Labels:   labels,
+	}
+}
+
+// userNetworkName returns the name of the isolated network shared by the connections of the user. The username is
+// hashed since usernames may contain characters Docker does not accept, and replacing them would let users share a
+// network.
+func userNetworkName(username string) string {
+	hash := sha256.Sum256([]byte(username))
+	return "containerssh-user-" + hex.EncodeToString(hash[:])[:24]
+}
+
+// userNetworks is the process-wide registry of the isolated networks shared by the connections of a user in the
+// "user" scope, keyed by network name.
+var userNetworks = struct {
+	lock    *sync.Mutex
+	entries map[string]*userNetwork
+}{
+	lock:    &sync.Mutex{},
+	entries: map[string]*userNetwork{},
+}
+
+// userNetwork is an isolated network shared by the connections of a user. All fields are protected by the registry
+// lock.
+type userNetwork struct {
+	// ready is closed when the first connection has finished creating the network and the egress proxy.
+	ready chan struct{}
+	// err is the error that happened while creating the network.
+	err error
+	// egressProxy is the egress proxy shared by the connections on the network, if any.
+	egressProxy dockerContainer
+	// refs is the number of connections using the network.
+	refs int
+	// removed is closed when the network has been removed after the last connection released it.
+	removed chan struct{}
+}
+
+// acquireUserNetwork joins the isolated network of the user or creates it with the egress proxy if the user has
+// none.
+func (n *networkHandler) acquireUserNetwork(ctx context.Context) error {
+	name := userNetworkName(n.username)
+	var entry *userNetwork
+	existing := false
+	for entry == nil {
+		userNetworks.lock.Lock()
+		found, ok := userNetworks.entries[name]
+		if ok && found.removed != nil {
+			// The network is being removed and must not be joined.
+			removed := found.removed
+			userNetworks.lock.Unlock()
+			select {
+			case <-removed:
+				continue
+			case <-ctx.Done():
+				return n.userNetworkTimeout(ctx, name)
+			}
+		}
+		if !ok {
+			found = &userNetwork{ready: make(chan struct{})}
+			userNetworks.entries[name] = found
+		}
+		found.refs++
+		userNetworks.lock.Unlock()
+		entry = found
+		existing = ok
+	}
+	n.userNetwork = entry
+	n.network = name
+
+	if existing {
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return n.userNetworkTimeout(ctx, name)
+		}
+		userNetworks.lock.Lock()
+		err := entry.err
+		userNetworks.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		n.dockerClient.useNetwork(name)
+		return nil
+	}
+
+	config := n.config.Execution.IsolatedNetwork
+	options := n.networkOptions(map[string]string{"containerssh_username": n.username})
+	err := n.createNetwork(ctx, name, options, config.subnets())
+	var egressProxy dockerContainer
+	if err == nil && config.EgressProxy != nil {
+		egressProxy, err = n.startEgressProxy(ctx, name, config)
+	}
+	userNetworks.lock.Lock()
+	entry.egressProxy = egressProxy
+	entry.err = err
+	close(entry.ready)
+	userNetworks.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	n.dockerClient.useNetwork(name)
+	return nil
+}
+
+func (n *networkHandler) userNetworkTimeout(ctx context.Context, name string) error {
+	return log.WrapUser(
+		ctx.Err(),
+		EFailedNetworkCreate,
+		UserMessageInitializeSSHSession,
+		"timeout while waiting for network %s",
+		name,
+	).Label("network", name)
+}
+
+// releaseUserNetwork gives up the use of the isolated network of the user. The egress proxy and the network are
+// removed when the last connection releases it.
+func (n *networkHandler) releaseUserNetwork() {
+	entry := n.userNetwork
+	n.userNetwork = nil
+	userNetworks.lock.Lock()
+	entry.refs--
+	if entry.refs > 0 {
+		userNetworks.lock.Unlock()
+		return
+	}
+	entry.removed = make(chan struct{})
+	egressProxy := entry.egressProxy
+	userNetworks.lock.Unlock()
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.ContainerStop)
+	defer cancelFunc()
+	if egressProxy != nil {
+		_ = egressProxy.remove(ctx)
+	}
+	// Removing the network fails while containers of other ContainerSSH instances are still attached.
+	if err := n.dockerClient.removeNetwork(ctx, n.network); err != nil {
+		n.logger.Debug(err)
+	}
+
+	userNetworks.lock.Lock()
+	if userNetworks.entries[n.network] == entry {
+		delete(userNetworks.entries, n.network)
+	}
+	close(entry.removed)
+	userNetworks.lock.Unlock()
+}
+
+func (n *networkHandler) createNetwork(
+	ctx context.Context,
+	name string,
+	options types.NetworkCreate,
+	subnets []string,
+) error {
+	if len(subnets) == 0 {
+		_, err := n.dockerClient.createNetwork(ctx, name, options)
+		return err
+	}
+	usedSubnets, err := n.dockerClient.usedSubnets(ctx)
+	if err != nil {
+		return err
+	}
+	attempts := 0
+	for _, subnet := range subnets {
+		if subnetOverlaps(subnet, usedSubnets) {
+			continue
+		}
+		options.IPAM = &network.IPAM{
+			Config: []network.IPAMConfig{{Subnet: subnet}},
+		}
+		if _, err = n.dockerClient.createNetwork(ctx, name, options); err == nil {
+			return nil
+		}
+		attempts++
+		if attempts >= maxSubnetAttempts {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return log.UserMessage(
+		EFailedNetworkCreate,
+		UserMessageInitializeSSHSession,
+		"no free subnet left in the subnet pool for network %s",
+		name,
+	).Label("network", name)
+}
+
+// startEgressProxy launches the egress proxy on the network. The caller is responsible for removing the returned
+// container, which may be returned even if an error happened.
+func (n *networkHandler) startEgressProxy(
+	ctx context.Context,
+	networkName string,
+	config IsolatedNetworkConfig,
+) (dockerContainer, error) {
+	launch := *config.EgressProxy
+	hostConfig := container.HostConfig{}
+	if launch.HostConfig != nil {
+		hostConfig = *launch.HostConfig
+	}
+	hostConfig.NetworkMode = container.NetworkMode(networkName)
+	launch.HostConfig = &hostConfig
+	launch.NetworkConfig = &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			networkName: {
+				Aliases: []string{config.EgressProxyAlias},
+			},
+		},
+	}
+	cnt, err := n.newAuxiliaryContainer(ctx, launch, egressProxyRole)
+	if err != nil {
+		return cnt, err
+	}
+	if err := cnt.connectNetwork(ctx, config.EgressNetwork, nil); err != nil {
+		return cnt, err
+	}
+	return cnt, cnt.start(ctx)
+}
+
+// removeNetwork removes the isolated network of the connection, or releases the network shared by the user.
+func (n *networkHandler) removeNetwork() {
+	if n.userNetwork != nil {
+		n.releaseUserNetwork()
+		return
+	}
+	if n.network == "" {
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.ContainerStop)
+	defer cancelFunc()
+	if err := n.dockerClient.removeNetwork(ctx, n.network); err != nil {
+		n.logger.Warning(err)
+	}
+	n.network = ""
+}
+
+// egressProxyRole is the containerssh_role label of the egress proxy.
+const egressProxyRole = "egress-proxy"
+
+// createAuxiliaryContainer creates a container from a separate launch configuration for the connection. The
+// container runs the command of its own configuration and is removed together with the user container.
+func (n *networkHandler) createAuxiliaryContainer(
+	ctx context.Context,
+	launch LaunchConfig,
+	role string,
+) (dockerContainer, error) {
+	cnt, err := n.newAuxiliaryContainer(ctx, launch, role)
+	if cnt != nil {
+		n.auxiliaryContainers = append(n.auxiliaryContainers, cnt)
+	}
+	return cnt, err
+}
+
+// newAuxiliaryContainer creates a container from a separate launch configuration. The caller is responsible for
+// removing the container, which may be returned even if an error happened.
+func (n *networkHandler) newAuxiliaryContainer(
+	ctx context.Context,
+	launch LaunchConfig,
+	role string,
+) (dockerContainer, error) {
+	config := n.config
+	config.Execution.Launch = launch
+	config.Execution.IdleCommand = nil
+	if launch.ContainerConfig != nil {
+		config.Execution.IdleCommand = launch.ContainerConfig.Cmd
+	}
+	dockerClient, err := n.dockerClientFactory.get(ctx, config, n.logger.WithLabel("role", role), n.audit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client for %s (%w)", role, err)
+	}
+	if err := n.pullImage(ctx, dockerClient); err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	for key, value := range n.labels {
+		labels[key] = value
+	}
+	labels["containerssh_role"] = role
+	return dockerClient.createContainer(ctx, labels, nil, nil, nil)
+}
+
+func (n *networkHandler) removeAuxiliaryContainers() {
+	if len(n.auxiliaryContainers) == 0 {
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.ContainerStop)
+	defer cancelFunc()
+	for _, cnt := range n.auxiliaryContainers {
+		_ = cnt.remove(ctx)
+	}
+	n.auxiliaryContainers = nil
+}
+
+// subnetOverlaps checks if the subnet overlaps with any of the used subnets.
+func subnetOverlaps(subnet string, usedSubnets []string) bool {
+	_, subnetNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return true
+	}
+	for _, used := range usedSubnets {
+		_, usedNet, err := net.ParseCIDR(used)
+		if err != nil {
+			continue
+		}
+		if usedNet.Contains(subnetNet.IP) || subnetNet.Contains(usedNet.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// startSidecars launches the configured sidecars next to the running user container.
+func (n *networkHandler) startSidecars(ctx context.Context) error {
+	for i, sidecar := range n.config.Execution.Sidecars {
+		launch := sidecar
+		hostConfig := container.HostConfig{}
+		if launch.HostConfig != nil {
+			hostConfig = *launch.HostConfig
+		}
+		switch n.config.Execution.SidecarNetwork {
+		case SidecarNetworkModeIsolated:
+			hostConfig.NetworkMode = container.NetworkMode(n.network)
+		default:
+			hostConfig.NetworkMode = container.NetworkMode("container:" + n.container.getID())
+		}
+		launch.HostConfig = &hostConfig
+		launch.NetworkConfig = nil
+		cnt, err := n.createAuxiliaryContainer(ctx, launch, fmt.Sprintf("sidecar-%d", i))
+		if err != nil {
+			return err
+		}
+		if err := cnt.start(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package docker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSubnetOverlaps tests if subnets containing or contained in used subnets are detected.
+func TestSubnetOverlaps(t *testing.T) {
+	t.Parallel()
+
+	used := []string{"10.0.0.0/24", "172.17.0.0/16", "invalid"}
+	assert.True(t, subnetOverlaps("10.0.0.0/24", used))
+	assert.True(t, subnetOverlaps("10.0.0.128/25", used))
+	assert.True(t, subnetOverlaps("10.0.0.0/16", used))
+	assert.True(t, subnetOverlaps("172.17.5.0/24", used))
+	assert.False(t, subnetOverlaps("10.0.1.0/24", used))
+	assert.False(t, subnetOverlaps("192.168.0.0/24", used))
+	// Invalid subnets are never allocated.
+	assert.True(t, subnetOverlaps("10.1.0.0", used))
+}
+
+// TestUserNetworkName tests if users get distinct valid network names regardless of the characters in the username.
+func TestUserNetworkName(t *testing.T) {
+	t.Parallel()
+
+	names := map[string]string{}
+	for _, username := range []string{"alice@corp", "alice_corp", "alice.corp", "Alice", "alice", "ä/../x"} {
+		name := userNetworkName(username)
+		assert.Regexp(t, "^containerssh-user-[0-9a-f]{24}$", name, username)
+		if other, ok := names[name]; ok {
+			t.Errorf("users %s and %s share the network %s", username, other, name)
+		}
+		names[name] = username
+	}
+	assert.Equal(t, userNetworkName("alice"), userNetworkName("alice"))
+}
+
+type fakeNetworkContainer struct {
+	dockerContainer
+
+	client *fakeNetworkClient
+	audit  *auditLogger
+}
+
+func (f *fakeNetworkContainer) forConnection(_ log.Logger, audit *auditLogger) dockerContainer {
+	return &fakeNetworkContainer{client: f.client, audit: audit}
+}
+
+// createExec audits the execution like the Docker implementation, but does not run anything.
+func (f *fakeNetworkContainer) createExec(
+	_ context.Context,
+	program []string,
+	_ map[string]string,
+	_ bool,
+	_ string,
+) (dockerExecution, error) {
+	f.audit.emit(AuditEvent{Type: AuditEventExecCreate, Program: program})
+	return nil, nil
+}
+
+func (f *fakeNetworkContainer) connectNetwork(_ context.Context, _ string, _ []string) error {
+	return nil
+}
+
+func (f *fakeNetworkContainer) start(_ context.Context) error {
+	return nil
+}
+
+func (f *fakeNetworkContainer) remove(_ context.Context) error {
+	f.client.lock.Lock()
+	defer f.client.lock.Unlock()
+	f.client.containers--
+	return nil
+}
+
+// fakeNetworkClient records the networks and containers created through it.
+type fakeNetworkClient struct {
+	dockerClient
+
+	lock       *sync.Mutex
+	networks   map[string]bool
+	containers int
+	created    int
+}
+
+func (f *fakeNetworkClient) get(_ context.Context, _ Config, _ log.Logger, _ *auditLogger) (dockerClient, error) {
+	return f, nil
+}
+
+func (f *fakeNetworkClient) createNetwork(_ context.Context, name string, _ types.NetworkCreate) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.networks[name] {
+		return false, nil
+	}
+	f.networks[name] = true
+	return true, nil
+}
+
+func (f *fakeNetworkClient) removeNetwork(_ context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.networks, name)
+	return nil
+}
+
+func (f *fakeNetworkClient) useNetwork(_ string) {}
+
+func (f *fakeNetworkClient) createContainer(
+	_ context.Context,
+	_ map[string]string,
+	_ map[string]string,
+	_ *bool,
+	_ []string,
+) (dockerContainer, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.containers++
+	f.created++
+	return &fakeNetworkContainer{client: f}, nil
+}
+
+// TestUserNetworkSharesEgressProxy tests if the connections of a user share the network and the egress proxy, and
+// both are removed with the last connection.
+func TestUserNetworkSharesEgressProxy(t *testing.T) {
+	t.Parallel()
+
+	client := &fakeNetworkClient{lock: &sync.Mutex{}, networks: map[string]bool{}}
+	config := Config{}
+	config.Execution.ImagePullPolicy = ImagePullPolicyNever
+	config.Execution.IsolatedNetwork = IsolatedNetworkConfig{
+		Enable:           true,
+		Scope:            NetworkIsolationScopeUser,
+		EgressProxy:      &LaunchConfig{ContainerConfig: &container.Config{Image: "proxy"}},
+		EgressProxyAlias: "egress-proxy",
+		EgressNetwork:    "bridge",
+	}
+	config.Timeouts.ContainerStop = time.Second
+	// The username is unique to this test since the registry is shared by the whole process.
+	username := "network-test-user"
+
+	var handlers []*networkHandler
+	for i := 0; i < 3; i++ {
+		n := &networkHandler{
+			mutex:               &sync.Mutex{},
+			username:            username,
+			connectionID:        string(rune('a' + i)),
+			config:              config,
+			dockerClient:        client,
+			dockerClientFactory: client,
+			logger:              log.NewTestLogger(t),
+			labels:              map[string]string{},
+		}
+		assert.NoError(t, n.setupNetwork(context.Background()))
+		assert.Equal(t, userNetworkName(username), n.network)
+		handlers = append(handlers, n)
+	}
+	assert.Equal(t, 1, client.created)
+	assert.Equal(t, 1, client.containers)
+
+	handlers[0].removeNetwork()
+	handlers[1].removeNetwork()
+	assert.Equal(t, 1, client.containers)
+	assert.True(t, client.networks[userNetworkName(username)])
+
+	handlers[2].removeNetwork()
+	assert.Equal(t, 0, client.containers)
+	assert.False(t, client.networks[userNetworkName(username)])
+
+	// The next connection creates the network and the proxy again.
+	n := &networkHandler{
+		mutex:               &sync.Mutex{},
+		username:            username,
+		connectionID:        "d",
+		config:              config,
+		dockerClient:        client,
+		dockerClientFactory: client,
+		logger:              log.NewTestLogger(t),
+		labels:              map[string]string{},
+	}
+	assert.NoError(t, n.setupNetwork(context.Background()))
+	assert.Equal(t, 2, client.created)
+	n.removeNetwork()
+	assert.Equal(t, 0, client.containers)
+}
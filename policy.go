@@ -0,0 +1,174 @@
+package docker
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/unixutils"
+)
+
+// check evaluates the execution policy for the given request and returns a user-facing error if the program is not
+// permitted. The argv is the parsed command before it is wrapped in a shell. If opaque is true the shell may run a
+// different program than argv, so argv rules fail closed: deny rules match and allow rules do not.
+func (p PolicyConfig) check(
+	requestType PolicyRequestType,
+	username string,
+	groups map[string][]string,
+	command string,
+	argv []string,
+	opaque bool,
+) error {
+	action := p.DefaultAction
+	message := p.Message
+	for _, rule := range p.Rules {
+		if rule.matches(requestType, username, groups, command, argv, opaque) {
+			action = rule.Action
+			if rule.Message != "" {
+				message = rule.Message
+			}
+			break
+		}
+	}
+	if action != PolicyActionDeny {
+		return nil
+	}
+	if message == "" {
+		message = "You are not permitted to run this program."
+	}
+	return log.UserMessage(
+		EProgramPolicyDenied,
+		message,
+		"the %s request was denied by the execution policy",
+		requestType,
+	).Label("requestType", requestType)
+}
+
+func (r PolicyRule) matches(
+	requestType PolicyRequestType,
+	username string,
+	groups map[string][]string,
+	command string,
+	argv []string,
+	opaque bool,
+) bool {
+	if !r.UserMatch.matches(username, groups) {
+		return false
+	}
+	if len(r.Types) > 0 {
+		found := false
+		for _, t := range r.Types {
+			if t == requestType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Command != "" && !matchPolicyExpression(r.Command, command) {
+		return false
+	}
+	if len(r.Argv) == 0 {
+		return true
+	}
+	if opaque {
+		return r.Action == PolicyActionDeny
+	}
+	if len(argv) < len(r.Argv) {
+		return false
+	}
+	for i, expression := range r.Argv {
+		if !matchPolicyExpression(expression, argv[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// compilePolicyExpression compiles a policy expression so that it must match the whole value. The expression is
+// compiled on its own first so unbalanced groups cannot escape the anchors.
+func compilePolicyExpression(expression string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(expression); err != nil {
+		return nil, err
+	}
+	return regexp.Compile("^(?:" + expression + ")$")
+}
+
+// policyExpressions caches the compiled policy expressions so they are not compiled on every request. Invalid
+// expressions are cached as nil.
+var policyExpressions = struct {
+	lock        *sync.Mutex
+	expressions map[string]*regexp.Regexp
+}{
+	lock:        &sync.Mutex{},
+	expressions: map[string]*regexp.Regexp{},
+}
+
+func matchPolicyExpression(expression string, value string) bool {
+	policyExpressions.lock.Lock()
+	re, ok := policyExpressions.expressions[expression]
+	if !ok {
+		// Expressions are checked in Validate, an invalid expression should never match.
+		re, _ = compilePolicyExpression(expression)
+		policyExpressions.expressions[expression] = re
+	}
+	policyExpressions.lock.Unlock()
+	return re != nil && re.MatchString(value)
+}
+
+// shellCommandWords are the reserved words and builtins that make the shell run the following words as a different
+// command.
+var shellCommandWords = map[string]struct{}{
+	"!": {}, "time": {}, "coproc": {}, "exec": {}, "command": {}, "builtin": {}, "eval": {}, ".": {}, "source": {},
+}
+
+// shellAssignment matches a variable assignment preceding a command.
+var shellAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// policyArgv returns the argv of a command that is run through a shell. The result is opaque if the command cannot be
+// parsed or contains shell syntax that can make the shell run a different program than the parsed words. Variable
+// assignments preceding the command make it opaque too, since variables such as PATH or LD_PRELOAD change which code
+// the program runs.
+func policyArgv(command string) ([]string, bool) {
+	argv, err := unixutils.ParseCMD(command)
+	if err != nil {
+		return nil, true
+	}
+	if len(argv) > 0 && shellAssignment.MatchString(argv[0]) {
+		return argv, true
+	}
+	if len(argv) > 0 {
+		if _, ok := shellCommandWords[argv[0]]; ok {
+			return argv, true
+		}
+	}
+	return argv, hasShellSyntax(command)
+}
+
+// hasShellSyntax returns true if the command contains operators, redirections, substitutions, expansions, globs or
+// history expansion outside of single quotes.
+func hasShellSyntax(command string) bool {
+	var escaped, singleQuoted, doubleQuoted bool
+	for _, r := range command {
+		switch {
+		case escaped:
+			escaped = false
+		case singleQuoted:
+			singleQuoted = r != '\''
+		case r == '\\':
+			escaped = true
+		case r == '\'' && !doubleQuoted:
+			singleQuoted = true
+		case r == '"':
+			doubleQuoted = !doubleQuoted
+		case r == '$' || r == '`' || r == '!':
+			return true
+		case !doubleQuoted && strings.ContainsRune(";&|<>()*?[{~#\n", r):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPolicyArgvBeforeShellWrapping tests if argv rules are evaluated on the parsed command instead of the shell the
+// command is wrapped in.
+func TestPolicyArgvBeforeShellWrapping(t *testing.T) {
+	t.Parallel()
+
+	policy := PolicyConfig{
+		DefaultAction: PolicyActionAllow,
+		Rules: []PolicyRule{
+			{Action: PolicyActionDeny, Argv: []string{"(.*/)?rm"}},
+		},
+	}
+	for command, denied := range map[string]bool{
+		"rm -rf /":            true,
+		"/bin/rm -rf /":       true,
+		"r\"\"m -rf /":        true,
+		"FOO=bar rm -rf /":    true,
+		"ls -l":               false,
+		"ls; rm -rf /":        true,
+		"echo $(rm -rf /)":    true,
+		"/bin/r[m] -rf /":     true,
+		"exec rm -rf /":       true,
+		"echo 'rm -rf /; ls'": false,
+		"echo \"unterminated": true,
+	} {
+		argv, opaque := policyArgv(command)
+		err := policy.check(PolicyRequestExec, "foo", nil, command, argv, opaque)
+		if denied {
+			assert.Error(t, err, command)
+		} else {
+			assert.NoError(t, err, command)
+		}
+	}
+}
+
+// TestPolicyOpaqueArgvFailsClosed tests if allow rules with argv expressions do not match commands that the shell may
+// run differently than parsed.
+func TestPolicyOpaqueArgvFailsClosed(t *testing.T) {
+	t.Parallel()
+
+	policy := PolicyConfig{
+		DefaultAction: PolicyActionDeny,
+		Rules: []PolicyRule{
+			{Action: PolicyActionAllow, Argv: []string{"ls"}},
+		},
+	}
+	for command, denied := range map[string]bool{
+		"ls -l":                      false,
+		"ls && rm -rf":               true,
+		"ls `rm -rf /`":              true,
+		"LD_PRELOAD=/tmp/x.so ls -l": true,
+		"PATH=/tmp ls":               true,
+		"LC_ALL=C ls":                true,
+	} {
+		argv, opaque := policyArgv(command)
+		err := policy.check(PolicyRequestExec, "foo", nil, command, argv, opaque)
+		if denied {
+			assert.Error(t, err, command)
+		} else {
+			assert.NoError(t, err, command)
+		}
+	}
+}
+
+// TestPolicyExpressionsMatchWholeValue tests if command and argv expressions are anchored.
+func TestPolicyExpressionsMatchWholeValue(t *testing.T) {
+	t.Parallel()
+
+	commandRule := PolicyRule{Action: PolicyActionDeny, Command: "curl"}
+	assert.True(t, commandRule.matches(PolicyRequestExec, "foo", nil, "curl", nil, false))
+	assert.False(t, commandRule.matches(PolicyRequestExec, "foo", nil, "mycurl-safe", nil, false))
+	assert.False(t, commandRule.matches(PolicyRequestExec, "foo", nil, "curl example.com", nil, false))
+
+	argvRule := PolicyRule{Action: PolicyActionDeny, Argv: []string{"curl"}}
+	assert.True(t, argvRule.matches(PolicyRequestExec, "foo", nil, "", []string{"curl", "example.com"}, false))
+	assert.False(t, argvRule.matches(PolicyRequestExec, "foo", nil, "", []string{"mycurl-safe"}, false))
+	assert.False(t, argvRule.matches(PolicyRequestExec, "foo", nil, "", nil, false))
+
+	// An alternation must not escape the anchors.
+	alternationRule := PolicyRule{Action: PolicyActionDeny, Argv: []string{"curl|wget"}}
+	assert.True(t, alternationRule.matches(PolicyRequestExec, "foo", nil, "", []string{"wget"}, false))
+	assert.False(t, alternationRule.matches(PolicyRequestExec, "foo", nil, "", []string{"curl-safe"}, false))
+}
+
+// TestPolicyRuleFilters tests if rules are restricted to the configured users and request types.
+func TestPolicyRuleFilters(t *testing.T) {
+	t.Parallel()
+
+	groups := map[string][]string{"admins": {"alice"}}
+	rule := PolicyRule{
+		UserMatch: UserMatch{Groups: []string{"admins"}},
+		Action:    PolicyActionAllow,
+		Types:     []PolicyRequestType{PolicyRequestShell},
+	}
+	assert.True(t, rule.matches(PolicyRequestShell, "alice", groups, "", []string{"/bin/bash"}, false))
+	assert.False(t, rule.matches(PolicyRequestShell, "bob", groups, "", []string{"/bin/bash"}, false))
+	assert.False(t, rule.matches(PolicyRequestExec, "alice", groups, "ls", []string{"ls"}, false))
+}
+
+// TestPolicyRuleValidate tests if invalid expressions are rejected.
+func TestPolicyRuleValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, PolicyRule{Action: PolicyActionDeny, Argv: []string{"rm"}}.Validate())
+	assert.Error(t, PolicyRule{Action: PolicyActionDeny, Argv: []string{"("}}.Validate())
+	assert.Error(t, PolicyRule{Action: PolicyActionDeny, Command: "a)|(b"}.Validate())
+	assert.Error(t, PolicyRule{Action: "maybe"}.Validate())
+}
+
+// TestForceCommandFirstMatchingRule tests if the first forced command rule matching the user replaces the request.
+func TestForceCommandFirstMatchingRule(t *testing.T) {
+	t.Parallel()
+
+	config := Config{}
+	config.Execution.Groups = map[string][]string{"sftp": {"bob"}}
+	config.Execution.ForceCommand = []ForceCommandRule{
+		{UserMatch: UserMatch{Usernames: []string{"alice"}}, Command: "/usr/bin/audit-shell"},
+		{UserMatch: UserMatch{Groups: []string{"sftp"}}, Command: "internal-sftp"},
+		{Command: "/usr/bin/menu"},
+	}
+	for username, expected := range map[string]string{
+		"alice": "/usr/bin/audit-shell",
+		"bob":   "internal-sftp",
+		"carol": "/usr/bin/menu",
+	} {
+		handler := &channelHandler{username: username, networkHandler: &networkHandler{config: config}}
+		command, ok := handler.getForcedCommand()
+		assert.True(t, ok, username)
+		assert.Equal(t, expected, command, username)
+	}
+
+	config.Execution.ForceCommand = config.Execution.ForceCommand[:2]
+	handler := &channelHandler{username: "carol", networkHandler: &networkHandler{config: config}}
+	_, ok := handler.getForcedCommand()
+	assert.False(t, ok)
+}
+
+// TestForceCommandRuleValidate tests if an empty forced command is rejected.
+func TestForceCommandRuleValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ForceCommandRule{Command: "/usr/bin/menu"}.Validate())
+	assert.Error(t, ForceCommandRule{UserMatch: UserMatch{Usernames: []string{"alice"}}}.Validate())
+}
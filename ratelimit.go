@@ -0,0 +1,121 @@
+package docker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterPruneInterval is the minimum time between two removals of idle limiters from the registry.
+const rateLimiterPruneInterval = time.Minute
+
+// rateLimiters is the process-wide registry of the token buckets limiting container launches, keyed by the limited
+// value and the bucket settings.
+var rateLimiters = struct {
+	lock      *sync.Mutex
+	limiters  map[string]*rateLimiter
+	lastPrune time.Time
+}{
+	lock:     &sync.Mutex{},
+	limiters: map[string]*rateLimiter{},
+}
+
+type rateLimiter struct {
+	limiter *rate.Limiter
+	// idle is the time after which the bucket is full again and can be forgotten.
+	idle     time.Duration
+	lastUsed time.Time
+}
+
+// rateLimitKey is a bucket a container launch takes a token from.
+type rateLimitKey struct {
+	name  string
+	key   string
+	limit RateLimit
+}
+
+// allowRates takes a token from each bucket. If a bucket is empty, no token is taken from any of them and the empty
+// bucket is returned.
+func allowRates(keys []rateLimitKey) (rateLimitKey, bool) {
+	now := time.Now()
+	rateLimiters.lock.Lock()
+	defer rateLimiters.lock.Unlock()
+	if now.Sub(rateLimiters.lastPrune) > rateLimiterPruneInterval {
+		for k, l := range rateLimiters.limiters {
+			if now.Sub(l.lastUsed) > l.idle {
+				delete(rateLimiters.limiters, k)
+			}
+		}
+		rateLimiters.lastPrune = now
+	}
+	var reservations []*rate.Reservation
+	for _, key := range keys {
+		limiter := getRateLimiter(key.key, key.limit, now)
+		if limiter == nil {
+			continue
+		}
+		reservation := limiter.ReserveN(now, 1)
+		if reservation.OK() && reservation.DelayFrom(now) == 0 {
+			reservations = append(reservations, reservation)
+			continue
+		}
+		// The tokens are put back in full since no other reservation can happen while the registry is locked.
+		reservation.CancelAt(now)
+		for _, taken := range reservations {
+			taken.CancelAt(now)
+		}
+		return key, false
+	}
+	return rateLimitKey{}, true
+}
+
+// getRateLimiter returns the bucket of the key, or nil if the limit is disabled. The caller must hold the registry
+// lock.
+func getRateLimiter(key string, config RateLimit, now time.Time) *rate.Limiter {
+	if config.PerMinute <= 0 {
+		return nil
+	}
+	burst := config.Burst
+	if burst == 0 {
+		burst = 1
+	}
+	key = fmt.Sprintf("%s\x00%f\x00%d", key, config.PerMinute, burst)
+	l, ok := rateLimiters.limiters[key]
+	if !ok {
+		perSecond := config.PerMinute / 60
+		l = &rateLimiter{
+			limiter: rate.NewLimiter(rate.Limit(perSecond), burst),
+			idle:    time.Duration(float64(burst) / perSecond * float64(time.Second)),
+		}
+		rateLimiters.limiters[key] = l
+	}
+	l.lastUsed = now
+	return l.limiter
+}
+
+// checkRateLimit returns an error if the client IP or the user launches containers faster than permitted. A token is
+// only taken if neither limit is exceeded, so the caller must check the other limits first.
+func (n *networkHandler) checkRateLimit() error {
+	config := n.config.RateLimit
+	limit, ok := allowRates(
+		[]rateLimitKey{
+			{name: "ip", key: "ip\x00" + n.client.IP.String(), limit: config.PerIP},
+			{name: "user", key: "user\x00" + n.username, limit: config.PerUser},
+		},
+	)
+	if ok {
+		return nil
+	}
+	err := log.UserMessage(
+		ERateLimited,
+		config.Message,
+		"the %s rate limit of %f containers per minute has been exceeded",
+		limit.name,
+		limit.limit.PerMinute,
+	).Label("limit", limit.name)
+	n.logger.Notice(err)
+	return err
+}
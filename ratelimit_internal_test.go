@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func allowRate(key string, limit RateLimit) bool {
+	_, ok := allowRates([]rateLimitKey{{key: key, limit: limit}})
+	return ok
+}
+
+// TestAllowRates tests if a bucket allows the burst and then rejects launches until it refills, and no token is taken
+// if another bucket is empty.
+func TestAllowRates(t *testing.T) {
+	t.Parallel()
+
+	// The keys are unique to this test since the limiters are shared by the whole process.
+	limit := RateLimit{PerMinute: 1, Burst: 3}
+	for i := 0; i < 3; i++ {
+		assert.True(t, allowRate("ratelimit-test-burst", limit))
+	}
+	assert.False(t, allowRate("ratelimit-test-burst", limit))
+
+	for i := 0; i < 5; i++ {
+		rejected, ok := allowRates(
+			[]rateLimitKey{
+				{name: "other", key: "ratelimit-test-other", limit: limit},
+				{name: "burst", key: "ratelimit-test-burst", limit: limit},
+			},
+		)
+		assert.False(t, ok)
+		assert.Equal(t, "burst", rejected.name)
+	}
+	// Other keys have their own bucket, which is still full.
+	for i := 0; i < 3; i++ {
+		assert.True(t, allowRate("ratelimit-test-other", limit))
+	}
+	assert.False(t, allowRate("ratelimit-test-other", limit))
+
+	// The burst defaults to 1.
+	assert.True(t, allowRate("ratelimit-test-default", RateLimit{PerMinute: 1}))
+	assert.False(t, allowRate("ratelimit-test-default", RateLimit{PerMinute: 1}))
+
+	// A changed limit starts a new bucket.
+	assert.True(t, allowRate("ratelimit-test-default", RateLimit{PerMinute: 2}))
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, allowRate("ratelimit-test-disabled", RateLimit{}))
+	}
+}
+
+// TestAdmitContainerRateLimit tests if the IP limit applies across users and the user limit across IPs, and tokens
+// are only used by containers that are admitted.
+func TestAdmitContainerRateLimit(t *testing.T) {
+	t.Parallel()
+
+	config := Config{}
+	config.RateLimit = RateLimitConfig{
+		PerIP:   RateLimit{PerMinute: 1, Burst: 2},
+		PerUser: RateLimit{PerMinute: 1, Burst: 2},
+	}
+	config.Limits.MaxContainersPerUser = 1
+	admit := func(username string, ip string) (*containerSlot, error) {
+		return newTestHandler(t, username, ip, config).admitContainer()
+	}
+
+	slot, err := admit("ratelimit-a", "203.0.113.1")
+	assert.NoError(t, err)
+	// Rejected by the concurrency limit before any token is taken.
+	_, err = admit("ratelimit-a", "203.0.113.1")
+	var typedErr log.Message
+	if assert.ErrorAs(t, err, &typedErr) {
+		assert.Equal(t, ELimitReached, typedErr.Code())
+	}
+	slot.release()
+
+	slot, err = admit("ratelimit-a", "203.0.113.2")
+	assert.NoError(t, err)
+	slot.release()
+	// Rejected by the user limit, which puts back the token of the IP.
+	_, err = admit("ratelimit-a", "203.0.113.1")
+	if assert.ErrorAs(t, err, &typedErr) {
+		assert.Equal(t, ERateLimited, typedErr.Code())
+		assert.Equal(t, "user", typedErr.Labels()["limit"])
+	}
+
+	slot, err = admit("ratelimit-b", "203.0.113.1")
+	assert.NoError(t, err)
+	slot.release()
+	_, err = admit("ratelimit-c", "203.0.113.1")
+	if assert.ErrorAs(t, err, &typedErr) {
+		assert.Equal(t, ERateLimited, typedErr.Code())
+		assert.Equal(t, "ip", typedErr.Labels()["limit"])
+	}
+}
@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// readinessInterval is the time between two attempts of a readiness check.
+const readinessInterval = time.Second
+
+// errNoHealthCheck is returned by health checks on containers without a health check. The check is not retried
+// since it cannot succeed.
+var errNoHealthCheck = errors.New("the container has no health check")
+
+// waitForReadiness performs the configured readiness checks one after the other. Each check is retried until it
+// succeeds or the readiness timeout has passed.
+func (n *networkHandler) waitForReadiness() error {
+	readiness := n.config.Execution.Readiness
+	for i, check := range readiness.Checks {
+		n.logger.Debug(log.NewMessage(MReadiness, "Waiting for readiness check %d (%s)...", i, check.Type))
+		err := n.waitForReadinessCheck(check)
+		if errors.Is(err, errNoHealthCheck) {
+			err := log.WrapUser(
+				err,
+				EConfigError,
+				readiness.FailureMessage,
+				"readiness check %d (%s) cannot succeed, the image has no HEALTHCHECK",
+				i,
+				check.Type,
+			)
+			n.logger.Error(err)
+			return err
+		}
+		if err != nil {
+			err := log.WrapUser(
+				err,
+				EFailedReadiness,
+				readiness.FailureMessage,
+				"readiness check %d (%s) did not succeed in time",
+				i,
+				check.Type,
+			)
+			n.logger.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *networkHandler) waitForReadinessCheck(check ReadinessCheck) error {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.Readiness)
+	defer cancelFunc()
+	for {
+		err := n.checkReadiness(ctx, check)
+		if err == nil || errors.Is(err, errNoHealthCheck) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(readinessInterval):
+		}
+	}
+}
+
+// checkReadiness performs a single attempt of the readiness check and returns an error if the container is not ready.
+func (n *networkHandler) checkReadiness(ctx context.Context, check ReadinessCheck) error {
+	switch check.Type {
+	case ReadinessCheckHealth:
+		state, err := n.container.inspect(ctx)
+		if err != nil {
+			return err
+		}
+		if state.State == nil || state.State.Health == nil {
+			return errNoHealthCheck
+		}
+		if state.State.Health.Status != "healthy" {
+			return fmt.Errorf("the container is %s", state.State.Health.Status)
+		}
+		return nil
+	case ReadinessCheckFile:
+		_, err := n.container.statPath(ctx, check.Path)
+		return err
+	case ReadinessCheckTCP:
+		host := check.Host
+		if host == "" {
+			var err error
+			if host, err = n.containerIP(ctx); err != nil {
+				return err
+			}
+		}
+		dialer := &net.Dialer{}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(check.Port)))
+		if err != nil {
+			return err
+		}
+		_ = conn.Close()
+		return nil
+	case ReadinessCheckCommand:
+		result, err := runCommand(ctx, n.container, check.Program, nil, "")
+		if err != nil {
+			return err
+		}
+		if result.exitStatus != 0 {
+			return fmt.Errorf("readiness command exited with status %d", result.exitStatus)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid readiness check type: %s", check.Type)
+	}
+}
+
+// containerIP returns the first IP address of the container on any of its networks.
+func (n *networkHandler) containerIP(ctx context.Context) (string, error) {
+	state, err := n.container.inspect(ctx)
+	if err != nil {
+		return "", err
+	}
+	if state.NetworkSettings != nil {
+		for _, endpoint := range state.NetworkSettings.Networks {
+			if endpoint != nil && endpoint.IPAddress != "" {
+				return endpoint.IPAddress, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("the container has no IP address")
+}
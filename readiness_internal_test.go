@@ -0,0 +1,128 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReadinessContainer reports the configured state when inspected.
+type fakeReadinessContainer struct {
+	dockerContainer
+
+	state types.ContainerJSON
+}
+
+func (f *fakeReadinessContainer) inspect(_ context.Context) (types.ContainerJSON, error) {
+	return f.state, nil
+}
+
+func newReadinessTestHandler(
+	t *testing.T,
+	cnt dockerContainer,
+	timeout time.Duration,
+	checks ...ReadinessCheck,
+) *networkHandler {
+	config := Config{}
+	config.Execution.Readiness.Checks = checks
+	config.Timeouts.Readiness = timeout
+	return &networkHandler{config: config, container: cnt, logger: log.NewTestLogger(t)}
+}
+
+func healthState(health *types.Health) types.ContainerJSON {
+	return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{State: &types.ContainerState{Health: health}}}
+}
+
+// TestReadinessHealth tests if the health check waits for the container to become healthy and fails immediately if
+// the image has no health check.
+func TestReadinessHealth(t *testing.T) {
+	t.Parallel()
+
+	check := ReadinessCheck{Type: ReadinessCheckHealth}
+
+	healthy := &fakeReadinessContainer{state: healthState(&types.Health{Status: types.Healthy})}
+	assert.NoError(t, newReadinessTestHandler(t, healthy, time.Second, check).waitForReadiness())
+
+	starting := &fakeReadinessContainer{state: healthState(&types.Health{Status: types.Starting})}
+	assert.Error(t, newReadinessTestHandler(t, starting, 10*time.Millisecond, check).waitForReadiness())
+
+	missing := &fakeReadinessContainer{state: healthState(nil)}
+	start := time.Now()
+	err := newReadinessTestHandler(t, missing, time.Minute, check).waitForReadiness()
+	assert.Less(t, int64(time.Since(start)), int64(readinessInterval))
+	var message log.Message
+	if assert.ErrorAs(t, err, &message) {
+		assert.Equal(t, EConfigError, message.Code())
+	}
+}
+
+// TestReadinessFile tests if the file check waits for the file to exist in the container.
+func TestReadinessFile(t *testing.T) {
+	t.Parallel()
+
+	cnt := newFakeFilesystemContainer()
+	exists := ReadinessCheck{Type: ReadinessCheckFile, Path: "/home/alice/a.txt"}
+	missing := ReadinessCheck{Type: ReadinessCheckFile, Path: "/run/ready"}
+
+	assert.NoError(t, newReadinessTestHandler(t, cnt, time.Second, exists).waitForReadiness())
+	err := newReadinessTestHandler(t, cnt, 10*time.Millisecond, exists, missing).waitForReadiness()
+	var message log.Message
+	if assert.ErrorAs(t, err, &message) {
+		assert.Equal(t, EFailedReadiness, message.Code())
+	}
+}
+
+// TestReadinessTCP tests if the TCP check connects to the configured host or the IP address of the container.
+func TestReadinessTCP(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	cnt := &fakeReadinessContainer{
+		state: types.ContainerJSON{
+			NetworkSettings: &types.NetworkSettings{
+				Networks: map[string]*network.EndpointSettings{"bridge": {IPAddress: "127.0.0.1"}},
+			},
+		},
+	}
+
+	host := ReadinessCheck{Type: ReadinessCheckTCP, Host: "127.0.0.1", Port: port}
+	assert.NoError(t, newReadinessTestHandler(t, cnt, time.Second, host).waitForReadiness())
+	containerIP := ReadinessCheck{Type: ReadinessCheckTCP, Port: port}
+	assert.NoError(t, newReadinessTestHandler(t, cnt, time.Second, containerIP).waitForReadiness())
+
+	assert.NoError(t, listener.Close())
+	assert.Error(t, newReadinessTestHandler(t, cnt, 10*time.Millisecond, host).waitForReadiness())
+}
+
+// TestReadinessCommand tests if the command check waits for the command to exit with status 0.
+func TestReadinessCommand(t *testing.T) {
+	t.Parallel()
+
+	cnt := &fakeSetupContainer{lock: &sync.Mutex{}, status: map[string]int{"false": 1}}
+
+	ready := ReadinessCheck{Type: ReadinessCheckCommand, Program: []string{"true"}}
+	assert.NoError(t, newReadinessTestHandler(t, cnt, time.Second, ready).waitForReadiness())
+	notReady := ReadinessCheck{Type: ReadinessCheckCommand, Program: []string{"false"}}
+	assert.Error(t, newReadinessTestHandler(t, cnt, 10*time.Millisecond, notReady).waitForReadiness())
+	assert.Len(t, cnt.commands, 2)
+}
@@ -0,0 +1,212 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/containerssh/log"
+)
+
+// sessionRecorder records the streams of a running program.
+type sessionRecorder interface {
+	// output returns a writer that records the output of the program.
+	output() io.Writer
+	// input returns a writer that records the input sent to the program.
+	input() io.Writer
+	// resize records a change in the terminal size.
+	resize(width uint, height uint)
+	// close finishes the recording.
+	close()
+}
+
+// newAsciicastRecorder creates a recorder that writes the session in the asciicast v2 format to the configured
+// directory. See https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md for the file format.
+func newAsciicastRecorder(
+	config RecordingConfig,
+	connectionID string,
+	channelID uint64,
+	width uint32,
+	height uint32,
+	env map[string]string,
+	logger log.Logger,
+) (sessionRecorder, error) {
+	start := time.Now()
+	header := asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Env:       map[string]string{},
+	}
+	if term, ok := env["TERM"]; ok {
+		header.Env["TERM"] = term
+	}
+	recorder := &asciicastRecorder{
+		lock:   &sync.Mutex{},
+		start:  start,
+		logger: logger,
+	}
+	var err error
+	baseName := filepath.Join(config.Directory, fmt.Sprintf("%s-%d", connectionID, channelID))
+	if recorder.outputFile, err = newAsciicastFile(baseName+".cast", header, config.MaxSize, "o"); err != nil {
+		return nil, err
+	}
+	if config.RecordStdin {
+		if recorder.inputFile, err = newAsciicastFile(baseName+"-stdin.cast", header, config.MaxSize, "i"); err != nil {
+			recorder.outputFile.close()
+			return nil, err
+		}
+	}
+	logger.Debug(log.NewMessage(MRecordingStart, "Recording session to %s.cast", baseName))
+	return recorder, nil
+}
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint32            `json:"width"`
+	Height    uint32            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+type asciicastRecorder struct {
+	lock       *sync.Mutex
+	start      time.Time
+	outputFile *asciicastFile
+	inputFile  *asciicastFile
+	logger     log.Logger
+}
+
+func (a *asciicastRecorder) output() io.Writer {
+	return &asciicastWriter{recorder: a, file: a.outputFile}
+}
+
+func (a *asciicastRecorder) input() io.Writer {
+	if a.inputFile == nil {
+		return ioutil.Discard
+	}
+	return &asciicastWriter{recorder: a, file: a.inputFile}
+}
+
+func (a *asciicastRecorder) resize(width uint, height uint) {
+	a.write(a.outputFile, "r", []byte(fmt.Sprintf("%dx%d", width, height)))
+}
+
+func (a *asciicastRecorder) close() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.outputFile.close()
+	if a.inputFile != nil {
+		a.inputFile.close()
+	}
+}
+
+func (a *asciicastRecorder) write(file *asciicastFile, eventType string, data []byte) {
+	if file == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := file.writeEvent(time.Since(a.start), eventType, data); err != nil {
+		a.logger.Notice(log.Wrap(err, EFailedRecording, "stopped recording to %s", file.name))
+	}
+}
+
+// asciicastWriter records every write as an event and never fails to not disrupt the session.
+type asciicastWriter struct {
+	recorder *asciicastRecorder
+	file     *asciicastFile
+}
+
+func (a *asciicastWriter) Write(p []byte) (int, error) {
+	a.recorder.write(a.file, a.file.eventType, p)
+	return len(p), nil
+}
+
+type asciicastFile struct {
+	name      string
+	fh        *os.File
+	maxSize   int64
+	written   int64
+	eventType string
+	// pending holds an incomplete UTF-8 sequence from the end of the previous write.
+	pending []byte
+	stopped bool
+}
+
+func newAsciicastFile(name string, header asciicastHeader, maxSize int64, eventType string) (*asciicastFile, error) {
+	fh, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, log.Wrap(err, EFailedRecording, "failed to create recording file %s", name)
+	}
+	file := &asciicastFile{
+		name:      name,
+		fh:        fh,
+		maxSize:   maxSize,
+		eventType: eventType,
+	}
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		_ = fh.Close()
+		return nil, err
+	}
+	if err := file.writeLine(headerData); err != nil {
+		_ = fh.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (a *asciicastFile) writeEvent(elapsed time.Duration, eventType string, data []byte) error {
+	if a.stopped {
+		return nil
+	}
+	data = append(a.pending, data...)
+	a.pending = nil
+	if eventType != "r" {
+		// Hold back an incomplete UTF-8 sequence at the end so multibyte characters are not split across events.
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					a.pending = append([]byte{}, data[i:]...)
+					data = data[:i]
+				}
+				break
+			}
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	line, err := json.Marshal([]interface{}{elapsed.Seconds(), eventType, string(data)})
+	if err != nil {
+		return err
+	}
+	return a.writeLine(line)
+}
+
+func (a *asciicastFile) writeLine(line []byte) error {
+	if a.maxSize > 0 && a.written+int64(len(line))+1 > a.maxSize {
+		a.stopped = true
+		return log.NewMessage(EFailedRecording, "recording size limit of %d bytes reached", a.maxSize)
+	}
+	n, err := a.fh.Write(append(line, '\n'))
+	a.written += int64(n)
+	if err != nil {
+		a.stopped = true
+		return log.Wrap(err, EFailedRecording, "failed to write recording")
+	}
+	return nil
+}
+
+func (a *asciicastFile) close() {
+	a.stopped = true
+	_ = a.fh.Close()
+}
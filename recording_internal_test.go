@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAsciicastEvents(t *testing.T, name string) [][]interface{} {
+	fh, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+	scanner := bufio.NewScanner(fh)
+	if !scanner.Scan() {
+		t.Fatal("recording has no header")
+	}
+	header := asciicastHeader{}
+	assert.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, 2, header.Version)
+	var events [][]interface{}
+	for scanner.Scan() {
+		var event []interface{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+// TestAsciicastSizeLimit tests if the recording stops before the file grows over the configured size.
+func TestAsciicastSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	config := RecordingConfig{Enable: true, Directory: dir, MaxSize: 200}
+	recorder, err := newAsciicastRecorder(config, "conn", 1, 80, 25, map[string]string{}, log.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := recorder.output()
+	for i := 0; i < 20; i++ {
+		n, err := output.Write([]byte("0123456789"))
+		// The recording must never disrupt the session.
+		assert.NoError(t, err)
+		assert.Equal(t, 10, n)
+	}
+	recorder.close()
+
+	stat, err := os.Stat(filepath.Join(dir, "conn-1.cast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.LessOrEqual(t, stat.Size(), int64(200))
+	events := readAsciicastEvents(t, filepath.Join(dir, "conn-1.cast"))
+	assert.NotEmpty(t, events)
+	assert.Less(t, len(events), 20)
+}
+
+// TestAsciicastUTF8Split tests if a multibyte character split across writes is recorded in a single event.
+func TestAsciicastUTF8Split(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	config := RecordingConfig{Enable: true, Directory: dir, RecordStdin: true}
+	recorder, err := newAsciicastRecorder(config, "conn", 2, 80, 25, map[string]string{}, log.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	character := []byte("é")
+	_, _ = recorder.output().Write([]byte{'a', character[0]})
+	_, _ = recorder.output().Write([]byte{character[1], 'b'})
+	_, _ = recorder.input().Write([]byte("ls\r"))
+	recorder.resize(100, 30)
+	recorder.close()
+
+	events := readAsciicastEvents(t, filepath.Join(dir, "conn-2.cast"))
+	assert.Len(t, events, 3)
+	assert.Equal(t, "o", events[0][1])
+	assert.Equal(t, "a", events[0][2])
+	assert.Equal(t, "éb", events[1][2])
+	assert.Equal(t, "r", events[2][1])
+	assert.Equal(t, "100x30", events[2][2])
+
+	inputEvents := readAsciicastEvents(t, filepath.Join(dir, "conn-2-stdin.cast"))
+	assert.Len(t, inputEvents, 1)
+	assert.Equal(t, "i", inputEvents[0][1])
+	assert.Equal(t, "ls\r", inputEvents[0][2])
+}
@@ -0,0 +1,212 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/containerssh/log"
+	"github.com/containerssh/metrics"
+)
+
+// executionModeLabel is the container label recording the execution mode the container was launched in.
+const executionModeLabel = "containerssh_execution_mode"
+
+// instanceLabel is the container label recording the ContainerSSH deployment the container was launched by.
+const instanceLabel = "containerssh_instance"
+
+// Recover discovers the containers left behind by a previous ContainerSSH process on the Docker host of the
+// configuration and adopts or removes them according to the recovery policy. Only containers labeled with the
+// configured instance name are considered, so the containers of other deployments sharing the Docker host are left
+// alone.
+//
+// New does not call Recover. The application embedding this library must call it once for each configured Docker
+// host after starting and before accepting connections, because the containers of active connections cannot be
+// told apart from orphaned ones.
+func Recover(
+	ctx context.Context,
+	config Config,
+	logger log.Logger,
+	backendRequestsMetric metrics.SimpleCounter,
+	backendFailuresMetric metrics.SimpleCounter,
+) error {
+	applySecurityPolicy(&config, logger)
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.Recovery.Policy == RecoveryPolicyNone {
+		return nil
+	}
+	factory := &dockerV20ClientFactory{
+		backendFailuresMetric: backendFailuresMetric,
+		backendRequestsMetric: backendRequestsMetric,
+	}
+	dockerClient, err := factory.get(ctx, config, logger, nil)
+	if err != nil {
+		return log.Wrap(err, EFailedRecovery, "failed to create Docker client")
+	}
+	return recoverContainers(ctx, config, logger, dockerClient)
+}
+
+// recoverContainers adopts or removes the containers of the instance found through the Docker client.
+func recoverContainers(ctx context.Context, config Config, logger log.Logger, dockerClient dockerClient) error {
+	containers, err := dockerClient.findContainers(
+		ctx,
+		map[string]string{
+			"containerssh_connection_id": "",
+			instanceLabel:                config.Recovery.Instance,
+		},
+	)
+	if err != nil {
+		return log.Wrap(err, EFailedRecovery, "failed to discover containers")
+	}
+
+	var remove []foundContainer
+	users := map[string][]foundContainer{}
+	for _, cnt := range containers {
+		if config.Recovery.Policy == RecoveryPolicyAdopt &&
+			cnt.labels[executionModeLabel] == string(ExecutionModeUser) &&
+			cnt.running {
+			username := cnt.labels["containerssh_username"]
+			users[username] = append(users[username], cnt)
+		} else {
+			remove = append(remove, cnt)
+		}
+	}
+	for username, found := range users {
+		if !adoptUserContainer(config, logger, dockerClient, username, found) {
+			remove = append(remove, found...)
+		}
+	}
+	removeFoundContainers(ctx, config, logger, dockerClient, remove)
+	return nil
+}
+
+// adoptUserContainer registers the shared container of a user and its auxiliary containers as if they had been
+// launched by this process. Returns false if the containers cannot be adopted.
+func adoptUserContainer(
+	config Config,
+	logger log.Logger,
+	dockerClient dockerClient,
+	username string,
+	containers []foundContainer,
+) bool {
+	isolatedNetwork := config.Execution.IsolatedNetwork
+	var main *foundContainer
+	var auxiliaryContainers []dockerContainer
+	var egressProxy dockerContainer
+	for i, cnt := range containers {
+		if role, ok := cnt.labels["containerssh_role"]; ok {
+			if role == egressProxyRole && isolatedNetwork.Scope == NetworkIsolationScopeUser {
+				// The egress proxy of a user network belongs to the network, not the connection.
+				egressProxy = cnt.container
+				continue
+			}
+			auxiliaryContainers = append(auxiliaryContainers, cnt.container)
+			continue
+		}
+		if main != nil {
+			// Only one shared container per user is expected, the others cannot be adopted.
+			return false
+		}
+		main = &containers[i]
+	}
+	if main == nil {
+		return false
+	}
+
+	owner := &networkHandler{
+		mutex:               &sync.Mutex{},
+		username:            username,
+		connectionID:        main.labels["containerssh_connection_id"],
+		config:              config,
+		container:           main.container,
+		dockerClient:        dockerClient,
+		logger:              logger.WithLabel("username", username),
+		labels:              main.labels,
+		done:                make(chan struct{}),
+		auxiliaryContainers: auxiliaryContainers,
+		channels:            map[uint64]*channelHandler{},
+	}
+	owner.client.IP = net.ParseIP(main.labels["containerssh_ip"])
+	if isolatedNetwork.Enable {
+		switch isolatedNetwork.Scope {
+		case NetworkIsolationScopeUser:
+			owner.network = userNetworkName(username)
+			owner.userNetwork = &userNetwork{ready: make(chan struct{}), egressProxy: egressProxy, refs: 1}
+			close(owner.userNetwork.ready)
+		default:
+			owner.network = "containerssh-" + owner.connectionID
+		}
+	}
+	entry := &userContainer{
+		ready:     make(chan struct{}),
+		container: main.container,
+		cleanup:   owner.cleanup,
+		refs:      1,
+	}
+	close(entry.ready)
+
+	userContainers.lock.Lock()
+	if _, ok := userContainers.entries[username]; ok {
+		userContainers.lock.Unlock()
+		return false
+	}
+	userContainers.entries[username] = entry
+	userContainers.lock.Unlock()
+	if owner.userNetwork != nil {
+		userNetworks.lock.Lock()
+		if existing, ok := userNetworks.entries[owner.network]; ok && existing.removed == nil {
+			existing.refs++
+			owner.userNetwork = existing
+			if egressProxy != nil {
+				owner.auxiliaryContainers = append(owner.auxiliaryContainers, egressProxy)
+			}
+		} else {
+			userNetworks.entries[owner.network] = owner.userNetwork
+		}
+		userNetworks.lock.Unlock()
+	}
+	owner.countAdoptedContainer()
+
+	owner.logger.Info(
+		log.NewMessage(MRecovery, "Adopted the shared container of user %s.", username).
+			Label("containerId", main.container.getID()),
+	)
+	// The container is removed after the adopt timeout unless the user reconnects.
+	entry.release(username, config.Timeouts.Adopt, owner.logger)
+	return true
+}
+
+// removeFoundContainers removes the containers that are not adopted, and the isolated networks of their connections.
+func removeFoundContainers(
+	ctx context.Context,
+	config Config,
+	logger log.Logger,
+	dockerClient dockerClient,
+	containers []foundContainer,
+) {
+	networks := map[string]struct{}{}
+	for _, cnt := range containers {
+		logger.Info(
+			log.NewMessage(MRecovery, "Removing container left behind by a previous ContainerSSH process...").
+				Label("containerId", cnt.container.getID()),
+		)
+		if err := cnt.container.remove(ctx); err != nil {
+			logger.Warning(log.Wrap(err, EFailedRecovery, "failed to remove container %s", cnt.container.getID()))
+		}
+		if config.Execution.IsolatedNetwork.Enable {
+			if config.Execution.IsolatedNetwork.Scope == NetworkIsolationScopeUser {
+				networks[userNetworkName(cnt.labels["containerssh_username"])] = struct{}{}
+			} else {
+				networks["containerssh-"+cnt.labels["containerssh_connection_id"]] = struct{}{}
+			}
+		}
+	}
+	for name := range networks {
+		// Networks still used by adopted containers cannot be removed and are kept.
+		if err := dockerClient.removeNetwork(ctx, name); err != nil {
+			logger.Debug(err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package docker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRecoveredContainer struct {
+	dockerContainer
+
+	id      string
+	removed bool
+}
+
+func (f *fakeRecoveredContainer) getID() string {
+	return f.id
+}
+
+func (f *fakeRecoveredContainer) remove(_ context.Context) error {
+	f.removed = true
+	return nil
+}
+
+// fakeRecoveryClient serves the containers matching the label filter like the Docker daemon.
+type fakeRecoveryClient struct {
+	dockerClient
+
+	containers []foundContainer
+	networks   []string
+}
+
+func (f *fakeRecoveryClient) findContainers(_ context.Context, labels map[string]string) ([]foundContainer, error) {
+	var result []foundContainer
+	for _, cnt := range f.containers {
+		matches := true
+		for key, value := range labels {
+			if actual, ok := cnt.labels[key]; !ok || (value != "" && actual != value) {
+				matches = false
+			}
+		}
+		if matches {
+			result = append(result, cnt)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeRecoveryClient) removeNetwork(_ context.Context, name string) error {
+	f.networks = append(f.networks, name)
+	return nil
+}
+
+// TestRecoverOnlyOwnInstance tests if only the containers labeled with the configured instance are removed.
+func TestRecoverOnlyOwnInstance(t *testing.T) {
+	t.Parallel()
+
+	own := &fakeRecoveredContainer{id: "own"}
+	other := &fakeRecoveredContainer{id: "other"}
+	unlabeled := &fakeRecoveredContainer{id: "unlabeled"}
+	client := &fakeRecoveryClient{
+		containers: []foundContainer{
+			{container: own, labels: map[string]string{"containerssh_connection_id": "a", instanceLabel: "eu-1"}},
+			{container: other, labels: map[string]string{"containerssh_connection_id": "b", instanceLabel: "eu-2"}},
+			{container: unlabeled, labels: map[string]string{"containerssh_connection_id": "c"}},
+		},
+	}
+	config := Config{}
+	config.Recovery = RecoveryConfig{Policy: RecoveryPolicyRemove, Instance: "eu-1"}
+	config.Execution.IsolatedNetwork.Enable = true
+	config.Timeouts.ContainerStop = time.Second
+
+	assert.NoError(t, recoverContainers(context.Background(), config, log.NewTestLogger(t), client))
+	assert.True(t, own.removed)
+	assert.False(t, other.removed)
+	assert.False(t, unlabeled.removed)
+	assert.Equal(t, []string{"containerssh-a"}, client.networks)
+}
+
+// TestRecoveryRequiresInstance tests if a recovery policy cannot be configured without an instance name.
+func TestRecoveryRequiresInstance(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, RecoveryConfig{Policy: RecoveryPolicyNone}.Validate())
+	assert.Error(t, RecoveryConfig{Policy: RecoveryPolicyRemove}.Validate())
+	assert.Error(t, RecoveryConfig{Policy: RecoveryPolicyAdopt}.Validate())
+	assert.NoError(t, RecoveryConfig{Policy: RecoveryPolicyRemove, Instance: "eu-1"}.Validate())
+}
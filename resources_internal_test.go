@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+// hardenedTestClient returns a client with the hardening preset applied to the launch configuration like New does.
+func hardenedTestClient(hostConfig *container.HostConfig) *dockerV20Client {
+	config := Config{}
+	config.Execution.Launch = LaunchConfig{ContainerConfig: &container.Config{Image: "test"}, HostConfig: hostConfig}
+	config.Execution.Hardening = HardeningConfig{Enable: true, PidsLimit: 256, Memory: 1 << 30, CPUs: 2, User: "1000"}
+	config.Execution.Hardening.apply(&config.Execution.Launch)
+	return &dockerV20Client{config: config}
+}
+
+// TestResourceProfileOverridesHardening tests if the resource profile takes precedence over the hardening preset
+// without producing conflicting CPU limits.
+func TestResourceProfileOverridesHardening(t *testing.T) {
+	t.Parallel()
+
+	d := hardenedTestClient(nil)
+	assert.Equal(t, int64(2e9), d.config.Execution.Launch.HostConfig.NanoCPUs)
+	d.useResources(ResourceProfile{Memory: 1 << 20, CPUQuota: 50000, CPUPeriod: 100000, PidsLimit: 64})
+	hostConfig := d.config.Execution.Launch.HostConfig
+	assert.Equal(t, int64(0), hostConfig.NanoCPUs)
+	assert.Equal(t, int64(50000), hostConfig.CPUQuota)
+	assert.Equal(t, int64(100000), hostConfig.CPUPeriod)
+	assert.Equal(t, int64(1<<20), hostConfig.Memory)
+	assert.Equal(t, int64(64), *hostConfig.PidsLimit)
+
+	// A profile only changing the period keeps the CPU limit of the preset as a quota.
+	d = hardenedTestClient(nil)
+	d.useResources(ResourceProfile{CPUPeriod: 50000})
+	hostConfig = d.config.Execution.Launch.HostConfig
+	assert.Equal(t, int64(0), hostConfig.NanoCPUs)
+	assert.Equal(t, int64(100000), hostConfig.CPUQuota)
+	assert.Equal(t, int64(50000), hostConfig.CPUPeriod)
+
+	// Fields the profile does not set keep the values of the preset.
+	d = hardenedTestClient(nil)
+	d.useResources(ResourceProfile{CPUShares: 512})
+	hostConfig = d.config.Execution.Launch.HostConfig
+	assert.Equal(t, int64(2e9), hostConfig.NanoCPUs)
+	assert.Equal(t, int64(0), hostConfig.CPUQuota)
+	assert.Equal(t, int64(1<<30), hostConfig.Memory)
+	assert.Equal(t, int64(256), *hostConfig.PidsLimit)
+	assert.Equal(t, int64(512), hostConfig.CPUShares)
+}
+
+// TestHardeningKeepsLaunchResources tests if the preset does not add a CPU limit next to a configured quota, and the
+// shared launch configuration is not modified.
+func TestHardeningKeepsLaunchResources(t *testing.T) {
+	t.Parallel()
+
+	original := &container.HostConfig{Resources: container.Resources{CPUQuota: 25000, Memory: 1 << 20}}
+	d := hardenedTestClient(original)
+	hostConfig := d.config.Execution.Launch.HostConfig
+	assert.Equal(t, int64(0), hostConfig.NanoCPUs)
+	assert.Equal(t, int64(25000), hostConfig.CPUQuota)
+	assert.Equal(t, int64(1<<20), hostConfig.Memory)
+
+	d.useResources(ResourceProfile{CPUPeriod: 50000, PidsLimit: 32})
+	hostConfig = d.config.Execution.Launch.HostConfig
+	assert.Equal(t, int64(0), hostConfig.NanoCPUs)
+	assert.Equal(t, int64(25000), hostConfig.CPUQuota)
+	assert.Equal(t, int64(50000), hostConfig.CPUPeriod)
+	assert.Nil(t, original.PidsLimit)
+	assert.Equal(t, int64(0), original.CPUPeriod)
+}
@@ -0,0 +1,439 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/containerssh/log"
+)
+
+// scpOptions are the options of an "scp -t" or "scp -f" request.
+type scpOptions struct {
+	// sink is true for uploads (-t) and false for downloads (-f).
+	sink bool
+	// recursive permits transferring directories (-r).
+	recursive bool
+	// targetDirectory requires the upload target to be a directory (-d).
+	targetDirectory bool
+	// preserveTimes sends modification times with downloads (-p).
+	preserveTimes bool
+	// paths are the target for uploads or the sources for downloads.
+	paths []string
+}
+
+// parseSCPProgram returns the SCP options if the program is the remote side of an SCP transfer.
+func parseSCPProgram(program []string) (scpOptions, bool) {
+	options := scpOptions{}
+	if len(program) < 2 || path.Base(program[0]) != "scp" {
+		return options, false
+	}
+	mode := false
+	args := program[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, flag := range arg[1:] {
+			switch flag {
+			case 't':
+				options.sink = true
+				mode = true
+			case 'f':
+				mode = true
+			case 'r':
+				options.recursive = true
+			case 'd':
+				options.targetDirectory = true
+			case 'p':
+				options.preserveTimes = true
+			case 'v', 'q':
+			default:
+				return options, false
+			}
+		}
+	}
+	options.paths = args
+	if !mode || len(options.paths) == 0 || (options.sink && len(options.paths) != 1) {
+		return options, false
+	}
+	return options, true
+}
+
+// newSCPExecution creates an execution that serves the remote side of the SCP protocol from ContainerSSH and
+// performs the file operations in the container. Relative paths are resolved against the home directory of the user.
+func newSCPExecution(
+	options scpOptions,
+	fs *containerFilesystem,
+	logger log.Logger,
+) dockerExecution {
+	return newBuiltinExecution(
+		func(ctx context.Context, stdin io.Reader, stdout io.Writer, _ io.Writer) int {
+			s := &scpSession{
+				ctx:     ctx,
+				options: options,
+				fs:      fs,
+				reader:  bufio.NewReader(stdin),
+				writer:  stdout,
+				logger:  logger,
+			}
+			var err error
+			if options.sink {
+				err = s.sink(options.paths[0])
+			} else {
+				// The client signals that it is ready to receive.
+				err = s.readAck()
+				for _, source := range options.paths {
+					if err != nil {
+						break
+					}
+					err = s.source(source)
+				}
+			}
+			if err != nil {
+				logger.Debug(log.Wrap(err, EFailedSCP, "SCP session ended with an error"))
+				return 1
+			}
+			if s.failed {
+				return 1
+			}
+			return 0
+		},
+	)
+}
+
+type scpSession struct {
+	ctx     context.Context
+	options scpOptions
+	fs      *containerFilesystem
+	reader  *bufio.Reader
+	writer  io.Writer
+	logger  log.Logger
+	// failed indicates that at least one file could not be transferred.
+	failed bool
+}
+
+// sink receives files from the client and writes them to the target in the container.
+func (s *scpSession) sink(target string) error {
+	targetIsDirectory := false
+	if info, err := s.fs.stat(s.ctx, target); err == nil {
+		targetIsDirectory = info.IsDir()
+	}
+	if s.options.targetDirectory && !targetIsDirectory {
+		return s.sendFatal(fmt.Errorf("%s: not a directory", target))
+	}
+	if err := s.sendAck(); err != nil {
+		return err
+	}
+
+	directories := []string{target}
+	// first is true until the first file or directory has been received and decides if the target is used as a
+	// directory.
+	first := true
+	for {
+		line, err := s.reader.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return fmt.Errorf("empty SCP command")
+		}
+		switch line[0] {
+		case 'T':
+			// Modification times cannot be set through the archive API.
+			if err := s.sendAck(); err != nil {
+				return err
+			}
+		case 'C', 'D':
+			mode, size, name, err := parseSCPFileHeader(line)
+			if err != nil {
+				return s.sendFatal(err)
+			}
+			current := directories[len(directories)-1]
+			filePath := path.Join(current, name)
+			if first && !targetIsDirectory {
+				filePath = target
+			}
+			first = false
+			if line[0] == 'D' {
+				if !s.options.recursive {
+					return s.sendFatal(fmt.Errorf("received directory without -r"))
+				}
+				if err := s.receiveDirectory(filePath, mode); err != nil {
+					return s.sendFatal(err)
+				}
+				directories = append(directories, filePath)
+				if err := s.sendAck(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.receiveFile(filePath, mode, size); err != nil {
+				return err
+			}
+		case 'E':
+			if len(directories) == 1 {
+				return s.sendFatal(fmt.Errorf("unexpected end of directory"))
+			}
+			directories = directories[:len(directories)-1]
+			if err := s.sendAck(); err != nil {
+				return err
+			}
+		case '\x01', '\x02':
+			s.failed = true
+			s.logger.Debug(log.NewMessage(EFailedSCP, "SCP client reported an error: %s", line[1:]))
+			if line[0] == '\x02' {
+				return nil
+			}
+		default:
+			return s.sendFatal(fmt.Errorf("unknown SCP command %q", line[0]))
+		}
+	}
+}
+
+func (s *scpSession) receiveDirectory(directory string, mode os.FileMode) error {
+	info, err := s.fs.stat(s.ctx, directory)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s: not a directory", directory)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return s.fs.mkdir(s.ctx, directory, mode)
+}
+
+func (s *scpSession) receiveFile(filePath string, mode os.FileMode, size int64) error {
+	if err := s.sendAck(); err != nil {
+		return err
+	}
+	content := io.LimitReader(s.reader, size)
+	writeErr := s.fs.write(s.ctx, filePath, mode, content, size)
+	// Consume the rest of the file on error so the protocol stays in sync.
+	if _, err := io.Copy(ioutil.Discard, content); err != nil {
+		return err
+	}
+	if err := s.readAck(); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return s.sendError(writeErr)
+	}
+	s.logger.Info(
+		log.NewMessage(MSCPTransfer, "SCP upload of %s (%d bytes)", filePath, size).
+			Label("path", filePath).
+			Label("size", size),
+	)
+	return s.sendAck()
+}
+
+// source sends the file or directory from the container to the client.
+func (s *scpSession) source(filePath string) error {
+	info, err := s.fs.stat(s.ctx, filePath)
+	if err != nil {
+		return s.sendError(err)
+	}
+	if info.IsDir() {
+		if !s.options.recursive {
+			return s.sendError(fmt.Errorf("%s: not a regular file", filePath))
+		}
+		return s.sendDirectory(filePath)
+	}
+	return s.sendFile(filePath)
+}
+
+// sendDirectory sends the directory tree from a single archive of the directory. Symbolic links are followed for
+// files only, so the tree cannot loop.
+func (s *scpSession) sendDirectory(directory string) error {
+	identity, err := s.fs.getIdentity(s.ctx)
+	if err != nil {
+		return s.sendError(err)
+	}
+	// open are the directories that have been sent to the client and not ended yet.
+	var open []string
+	err = s.fs.walk(s.ctx, directory, func(name string, file *containerFile, content io.Reader) (bool, error) {
+		for len(open) > 1 && !isBelowAny(name, open[len(open)-1:]) {
+			open = open[:len(open)-1]
+			if err := s.sendCommand("E\n"); err != nil {
+				return false, err
+			}
+		}
+		filePath := path.Join(directory, name)
+		switch {
+		case file.IsDir():
+			if !identity.permits(file, accessRead|accessExecute) {
+				return false, s.sendError(&os.PathError{Op: "open", Path: filePath, Err: os.ErrPermission})
+			}
+			if err := s.sendTimes(file); err != nil {
+				return false, err
+			}
+			if err := s.sendCommand(fmt.Sprintf("D%04o 0 %s\n", file.Mode().Perm(), file.Name())); err != nil {
+				return false, err
+			}
+			open = append(open, name)
+			return true, nil
+		case file.Mode().IsRegular():
+			if !identity.permits(file, accessRead) {
+				return false, s.sendError(&os.PathError{Op: "open", Path: filePath, Err: os.ErrPermission})
+			}
+			return false, s.sendContent(filePath, file, content)
+		case file.Mode()&os.ModeSymlink != 0:
+			return false, s.sendFile(filePath)
+		default:
+			return false, s.sendError(fmt.Errorf("%s: not a regular file", filePath))
+		}
+	})
+	if err != nil {
+		var pathError *os.PathError
+		if errors.As(err, &pathError) && len(open) == 0 {
+			return s.sendError(err)
+		}
+		return err
+	}
+	for range open {
+		if err := s.sendCommand("E\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *scpSession) sendFile(filePath string) error {
+	file, info, err := s.fs.read(s.ctx, filePath)
+	if err != nil {
+		return s.sendError(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return s.sendContent(filePath, info, file)
+}
+
+func (s *scpSession) sendContent(filePath string, info os.FileInfo, content io.Reader) error {
+	if err := s.sendTimes(info); err != nil {
+		return err
+	}
+	if err := s.sendCommand(
+		fmt.Sprintf("C%04o %d %s\n", info.Mode().Perm(), info.Size(), path.Base(filePath)),
+	); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(s.writer, content, info.Size()); err != nil {
+		return err
+	}
+	if err := s.sendCommand("\x00"); err != nil {
+		return err
+	}
+	s.logger.Info(
+		log.NewMessage(MSCPTransfer, "SCP download of %s (%d bytes)", filePath, info.Size()).
+			Label("path", filePath).
+			Label("size", info.Size()),
+	)
+	return nil
+}
+
+func (s *scpSession) sendTimes(info os.FileInfo) error {
+	if !s.options.preserveTimes {
+		return nil
+	}
+	modTime := info.ModTime().Unix()
+	return s.sendCommand(fmt.Sprintf("T%d 0 %d 0\n", modTime, modTime))
+}
+
+// sendCommand sends a protocol message to the client and waits for the acknowledgement.
+func (s *scpSession) sendCommand(command string) error {
+	if _, err := s.writer.Write([]byte(command)); err != nil {
+		return err
+	}
+	return s.readAck()
+}
+
+func (s *scpSession) readAck() error {
+	status, err := s.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch status {
+	case 0:
+		return nil
+	case 1, 2:
+		message, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("SCP client error: %s", strings.TrimSuffix(message, "\n"))
+	default:
+		return fmt.Errorf("invalid SCP acknowledgement %d", status)
+	}
+}
+
+func (s *scpSession) sendAck() error {
+	_, err := s.writer.Write([]byte{0})
+	return err
+}
+
+// sendError reports a failed file to the client and continues with the transfer.
+func (s *scpSession) sendError(err error) error {
+	s.failed = true
+	s.logger.Debug(log.Wrap(err, EFailedSCP, "SCP transfer failed"))
+	_, writeErr := s.writer.Write([]byte(fmt.Sprintf("\x01scp: %s\n", scpErrorMessage(err))))
+	return writeErr
+}
+
+// sendFatal reports an error to the client and ends the transfer.
+func (s *scpSession) sendFatal(err error) error {
+	s.failed = true
+	if _, writeErr := s.writer.Write([]byte(fmt.Sprintf("\x02scp: %s\n", scpErrorMessage(err)))); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+func scpErrorMessage(err error) string {
+	var pathError *os.PathError
+	if errors.As(err, &pathError) {
+		switch {
+		case os.IsNotExist(pathError):
+			return fmt.Sprintf("%s: No such file or directory", pathError.Path)
+		case os.IsPermission(pathError):
+			return fmt.Sprintf("%s: Permission denied", pathError.Path)
+		}
+	}
+	return strings.ReplaceAll(err.Error(), "\n", " ")
+}
+
+// parseSCPFileHeader parses a "C" or "D" line of the form "C0644 1234 name".
+func parseSCPFileHeader(line string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(line[1:], " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("invalid SCP header %q", line)
+	}
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid SCP file mode %q", parts[0])
+	}
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("invalid SCP file size %q", parts[1])
+	}
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("invalid SCP file name %q", name)
+	}
+	return os.FileMode(mode).Perm(), size, name, nil
+}
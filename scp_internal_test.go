@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseSCPProgram tests if only the remote side of SCP transfers is recognized.
+func TestParseSCPProgram(t *testing.T) {
+	t.Parallel()
+
+	options, ok := parseSCPProgram([]string{"scp", "-t", "--", "upload"})
+	assert.True(t, ok)
+	assert.Equal(t, scpOptions{sink: true, paths: []string{"upload"}}, options)
+
+	options, ok = parseSCPProgram([]string{"/usr/bin/scp", "-rpf", "a", "b"})
+	assert.True(t, ok)
+	assert.Equal(t, scpOptions{recursive: true, preserveTimes: true, paths: []string{"a", "b"}}, options)
+
+	options, ok = parseSCPProgram([]string{"scp", "-v", "-d", "-t", "dir"})
+	assert.True(t, ok)
+	assert.True(t, options.targetDirectory)
+
+	for _, program := range [][]string{
+		{"scp"},
+		{"scp", "file", "host:file"},
+		{"scp", "-t"},
+		{"scp", "-t", "a", "b"},
+		{"scp", "-t", "-S", "/bin/sh", "file"},
+		{"/bin/sh", "-c", "scp -t file"},
+		{"myscp", "-t", "file"},
+	} {
+		_, ok := parseSCPProgram(program)
+		assert.False(t, ok, strings.Join(program, " "))
+	}
+}
+
+// TestSCPDetectedBeforeShellWrapping tests if SCP requests are recognized on the command the client sent.
+func TestSCPDetectedBeforeShellWrapping(t *testing.T) {
+	t.Parallel()
+
+	argv, opaque := policyArgv("scp -t -- 'my file'")
+	assert.False(t, opaque)
+	options, ok := parseSCPProgram(argv)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"my file"}, options.paths)
+
+	_, opaque = policyArgv("scp -t file; rm -rf /")
+	assert.True(t, opaque)
+}
+
+func newTestSCPSession(t *testing.T, options scpOptions, output *bytes.Buffer) *scpSession {
+	return &scpSession{
+		ctx:     context.Background(),
+		options: options,
+		fs:      newTestFilesystem(newFakeFilesystemContainer()),
+		// The client acknowledges every message.
+		reader: bufio.NewReader(bytes.NewReader(make([]byte, 64))),
+		writer: output,
+		logger: log.NewTestLogger(t),
+	}
+}
+
+// TestSCPSendDirectory tests if a directory tree is sent from a single archive.
+func TestSCPSendDirectory(t *testing.T) {
+	t.Parallel()
+
+	output := &bytes.Buffer{}
+	s := newTestSCPSession(t, scpOptions{recursive: true}, output)
+	assert.NoError(t, s.source("docs"))
+	assert.Equal(t, "D0755 0 docs\nC0600 1 b\nb\x00E\n", output.String())
+	assert.False(t, s.failed)
+	// The file is sent from the archive of the directory.
+	assert.NotContains(t, s.fs.container.(*fakeFilesystemContainer).copied, "/home/alice/docs/b")
+}
+
+// TestSCPSendDirectoryLinks tests if symbolic links in a directory tree are subject to the path checks.
+func TestSCPSendDirectoryLinks(t *testing.T) {
+	t.Parallel()
+
+	output := &bytes.Buffer{}
+	s := newTestSCPSession(t, scpOptions{recursive: true}, output)
+	assert.NoError(t, s.source("/home/alice"))
+	result := output.String()
+	assert.True(t, strings.HasPrefix(result, "D0750 0 alice\nC0644 11 a.txt\nhello world\x00D0755 0 docs\nC0600 1 b\nb\x00E\n"))
+	assert.Contains(t, result, "\x01scp: /home/alice/escape: Permission denied\n")
+	assert.Contains(t, result, "C0644 11 self\nhello world\x00")
+	assert.NotContains(t, result, "secret")
+	assert.True(t, strings.HasSuffix(result, "E\n"))
+	assert.True(t, s.failed)
+}
@@ -0,0 +1,64 @@
+package docker
+
+import (
+	"context"
+	"strings"
+
+	"github.com/containerssh/log"
+)
+
+// runSetupCommands runs the configured setup commands in the container of the connection. The first failing command
+// aborts the setup. The username and the connection ID are passed in the CONTAINERSSH_USERNAME and
+// CONTAINERSSH_CONNECTION_ID environment variables so shell scripts can use them without quoting issues.
+func (n *networkHandler) runSetupCommands() error {
+	setup := n.config.Execution.Setup
+	if len(setup.Commands) == 0 {
+		return nil
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.Setup)
+	defer cancelFunc()
+	data := n.templateData()
+	for i, command := range setup.Commands {
+		program := make([]string, len(command.Program))
+		for j, arg := range command.Program {
+			rendered, err := renderTemplate("program", arg, data)
+			if err != nil {
+				return log.WrapUser(err, EFailedSetup, setup.FailureMessage, "failed to render setup command %d", i)
+			}
+			program[j] = rendered
+		}
+		env := map[string]string{}
+		for key, value := range command.Env {
+			env[key] = value
+		}
+		env["CONTAINERSSH_USERNAME"] = data.Username
+		env["CONTAINERSSH_CONNECTION_ID"] = data.ConnectionID
+		n.logger.Debug(log.NewMessage(MSetupCommand, "Running setup command %s...", strings.Join(program, " ")))
+		result, err := runCommand(ctx, n.container, program, env, "")
+		if err != nil {
+			return log.WrapUser(err, EFailedSetup, setup.FailureMessage, "failed to run setup command %d", i)
+		}
+		n.logger.Debug(
+			log.NewMessage(
+				MSetupCommand,
+				"Setup command %s exited with status %d.",
+				program[0],
+				result.exitStatus,
+			).
+				Label("stdout", string(result.stdout)).
+				Label("stderr", string(result.stderr)),
+		)
+		if result.exitStatus != 0 {
+			err := log.UserMessage(
+				EFailedSetup,
+				setup.FailureMessage,
+				"setup command %s exited with status %d",
+				program[0],
+				result.exitStatus,
+			)
+			n.logger.Error(err)
+			return err
+		}
+	}
+	return nil
+}
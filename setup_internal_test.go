@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSetupCommand struct {
+	program []string
+	env     map[string]string
+}
+
+// fakeSetupContainer records the commands run in it. Commands exit with the status configured for their program.
+type fakeSetupContainer struct {
+	dockerContainer
+
+	lock     *sync.Mutex
+	commands []fakeSetupCommand
+	status   map[string]int
+}
+
+func (f *fakeSetupContainer) createExec(
+	_ context.Context,
+	program []string,
+	env map[string]string,
+	_ bool,
+	_ string,
+) (dockerExecution, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.commands = append(f.commands, fakeSetupCommand{program: program, env: env})
+	return &fakeSetupExecution{status: f.status[program[0]]}, nil
+}
+
+type fakeSetupExecution struct {
+	dockerExecution
+
+	status int
+}
+
+func (f *fakeSetupExecution) run(
+	_ io.Reader,
+	_ io.Writer,
+	_ io.Writer,
+	_ func() error,
+	onExit func(exitStatus int),
+) {
+	onExit(f.status)
+}
+
+func newSetupTestHandler(t *testing.T, username string, commands ...SetupCommand) (*networkHandler, *fakeSetupContainer) {
+	cnt := &fakeSetupContainer{lock: &sync.Mutex{}, status: map[string]int{"false": 1}}
+	config := Config{}
+	config.Execution.Setup.Commands = commands
+	config.Timeouts.Setup = time.Second
+	return &networkHandler{
+		username:     username,
+		connectionID: "0123",
+		config:       config,
+		container:    cnt,
+		logger:       log.NewTestLogger(t),
+	}, cnt
+}
+
+// TestSetupCommandsEnvironment tests if a username with shell metacharacters is passed as a single argument and in
+// the environment, and cannot override the environment set by ContainerSSH.
+func TestSetupCommandsEnvironment(t *testing.T) {
+	t.Parallel()
+
+	username := "alice; touch /tmp/pwned"
+	n, cnt := newSetupTestHandler(
+		t,
+		username,
+		SetupCommand{Program: []string{"useradd", "{{ .Username }}"}},
+		SetupCommand{
+			Program: []string{"/bin/sh", "-c", `mkdir -p "/home/$CONTAINERSSH_USERNAME"`},
+			Env:     map[string]string{"LANG": "C", "CONTAINERSSH_USERNAME": "root"},
+		},
+	)
+
+	assert.NoError(t, n.runSetupCommands())
+
+	if !assert.Len(t, cnt.commands, 2) {
+		return
+	}
+	assert.Equal(t, []string{"useradd", username}, cnt.commands[0].program)
+	assert.Equal(t, []string{"/bin/sh", "-c", `mkdir -p "/home/$CONTAINERSSH_USERNAME"`}, cnt.commands[1].program)
+	assert.Equal(
+		t,
+		map[string]string{"LANG": "C", "CONTAINERSSH_USERNAME": username, "CONTAINERSSH_CONNECTION_ID": "0123"},
+		cnt.commands[1].env,
+	)
+	// The configuration is shared by all connections and must not be modified.
+	assert.Equal(t, "root", n.config.Execution.Setup.Commands[1].Env["CONTAINERSSH_USERNAME"])
+}
+
+// TestSetupCommandsFailure tests if the first failing command aborts the setup.
+func TestSetupCommandsFailure(t *testing.T) {
+	t.Parallel()
+
+	n, cnt := newSetupTestHandler(
+		t,
+		"alice",
+		SetupCommand{Program: []string{"false"}},
+		SetupCommand{Program: []string{"true"}},
+	)
+
+	assert.Error(t, n.runSetupCommands())
+	assert.Len(t, cnt.commands, 1)
+}
@@ -0,0 +1,319 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/containerssh/log"
+	"github.com/pkg/sftp"
+)
+
+// newSFTPExecution creates an execution that serves the SFTP protocol from ContainerSSH and performs the file
+// operations in the container.
+func newSFTPExecution(fs *containerFilesystem, logger log.Logger) dockerExecution {
+	return newBuiltinExecution(
+		func(ctx context.Context, stdin io.Reader, stdout io.Writer, _ io.Writer) int {
+			handler := &sftpHandler{
+				fs:     fs,
+				logger: logger,
+			}
+			server := sftp.NewRequestServer(
+				&sftpChannel{Reader: stdin, Writer: stdout},
+				sftp.Handlers{
+					FileGet:  handler,
+					FilePut:  handler,
+					FileCmd:  handler,
+					FileList: handler,
+				},
+			)
+			go func() {
+				<-ctx.Done()
+				_ = server.Close()
+			}()
+			if err := server.Serve(); err != nil && !errors.Is(err, io.EOF) {
+				logger.Debug(log.Wrap(err, EFailedSFTP, "SFTP session ended with an error"))
+				return 1
+			}
+			return 0
+		},
+	)
+}
+
+// sftpChannel adapts the SSH channel streams for the SFTP server. Closing the channel is handled by the execution.
+type sftpChannel struct {
+	io.Reader
+	io.Writer
+}
+
+func (s *sftpChannel) Close() error {
+	return nil
+}
+
+type sftpHandler struct {
+	fs     *containerFilesystem
+	logger log.Logger
+}
+
+// RealPath resolves relative paths against the home directory of the user, so clients start in the home directory.
+func (s *sftpHandler) RealPath(filePath string) string {
+	if path.IsAbs(filePath) {
+		return path.Clean(filePath)
+	}
+	home, err := s.fs.homeDirectory(context.Background())
+	if err != nil {
+		s.logger.Debug(log.Wrap(err, EFailedSFTP, "failed to determine the home directory"))
+		home = "/"
+	}
+	return path.Join(home, filePath)
+}
+
+func (s *sftpHandler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	s.logger.Debug(log.NewMessage(MSFTPOperation, "SFTP download of %s", request.Filepath).
+		Label("path", request.Filepath))
+	// The request context ends when the file is closed, so the stream can be reopened until then.
+	ctx := request.Context()
+	open := func() (io.ReadCloser, error) {
+		reader, _, err := s.fs.read(ctx, request.Filepath)
+		return reader, err
+	}
+	reader, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &sftpDownload{
+		lock:   &sync.Mutex{},
+		open:   open,
+		reader: reader,
+	}, nil
+}
+
+func (s *sftpHandler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	s.logger.Debug(log.NewMessage(MSFTPOperation, "SFTP upload of %s", request.Filepath).
+		Label("path", request.Filepath))
+	// The upload is staged on the ContainerSSH host, so uploads the user may not write are rejected before staging.
+	if err := s.fs.checkWritable(request.Context(), request.Filepath); err != nil {
+		return nil, err
+	}
+	tmp, err := newTemporaryFile()
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0644)
+	if request.AttrFlags().Permissions {
+		mode = request.Attributes().FileMode()
+	}
+	if !request.Pflags().Trunc {
+		// The client may be appending to or partially overwriting the file, start with the current content.
+		existing, info, err := s.fs.read(request.Context(), request.Filepath)
+		if err == nil {
+			mode = info.Mode()
+			err = s.fs.checkSize(request.Filepath, info.Size())
+			if err == nil {
+				_, err = io.Copy(tmp, existing)
+			}
+			_ = existing.Close()
+			if err != nil {
+				_ = tmp.Close()
+				return nil, err
+			}
+		} else if !os.IsNotExist(err) {
+			_ = tmp.Close()
+			return nil, err
+		}
+	}
+	return &sftpUpload{
+		temporaryFile: tmp,
+		fs:            s.fs,
+		path:          request.Filepath,
+		mode:          mode,
+	}, nil
+}
+
+func (s *sftpHandler) Filecmd(request *sftp.Request) error {
+	s.logger.Debug(log.NewMessage(MSFTPOperation, "SFTP %s of %s", request.Method, request.Filepath).
+		Label("path", request.Filepath))
+	ctx := request.Context()
+	switch request.Method {
+	case "Setstat":
+		if request.AttrFlags().Permissions {
+			return s.fs.chmod(ctx, request.Filepath, request.Attributes().FileMode())
+		}
+		// Changing sizes, owners and times is not supported and silently ignored so uploads preserving times work.
+		return nil
+	case "Rename":
+		return s.mapUnsupported(s.fs.rename(ctx, request.Filepath, request.Target))
+	case "Rmdir":
+		return s.mapUnsupported(s.fs.remove(ctx, request.Filepath, true))
+	case "Remove":
+		return s.mapUnsupported(s.fs.remove(ctx, request.Filepath, false))
+	case "Mkdir":
+		return s.fs.mkdir(ctx, request.Filepath, 0755)
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// mapUnsupported reports operations the container cannot perform because it lacks the necessary programs as
+// unsupported to the client.
+func (s *sftpHandler) mapUnsupported(err error) error {
+	if errors.Is(err, errProgramUnavailable) {
+		s.logger.Debug(log.Wrap(err, EFailedSFTP, "SFTP operation not supported by the container image"))
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	return err
+}
+
+func (s *sftpHandler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	ctx := request.Context()
+	switch request.Method {
+	case "List":
+		files, err := s.fs.list(ctx, request.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister(files), nil
+	case "Stat":
+		file, err := s.fs.stat(ctx, request.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister{file}, nil
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+type sftpLister []os.FileInfo
+
+func (s sftpLister) ListAt(target []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(s)) {
+		return 0, io.EOF
+	}
+	n := copy(target, s[offset:])
+	if n < len(target) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpUpload collects the uploaded data in a temporary file and copies it into the container when closed.
+type sftpUpload struct {
+	*temporaryFile
+	fs   *containerFilesystem
+	path string
+	mode os.FileMode
+}
+
+// WriteAt stages the data, rejecting writes beyond the maximum upload size.
+func (s *sftpUpload) WriteAt(p []byte, off int64) (int, error) {
+	if err := s.fs.checkSize(s.path, off+int64(len(p))); err != nil {
+		return 0, err
+	}
+	return s.temporaryFile.WriteAt(p, off)
+}
+
+func (s *sftpUpload) Close() error {
+	defer func() {
+		_ = s.temporaryFile.Close()
+	}()
+	size, err := s.temporaryFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := s.temporaryFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// The request context has ended when the file is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), s.fs.timeout)
+	defer cancel()
+	return s.fs.write(ctx, s.path, s.mode, s.temporaryFile, size)
+}
+
+// sftpDownloadWindow is the amount of recently read data kept to serve reads arriving out of order.
+const sftpDownloadWindow = 4 * 1024 * 1024
+
+// sftpDownload serves the reads of an SFTP client from a stream of the file in the container. Clients read
+// sequentially but keep several requests in flight, so reads may arrive slightly out of order. These are served from
+// a window of recently read data, only a read before the window reopens the stream.
+type sftpDownload struct {
+	lock   *sync.Mutex
+	open   func() (io.ReadCloser, error)
+	reader io.ReadCloser
+	// offset is the position of the reader in the file.
+	offset int64
+	// window is the data read last, ending at offset.
+	window []byte
+	// eof is true if the reader reached the end of the file.
+	eof bool
+}
+
+func (s *sftpDownload) ReadAt(p []byte, off int64) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if off < s.offset-int64(len(s.window)) {
+		if err := s.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	if end := off + int64(len(p)); end > s.offset && !s.eof {
+		if err := s.readTo(end); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	if start := s.offset - int64(len(s.window)); off >= start && off < s.offset {
+		n = copy(p, s.window[off-start:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readTo reads from the stream into the window until the offset or the end of the file is reached.
+func (s *sftpDownload) readTo(end int64) error {
+	buf := make([]byte, 32*1024)
+	for s.offset < end {
+		chunk := buf
+		if remaining := end - s.offset; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := io.ReadFull(s.reader, chunk)
+		s.offset += int64(n)
+		s.window = append(s.window, chunk[:n]...)
+		if len(s.window) > 2*sftpDownloadWindow {
+			s.window = append([]byte(nil), s.window[len(s.window)-sftpDownloadWindow:]...)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			s.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sftpDownload) reopen() error {
+	_ = s.reader.Close()
+	reader, err := s.open()
+	if err != nil {
+		return err
+	}
+	s.reader = reader
+	s.offset = 0
+	s.window = nil
+	s.eof = false
+	return nil
+}
+
+func (s *sftpDownload) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.reader.Close()
+}
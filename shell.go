@@ -0,0 +1,65 @@
+package docker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containerssh/log"
+)
+
+// detectedShells caches the result of the shell detection per image name across all connections.
+var detectedShells = &shellCache{
+	lock:   &sync.Mutex{},
+	shells: map[string]string{},
+}
+
+type shellCache struct {
+	lock   *sync.Mutex
+	shells map[string]string
+}
+
+func (s *shellCache) get(image string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	shell, ok := s.shells[image]
+	return shell, ok
+}
+
+func (s *shellCache) set(image string, shell string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.shells[image] = shell
+}
+
+// getShell returns the shell to run commands with. If shell detection is enabled it looks for the first shell
+// candidate present in the container and falls back to the configured shell if none is found.
+func (n *networkHandler) getShell(ctx context.Context) string {
+	parsing := n.config.Execution.ProgramParsing
+	shell := parsing.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	if !parsing.DetectShell || n.container == nil {
+		return shell
+	}
+	image := n.dockerClient.getImageName()
+	if detectedShell, ok := detectedShells.get(image); ok {
+		return detectedShell
+	}
+	n.logger.Debug(log.NewMessage(MShellDetect, "Detecting shell in image %s...", image))
+	for _, candidate := range parsing.ShellCandidates {
+		if _, err := n.container.statPath(ctx, candidate); err != nil {
+			continue
+		}
+		n.logger.Debug(log.NewMessage(MShellDetect, "Detected shell %s in image %s.", candidate, image))
+		detectedShells.set(image, candidate)
+		return candidate
+	}
+	n.logger.Notice(log.NewMessage(
+		EShellDetectFailed,
+		"None of the configured shell candidates exist in image %s, falling back to %s.",
+		image,
+		shell,
+	))
+	return shell
+}
@@ -0,0 +1,175 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSidecar struct {
+	dockerContainer
+
+	launch  LaunchConfig
+	idle    []string
+	role    string
+	started bool
+	removed bool
+}
+
+func (f *fakeSidecar) start(_ context.Context) error {
+	if f.launch.ContainerConfig.Image == "broken" {
+		return errors.New("failed to start")
+	}
+	f.started = true
+	return nil
+}
+
+func (f *fakeSidecar) remove(_ context.Context) error {
+	f.removed = true
+	return nil
+}
+
+// fakeSidecarClient records the configuration each sidecar is created from.
+type fakeSidecarClient struct {
+	dockerClient
+
+	lock     *sync.Mutex
+	config   Config
+	sidecars *[]*fakeSidecar
+}
+
+func (f *fakeSidecarClient) get(_ context.Context, config Config, _ log.Logger, _ *auditLogger) (dockerClient, error) {
+	return &fakeSidecarClient{lock: f.lock, config: config, sidecars: f.sidecars}, nil
+}
+
+func (f *fakeSidecarClient) createContainer(
+	_ context.Context,
+	labels map[string]string,
+	_ map[string]string,
+	_ *bool,
+	_ []string,
+) (dockerContainer, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	sidecar := &fakeSidecar{
+		launch: f.config.Execution.Launch,
+		idle:   f.config.Execution.IdleCommand,
+		role:   labels["containerssh_role"],
+	}
+	*f.sidecars = append(*f.sidecars, sidecar)
+	return sidecar, nil
+}
+
+type fakeUserContainer struct {
+	dockerContainer
+}
+
+func (f *fakeUserContainer) getID() string {
+	return "user-container"
+}
+
+func newSidecarTestHandler(t *testing.T, mode SidecarNetworkMode, sidecars ...LaunchConfig) (
+	*networkHandler,
+	*[]*fakeSidecar,
+) {
+	created := &[]*fakeSidecar{}
+	config := Config{}
+	config.Execution.ImagePullPolicy = ImagePullPolicyNever
+	config.Execution.Sidecars = sidecars
+	config.Execution.SidecarNetwork = mode
+	config.Timeouts.ContainerStop = time.Second
+	return &networkHandler{
+		config:              config,
+		container:           &fakeUserContainer{},
+		network:             "containerssh-0123",
+		dockerClientFactory: &fakeSidecarClient{lock: &sync.Mutex{}, sidecars: created},
+		logger:              log.NewTestLogger(t),
+		labels:              map[string]string{"containerssh_connection_id": "0123"},
+	}, created
+}
+
+// TestSidecarsShareUserContainerNetwork tests if the sidecars are started with their own command in the network
+// namespace of the user container without changing the configuration, and are removed with the user container.
+func TestSidecarsShareUserContainerNetwork(t *testing.T) {
+	t.Parallel()
+
+	database := LaunchConfig{
+		ContainerConfig: &container.Config{Image: "postgres", Cmd: []string{"postgres"}},
+		HostConfig:      &container.HostConfig{NetworkMode: "bridge", ReadonlyRootfs: true},
+		NetworkConfig:   &network.NetworkingConfig{},
+	}
+	cache := LaunchConfig{ContainerConfig: &container.Config{Image: "redis"}}
+	n, created := newSidecarTestHandler(t, SidecarNetworkModeContainer, database, cache)
+
+	assert.NoError(t, n.startSidecars(context.Background()))
+
+	if !assert.Len(t, *created, 2) {
+		return
+	}
+	first, second := (*created)[0], (*created)[1]
+	assert.Equal(t, "sidecar-0", first.role)
+	assert.Equal(t, []string{"postgres"}, first.idle)
+	assert.Equal(t, container.NetworkMode("container:user-container"), first.launch.HostConfig.NetworkMode)
+	assert.True(t, first.launch.HostConfig.ReadonlyRootfs)
+	assert.Nil(t, first.launch.NetworkConfig)
+	assert.True(t, first.started)
+	assert.Equal(t, "sidecar-1", second.role)
+	assert.Nil(t, second.idle)
+	assert.Equal(t, container.NetworkMode("container:user-container"), second.launch.HostConfig.NetworkMode)
+	assert.True(t, second.started)
+	// The configuration is shared by all connections and must not be modified.
+	assert.Equal(t, container.NetworkMode("bridge"), database.HostConfig.NetworkMode)
+	assert.NotNil(t, n.config.Execution.Sidecars[0].NetworkConfig)
+
+	n.removeAuxiliaryContainers()
+	assert.True(t, first.removed)
+	assert.True(t, second.removed)
+	assert.Empty(t, n.auxiliaryContainers)
+}
+
+// TestSidecarsIsolatedNetwork tests if the sidecars are attached to the isolated network of the connection in the
+// "isolated" mode.
+func TestSidecarsIsolatedNetwork(t *testing.T) {
+	t.Parallel()
+
+	n, created := newSidecarTestHandler(
+		t,
+		SidecarNetworkModeIsolated,
+		LaunchConfig{ContainerConfig: &container.Config{Image: "redis"}},
+	)
+
+	assert.NoError(t, n.startSidecars(context.Background()))
+
+	if !assert.Len(t, *created, 1) {
+		return
+	}
+	assert.Equal(t, container.NetworkMode("containerssh-0123"), (*created)[0].launch.HostConfig.NetworkMode)
+}
+
+// TestSidecarsStartFailure tests if the remaining sidecars are not launched after a sidecar failed to start, and
+// the failed sidecar is still removed with the user container.
+func TestSidecarsStartFailure(t *testing.T) {
+	t.Parallel()
+
+	n, created := newSidecarTestHandler(
+		t,
+		SidecarNetworkModeContainer,
+		LaunchConfig{ContainerConfig: &container.Config{Image: "broken"}},
+		LaunchConfig{ContainerConfig: &container.Config{Image: "redis"}},
+	)
+
+	assert.Error(t, n.startSidecars(context.Background()))
+
+	if !assert.Len(t, *created, 1) {
+		return
+	}
+	n.removeAuxiliaryContainers()
+	assert.True(t, (*created)[0].removed)
+}
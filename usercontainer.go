@@ -0,0 +1,190 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerssh/log"
+)
+
+// userContainers is the process-wide registry of the containers shared by the connections of a user in execution
+// mode "user", keyed by username.
+var userContainers = struct {
+	lock    *sync.Mutex
+	entries map[string]*userContainer
+}{
+	lock:    &sync.Mutex{},
+	entries: map[string]*userContainer{},
+}
+
+// userContainer is a container shared by the connections of a user. All fields are protected by the registry lock.
+type userContainer struct {
+	// ready is closed when the owner has finished launching the container.
+	ready chan struct{}
+	// err is the error that happened while launching the container.
+	err error
+	// container is the launched container.
+	container dockerContainer
+	// cleanup removes the container and the resources it depends on, such as its network and sidecars. It does not
+	// use the connection that launched the container, which may have closed long before.
+	cleanup func()
+	// refs is the number of connections using the container.
+	refs int
+	// linger removes the container after the last connection has closed.
+	linger *time.Timer
+}
+
+// acquireUserContainer uses the running container of the user or launches a new one if the user has none.
+func (n *networkHandler) acquireUserContainer(ctx context.Context) error {
+	userContainers.lock.Lock()
+	entry, ok := userContainers.entries[n.username]
+	if !ok {
+		entry = &userContainer{
+			ready: make(chan struct{}),
+		}
+		userContainers.entries[n.username] = entry
+	}
+	entry.refs++
+	if entry.linger != nil {
+		entry.linger.Stop()
+		entry.linger = nil
+	}
+	userContainers.lock.Unlock()
+	n.userContainer = entry
+
+	if ok {
+		n.logger.Debug(log.NewMessage(MUserContainer, "Joining the shared container of user %s...", n.username))
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return log.WrapUser(
+				ctx.Err(),
+				EFailedUserContainer,
+				UserMessageInitializeSSHSession,
+				"timeout while waiting for the shared container of user %s",
+				n.username,
+			)
+		}
+		userContainers.lock.Lock()
+		defer userContainers.lock.Unlock()
+		if entry.err != nil {
+			return entry.err
+		}
+		// The operations of the connection are logged and audited as its own, not as those of the owner.
+		n.container = entry.container.forConnection(n.logger, n.audit)
+		return nil
+	}
+
+	err := n.launchContainer(ctx)
+	var container dockerContainer
+	var cleanup func()
+	if err == nil {
+		container, cleanup, err = n.handOverUserContainer(ctx)
+	}
+	if err != nil {
+		// Remove what has been created before the launch failed.
+		n.cleanup()
+	}
+	userContainers.lock.Lock()
+	defer userContainers.lock.Unlock()
+	if err != nil {
+		entry.err = err
+		delete(userContainers.entries, n.username)
+	}
+	entry.container = container
+	entry.cleanup = cleanup
+	close(entry.ready)
+	return err
+}
+
+// handOverUserContainer moves the container of the connection and the resources it depends on to a handler with its
+// own Docker client and audit logger, and returns the view of the container for the registry entry and the function
+// removing them. The connection keeps using the container, but no longer owns it.
+func (n *networkHandler) handOverUserContainer(ctx context.Context) (dockerContainer, func(), error) {
+	audit := newAuditLogger(n.config.Audit, "", n.username, "", n.logger)
+	dockerClient, err := n.dockerClientFactory.get(ctx, n.config, n.logger, audit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Docker client (%w)", err)
+	}
+	owner := &networkHandler{
+		mutex:               &sync.Mutex{},
+		username:            n.username,
+		connectionID:        n.connectionID,
+		config:              n.config,
+		container:           n.container.forConnection(n.logger, audit),
+		dockerClient:        dockerClient,
+		logger:              n.logger,
+		audit:               audit,
+		labels:              n.labels,
+		network:             n.network,
+		userNetwork:         n.userNetwork,
+		auxiliaryContainers: n.auxiliaryContainers,
+		containerSlot:       n.containerSlot,
+	}
+	n.network = ""
+	n.userNetwork = nil
+	n.auxiliaryContainers = nil
+	n.containerSlot = nil
+	return owner.container, owner.cleanup, nil
+}
+
+// releaseUserContainer gives up the use of the shared container. The container is removed when the last connection
+// releases it and the linger timeout has passed.
+func (n *networkHandler) releaseUserContainer() {
+	// The resources of the container have been handed over to the registry entry or removed if the launch failed.
+	// What remains is what the connection created for itself, such as a network it created or joined before joining
+	// the container.
+	n.removeAuxiliaryContainers()
+	n.removeNetwork()
+	entry := n.userContainer
+	userContainers.lock.Lock()
+	if entry.err != nil {
+		entry.refs--
+		userContainers.lock.Unlock()
+		return
+	}
+	userContainers.lock.Unlock()
+	entry.release(n.username, n.config.Timeouts.Linger, n.logger)
+}
+
+// retain adds a reference to the container that is not bound to a connection, such as a detached session.
+func (u *userContainer) retain() {
+	userContainers.lock.Lock()
+	defer userContainers.lock.Unlock()
+	u.refs++
+	if u.linger != nil {
+		u.linger.Stop()
+		u.linger = nil
+	}
+}
+
+// release removes a reference to the container and removes the container after the linger timeout if it was the
+// last one.
+func (u *userContainer) release(username string, linger time.Duration, logger log.Logger) {
+	userContainers.lock.Lock()
+	u.refs--
+	if u.refs > 0 {
+		userContainers.lock.Unlock()
+		return
+	}
+	remove := func() {
+		userContainers.lock.Lock()
+		if u.refs > 0 || userContainers.entries[username] != u {
+			userContainers.lock.Unlock()
+			return
+		}
+		delete(userContainers.entries, username)
+		userContainers.lock.Unlock()
+		logger.Debug(log.NewMessage(MUserContainer, "Removing the shared container of user %s...", username))
+		u.cleanup()
+	}
+	if linger > 0 {
+		u.linger = time.AfterFunc(linger, remove)
+		userContainers.lock.Unlock()
+		return
+	}
+	userContainers.lock.Unlock()
+	remove()
+}
@@ -0,0 +1,206 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerssh/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// namedNetworkClient is a client of the fake Docker daemon that records the networks it removes.
+type namedNetworkClient struct {
+	*fakeNetworkClient
+
+	name    string
+	removed *[]string
+	audit   *auditLogger
+}
+
+func (c *namedNetworkClient) createContainer(
+	ctx context.Context,
+	labels map[string]string,
+	env map[string]string,
+	tty *bool,
+	cmd []string,
+) (dockerContainer, error) {
+	cnt, err := c.fakeNetworkClient.createContainer(ctx, labels, env, tty, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return cnt.forConnection(nil, c.audit), nil
+}
+
+func (c *namedNetworkClient) removeNetwork(ctx context.Context, name string) error {
+	c.lock.Lock()
+	*c.removed = append(*c.removed, c.name+": "+name)
+	c.lock.Unlock()
+	return c.fakeNetworkClient.removeNetwork(ctx, name)
+}
+
+// namedNetworkClientFactory hands out a separate client of the same fake Docker daemon for every call.
+type namedNetworkClientFactory struct {
+	daemon  *fakeNetworkClient
+	clients int
+	removed []string
+}
+
+func (f *namedNetworkClientFactory) get(
+	_ context.Context,
+	_ Config,
+	_ log.Logger,
+	audit *auditLogger,
+) (dockerClient, error) {
+	f.daemon.lock.Lock()
+	defer f.daemon.lock.Unlock()
+	f.clients++
+	return &namedNetworkClient{
+		fakeNetworkClient: f.daemon,
+		name:              fmt.Sprintf("client %d", f.clients),
+		removed:           &f.removed,
+		audit:             audit,
+	}, nil
+}
+
+// TestUserContainerRemovedAfterOwner tests if the shared container and the network it was launched in are removed
+// without the connection that launched it when that connection closes first.
+func TestUserContainerRemovedAfterOwner(t *testing.T) {
+	t.Parallel()
+
+	factory := &namedNetworkClientFactory{daemon: &fakeNetworkClient{lock: &sync.Mutex{}, networks: map[string]bool{}}}
+	config := Config{}
+	config.Execution.Mode = ExecutionModeUser
+	config.Execution.IsolatedNetwork = IsolatedNetworkConfig{Enable: true, Scope: NetworkIsolationScopeConnection}
+	config.Timeouts.ContainerStop = time.Second
+
+	var handlers []*networkHandler
+	for _, connectionID := range []string{"owner", "joiner"} {
+		n := &networkHandler{
+			mutex: &sync.Mutex{},
+			// The username is unique to this test since the registry is shared by the whole process.
+			username:            "user-container-owner-test",
+			connectionID:        connectionID,
+			config:              config,
+			dockerClientFactory: factory,
+			logger:              log.NewTestLogger(t),
+			labels:              map[string]string{},
+		}
+		assert.NoError(t, n.setupDockerClient(context.Background(), config))
+		assert.NoError(t, n.setupNetwork(context.Background()))
+		assert.NoError(t, n.acquireUserContainer(context.Background()))
+		handlers = append(handlers, n)
+	}
+	assert.Equal(t, 1, factory.daemon.created)
+
+	handlers[0].releaseUserContainer()
+	assert.Empty(t, factory.removed)
+	assert.Equal(t, 1, factory.daemon.containers)
+
+	handlers[1].releaseUserContainer()
+	assert.Equal(t, 0, factory.daemon.containers)
+	// The owner's network is removed through the client created for the registry entry (2) instead of the client of
+	// the closed connection (1).
+	assert.Equal(t, []string{"client 3: containerssh-joiner", "client 2: containerssh-owner"}, factory.removed)
+	assert.Empty(t, factory.daemon.networks)
+}
+
+type fakeAuditSink struct {
+	lock   *sync.Mutex
+	events []AuditEvent
+}
+
+func (f *fakeAuditSink) Write(event AuditEvent) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.events = append(f.events, event)
+	return nil
+}
+
+// TestUserContainerAuditsJoiners tests if the operations of every connection sharing a container are audited under
+// the connection that performed them.
+func TestUserContainerAuditsJoiners(t *testing.T) {
+	t.Parallel()
+
+	factory := &namedNetworkClientFactory{daemon: &fakeNetworkClient{lock: &sync.Mutex{}, networks: map[string]bool{}}}
+	sink := &fakeAuditSink{lock: &sync.Mutex{}}
+	config := Config{}
+	config.Execution.Mode = ExecutionModeUser
+	config.Audit = AuditConfig{Enable: true, Sink: sink}
+	config.Timeouts.ContainerStop = time.Second
+
+	var handlers []*networkHandler
+	for _, connectionID := range []string{"owner", "joiner"} {
+		logger := log.NewTestLogger(t)
+		n := &networkHandler{
+			mutex: &sync.Mutex{},
+			// The username is unique to this test since the registry is shared by the whole process.
+			username:            "user-container-audit-test",
+			connectionID:        connectionID,
+			config:              config,
+			dockerClientFactory: factory,
+			logger:              logger,
+			audit:               newAuditLogger(config.Audit, connectionID, "user-container-audit-test", "", logger),
+			labels:              map[string]string{},
+		}
+		assert.NoError(t, n.setupDockerClient(context.Background(), config))
+		assert.NoError(t, n.acquireUserContainer(context.Background()))
+		handlers = append(handlers, n)
+	}
+	assert.Equal(t, 1, factory.daemon.created)
+
+	for _, n := range handlers {
+		_, err := n.container.createExec(context.Background(), []string{"id", n.connectionID}, nil, false, "")
+		assert.NoError(t, err)
+	}
+	_, err := handlers[0].userContainer.container.createExec(context.Background(), []string{"id", "entry"}, nil, false, "")
+	assert.NoError(t, err)
+
+	if assert.Len(t, sink.events, 3) {
+		assert.Equal(t, "owner", sink.events[0].ConnectionID)
+		assert.Equal(t, []string{"id", "owner"}, sink.events[0].Program)
+		assert.Equal(t, "joiner", sink.events[1].ConnectionID)
+		assert.Equal(t, []string{"id", "joiner"}, sink.events[1].Program)
+		// The registry entry outlives the connections, so it does not audit under any of them.
+		assert.Equal(t, "", sink.events[2].ConnectionID)
+		assert.Equal(t, "user-container-audit-test", sink.events[2].Username)
+	}
+
+	handlers[0].releaseUserContainer()
+	handlers[1].releaseUserContainer()
+	assert.Equal(t, 0, factory.daemon.containers)
+}
+
+// TestContainerViewSharesState tests if a view of a container for another connection shares the shutdown state with
+// the container, but not the audit logger.
+func TestContainerViewSharesState(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewTestLogger(t)
+	config := AuditConfig{Enable: true, Sink: &fakeAuditSink{lock: &sync.Mutex{}}}
+	owner := newAuditLogger(config, "owner", "", "", logger)
+	joiner := newAuditLogger(config, "joiner", "", "", logger)
+	cnt := &dockerV20Container{
+		containerID: "shared",
+		logger:      logger,
+		audit:       owner,
+		lock:        &sync.Mutex{},
+		wg:          &sync.WaitGroup{},
+		state:       &containerState{},
+		removeLock:  &sync.Mutex{},
+	}
+
+	view := cnt.forConnection(logger, joiner).(*dockerV20Container)
+
+	assert.Equal(t, "shared", view.getID())
+	assert.Same(t, joiner, view.audit)
+	assert.Same(t, owner, cnt.audit)
+	assert.Same(t, cnt.state, view.state)
+	assert.Same(t, cnt.lock, view.lock)
+	assert.Same(t, cnt.wg, view.wg)
+	// A container that is being removed through one view is not removed again through another.
+	cnt.state.shuttingDown = true
+	assert.NoError(t, view.remove(context.Background()))
+}
@@ -0,0 +1,142 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/containerssh/log"
+)
+
+// userNamePattern matches the user and group names accepted by the user mapping, which are also safe to pass to
+// useradd and adduser.
+var userNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// maxUserNameLength is the maximum length of user and group names accepted by useradd.
+const maxUserNameLength = 32
+
+// mapUser returns the user the sessions of the connection run as. Returns an empty string if the container user
+// should be used.
+func (n *networkHandler) mapUser() (string, error) {
+	userMapping := n.config.Execution.UserMapping
+	var user string
+	switch userMapping.Mode {
+	case UserMappingModeUsername:
+		user = n.username
+	case UserMappingModeTemplate:
+		rendered, err := renderTemplate("user", userMapping.Template, n.templateData())
+		if err != nil {
+			return "", log.WrapUser(err, EFailedUserMapping, UserMessageInitializeSSHSession, "failed to render the user")
+		}
+		user = strings.TrimSpace(rendered)
+		if user == "" {
+			return "", log.UserMessage(
+				EFailedUserMapping,
+				UserMessageInitializeSSHSession,
+				"the user template rendered an empty user",
+			)
+		}
+	case UserMappingModeTable:
+		var ok bool
+		if user, ok = userMapping.Table[n.username]; !ok {
+			user = userMapping.Default
+		}
+		if user == "" {
+			return "", nil
+		}
+	default:
+		return "", nil
+	}
+	if err := validateMappedUser(user, userMapping.AllowRoot); err != nil {
+		return "", log.WrapUser(err, EFailedUserMapping, UserMessageInitializeSSHSession, "invalid mapped user %s", user)
+	}
+	return user, nil
+}
+
+// validateMappedUser checks if the user is a valid name or uid, optionally followed by a group name or gid, and does
+// not map to root unless allowed.
+func validateMappedUser(user string, allowRoot bool) error {
+	parts := strings.Split(user, ":")
+	if len(parts) > 2 {
+		return fmt.Errorf("invalid user %q", user)
+	}
+	for _, part := range parts {
+		if isNumericUser(part) && part != "" {
+			if _, err := strconv.ParseUint(part, 10, 32); err != nil {
+				return fmt.Errorf("invalid ID %q", part)
+			}
+			continue
+		}
+		if len(part) > maxUserNameLength || !userNamePattern.MatchString(part) {
+			return fmt.Errorf("invalid name %q", part)
+		}
+	}
+	if !allowRoot {
+		if uid, err := strconv.ParseUint(parts[0], 10, 32); parts[0] == "root" || (err == nil && uid == 0) {
+			return fmt.Errorf("mapping to root is not allowed")
+		}
+	}
+	return nil
+}
+
+// setupUserMapping determines the user of the connection. In execution mode "session" the user is set as the
+// container user, otherwise it is used for the execs.
+func (n *networkHandler) setupUserMapping() error {
+	user, err := n.mapUser()
+	if err != nil || user == "" {
+		return err
+	}
+	n.logger.Debug(log.NewMessage(MUserMapping, "Running sessions as user %s.", user).Label("user", user))
+	if n.config.Execution.Mode == ExecutionModeSession {
+		n.dockerClient.useUser(user)
+		return nil
+	}
+	n.execUser = user
+	return nil
+}
+
+// createUser creates the mapped user in the container if configured.
+func (n *networkHandler) createUser() error {
+	userMapping := n.config.Execution.UserMapping
+	if !userMapping.CreateUser || n.execUser == "" || isNumericUser(n.execUser) {
+		return nil
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), n.config.Timeouts.CommandStart)
+	defer cancelFunc()
+	n.logger.Debug(log.NewMessage(MUserMapping, "Creating user %s if needed...", n.execUser).Label("user", n.execUser))
+	result, err := runCommand(
+		ctx,
+		n.container,
+		userMapping.CreateUserCommand,
+		map[string]string{"CONTAINERSSH_USER": n.execUser},
+		"",
+	)
+	if err != nil {
+		return log.WrapUser(err, EFailedUserMapping, UserMessageInitializeSSHSession, "failed to create user %s", n.execUser)
+	}
+	if result.exitStatus != 0 {
+		err := log.UserMessage(
+			EFailedUserMapping,
+			UserMessageInitializeSSHSession,
+			"failed to create user %s, command exited with status %d",
+			n.execUser,
+			result.exitStatus,
+		).Label("stderr", string(result.stderr))
+		n.logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// isNumericUser checks if the user is a uid or uid:gid pair.
+func isNumericUser(user string) bool {
+	for _, r := range user {
+		if !unicode.IsDigit(r) && r != ':' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/containerssh/structutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func mapTestUser(userMapping UserMappingConfig, username string) (string, error) {
+	config := Config{}
+	config.Execution.UserMapping = userMapping
+	n := &networkHandler{username: username, connectionID: "0123456789abcdef", config: config}
+	return n.mapUser()
+}
+
+// TestMapUser tests if the user is derived from the SSH username according to the mode.
+func TestMapUser(t *testing.T) {
+	t.Parallel()
+
+	user, err := mapTestUser(UserMappingConfig{Mode: UserMappingModeUsername}, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", user)
+
+	user, err = mapTestUser(UserMappingConfig{Mode: UserMappingModeTemplate, Template: " u-{{ .Username }}:users "}, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "u-bob:users", user)
+
+	table := UserMappingConfig{
+		Mode:    UserMappingModeTable,
+		Table:   map[string]string{"alice": "1000:1000"},
+		Default: "guest",
+	}
+	user, err = mapTestUser(table, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "1000:1000", user)
+	user, err = mapTestUser(table, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "guest", user)
+
+	table.Default = ""
+	user, err = mapTestUser(table, "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "", user)
+
+	user, err = mapTestUser(UserMappingConfig{}, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "", user)
+}
+
+// TestMapUserRejectsRoot tests if SSH usernames that would run sessions as root are rejected unless allowed.
+func TestMapUserRejectsRoot(t *testing.T) {
+	t.Parallel()
+
+	for _, username := range []string{"root", "0", "0:0", "00", "0:users"} {
+		_, err := mapTestUser(UserMappingConfig{Mode: UserMappingModeUsername}, username)
+		assert.Error(t, err, username)
+
+		user, err := mapTestUser(UserMappingConfig{Mode: UserMappingModeUsername, AllowRoot: true}, username)
+		assert.NoError(t, err, username)
+		assert.Equal(t, username, user)
+	}
+	_, err := mapTestUser(UserMappingConfig{Mode: UserMappingModeTemplate, Template: "{{ .Username }}"}, "root")
+	assert.Error(t, err)
+	// The group alone does not grant root.
+	user, err := mapTestUser(UserMappingConfig{Mode: UserMappingModeUsername}, "1000:0")
+	assert.NoError(t, err)
+	assert.Equal(t, "1000:0", user)
+}
+
+// TestMapUserRejectsInvalidNames tests if usernames that are not valid user names or could be parsed as options are
+// rejected.
+func TestMapUserRejectsInvalidNames(t *testing.T) {
+	t.Parallel()
+
+	for _, username := range []string{
+		"-ou0",
+		"--help",
+		"Alice",
+		"alice smith",
+		"alice;id",
+		"alice:",
+		":alice",
+		"a:b:c",
+		"1alice",
+		"99999999999",
+		strings.Repeat("a", 33),
+	} {
+		_, err := mapTestUser(UserMappingConfig{Mode: UserMappingModeUsername, AllowRoot: true}, username)
+		assert.Error(t, err, username)
+	}
+	_, err := mapTestUser(UserMappingConfig{Mode: UserMappingModeTemplate, Template: "{{ \"\" }}"}, "alice")
+	assert.Error(t, err)
+	for _, username := range []string{"alice", "_svc", "svc-01", "alice:users", "1000", "1000:1000"} {
+		_, err := mapTestUser(UserMappingConfig{Mode: UserMappingModeUsername}, username)
+		assert.NoError(t, err, username)
+	}
+}
+
+// TestUserMappingValidate tests if the configured table users are checked.
+func TestUserMappingValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, UserMappingConfig{Mode: UserMappingModeTable, Table: map[string]string{"a": "alice"}}.Validate())
+	assert.Error(t, UserMappingConfig{Mode: UserMappingModeTable, Table: map[string]string{"a": "root"}}.Validate())
+	assert.Error(t, UserMappingConfig{Mode: UserMappingModeTable, Default: "0"}.Validate())
+	assert.NoError(t, UserMappingConfig{Mode: UserMappingModeTable, Default: "0", AllowRoot: true}.Validate())
+	assert.Error(t, UserMappingConfig{Mode: UserMappingModeTable, Default: "-ou0", AllowRoot: true}.Validate())
+
+	config := UserMappingConfig{}
+	structutils.Defaults(&config)
+	assert.Contains(t, config.CreateUserCommand[2], `useradd -m -- "$CONTAINERSSH_USER"`)
+	assert.Contains(t, config.CreateUserCommand[2], `adduser -D -- "$CONTAINERSSH_USER"`)
+}